@@ -19,11 +19,19 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Service_Decode_FullMethodName     = "/pb.Service/Decode"
-	Service_Encode_FullMethodName     = "/pb.Service/Encode"
-	Service_EncodeByID_FullMethodName = "/pb.Service/EncodeByID"
-	Service_History_FullMethodName    = "/pb.Service/History"
-	Service_Delete_FullMethodName     = "/pb.Service/Delete"
+	Service_Decode_FullMethodName      = "/pb.Service/Decode"
+	Service_Encode_FullMethodName      = "/pb.Service/Encode"
+	Service_EncodeByID_FullMethodName  = "/pb.Service/EncodeByID"
+	Service_EncodeBatch_FullMethodName = "/pb.Service/EncodeBatch"
+	Service_History_FullMethodName     = "/pb.Service/History"
+	Service_Delete_FullMethodName      = "/pb.Service/Delete"
+	Service_Restore_FullMethodName     = "/pb.Service/Restore"
+	Service_LinkStats_FullMethodName   = "/pb.Service/LinkStats"
+	Service_Stats_FullMethodName       = "/pb.Service/Stats"
+	Service_Tags_FullMethodName        = "/pb.Service/Tags"
+	Service_Update_FullMethodName      = "/pb.Service/Update"
+	Service_Revisions_FullMethodName   = "/pb.Service/Revisions"
+	Service_Rollback_FullMethodName    = "/pb.Service/Rollback"
 )
 
 // ServiceClient is the client API for Service service.
@@ -33,8 +41,16 @@ type ServiceClient interface {
 	Decode(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error)
 	Encode(ctx context.Context, in *EncodeRequest, opts ...grpc.CallOption) (*EncodeResponse, error)
 	EncodeByID(ctx context.Context, in *EncodeByIDRequest, opts ...grpc.CallOption) (*EncodeByIDResponse, error)
+	EncodeBatch(ctx context.Context, in *EncodeBatchRequest, opts ...grpc.CallOption) (*EncodeBatchResponse, error)
 	History(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error)
+	LinkStats(ctx context.Context, in *LinkStatsRequest, opts ...grpc.CallOption) (*LinkStatsResponse, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	Tags(ctx context.Context, in *TagsRequest, opts ...grpc.CallOption) (*TagsResponse, error)
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	Revisions(ctx context.Context, in *RevisionsRequest, opts ...grpc.CallOption) (*RevisionsResponse, error)
+	Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*RollbackResponse, error)
 }
 
 type serviceClient struct {
@@ -72,6 +88,15 @@ func (c *serviceClient) EncodeByID(ctx context.Context, in *EncodeByIDRequest, o
 	return out, nil
 }
 
+func (c *serviceClient) EncodeBatch(ctx context.Context, in *EncodeBatchRequest, opts ...grpc.CallOption) (*EncodeBatchResponse, error) {
+	out := new(EncodeBatchResponse)
+	err := c.cc.Invoke(ctx, Service_EncodeBatch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *serviceClient) History(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*GetHistoryResponse, error) {
 	out := new(GetHistoryResponse)
 	err := c.cc.Invoke(ctx, Service_History_FullMethodName, in, out, opts...)
@@ -90,6 +115,69 @@ func (c *serviceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...g
 	return out, nil
 }
 
+func (c *serviceClient) Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (*RestoreResponse, error) {
+	out := new(RestoreResponse)
+	err := c.cc.Invoke(ctx, Service_Restore_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) LinkStats(ctx context.Context, in *LinkStatsRequest, opts ...grpc.CallOption) (*LinkStatsResponse, error) {
+	out := new(LinkStatsResponse)
+	err := c.cc.Invoke(ctx, Service_LinkStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, Service_Stats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Tags(ctx context.Context, in *TagsRequest, opts ...grpc.CallOption) (*TagsResponse, error) {
+	out := new(TagsResponse)
+	err := c.cc.Invoke(ctx, Service_Tags_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, Service_Update_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Revisions(ctx context.Context, in *RevisionsRequest, opts ...grpc.CallOption) (*RevisionsResponse, error) {
+	out := new(RevisionsResponse)
+	err := c.cc.Invoke(ctx, Service_Revisions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*RollbackResponse, error) {
+	out := new(RollbackResponse)
+	err := c.cc.Invoke(ctx, Service_Rollback_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ServiceServer is the server API for Service service.
 // All implementations must embed UnimplementedServiceServer
 // for forward compatibility
@@ -97,8 +185,16 @@ type ServiceServer interface {
 	Decode(context.Context, *DecodeRequest) (*DecodeResponse, error)
 	Encode(context.Context, *EncodeRequest) (*EncodeResponse, error)
 	EncodeByID(context.Context, *EncodeByIDRequest) (*EncodeByIDResponse, error)
+	EncodeBatch(context.Context, *EncodeBatchRequest) (*EncodeBatchResponse, error)
 	History(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error)
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	Restore(context.Context, *RestoreRequest) (*RestoreResponse, error)
+	LinkStats(context.Context, *LinkStatsRequest) (*LinkStatsResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	Tags(context.Context, *TagsRequest) (*TagsResponse, error)
+	Update(context.Context, *UpdateRequest) (*UpdateResponse, error)
+	Revisions(context.Context, *RevisionsRequest) (*RevisionsResponse, error)
+	Rollback(context.Context, *RollbackRequest) (*RollbackResponse, error)
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -115,12 +211,36 @@ func (UnimplementedServiceServer) Encode(context.Context, *EncodeRequest) (*Enco
 func (UnimplementedServiceServer) EncodeByID(context.Context, *EncodeByIDRequest) (*EncodeByIDResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method EncodeByID not implemented")
 }
+func (UnimplementedServiceServer) EncodeBatch(context.Context, *EncodeBatchRequest) (*EncodeBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EncodeBatch not implemented")
+}
 func (UnimplementedServiceServer) History(context.Context, *GetHistoryRequest) (*GetHistoryResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
 }
 func (UnimplementedServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedServiceServer) Restore(context.Context, *RestoreRequest) (*RestoreResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedServiceServer) LinkStats(context.Context, *LinkStatsRequest) (*LinkStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LinkStats not implemented")
+}
+func (UnimplementedServiceServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedServiceServer) Tags(context.Context, *TagsRequest) (*TagsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Tags not implemented")
+}
+func (UnimplementedServiceServer) Update(context.Context, *UpdateRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedServiceServer) Revisions(context.Context, *RevisionsRequest) (*RevisionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Revisions not implemented")
+}
+func (UnimplementedServiceServer) Rollback(context.Context, *RollbackRequest) (*RollbackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rollback not implemented")
+}
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 
 // UnsafeServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -188,6 +308,24 @@ func _Service_EncodeByID_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_EncodeBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncodeBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).EncodeBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_EncodeBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).EncodeBatch(ctx, req.(*EncodeBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Service_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetHistoryRequest)
 	if err := dec(in); err != nil {
@@ -224,6 +362,132 @@ func _Service_Delete_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_Restore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Restore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Restore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Restore(ctx, req.(*RestoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_LinkStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).LinkStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_LinkStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).LinkStats(ctx, req.(*LinkStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Tags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TagsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Tags(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Tags_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Tags(ctx, req.(*TagsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Revisions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevisionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Revisions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Revisions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Revisions(ctx, req.(*RevisionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_Rollback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).Rollback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Service_Rollback_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).Rollback(ctx, req.(*RollbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Service_ServiceDesc is the grpc.ServiceDesc for Service service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -243,6 +507,10 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "EncodeByID",
 			Handler:    _Service_EncodeByID_Handler,
 		},
+		{
+			MethodName: "EncodeBatch",
+			Handler:    _Service_EncodeBatch_Handler,
+		},
 		{
 			MethodName: "History",
 			Handler:    _Service_History_Handler,
@@ -251,6 +519,34 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _Service_Delete_Handler,
 		},
+		{
+			MethodName: "Restore",
+			Handler:    _Service_Restore_Handler,
+		},
+		{
+			MethodName: "LinkStats",
+			Handler:    _Service_LinkStats_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _Service_Stats_Handler,
+		},
+		{
+			MethodName: "Tags",
+			Handler:    _Service_Tags_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Service_Update_Handler,
+		},
+		{
+			MethodName: "Revisions",
+			Handler:    _Service_Revisions_Handler,
+		},
+		{
+			MethodName: "Rollback",
+			Handler:    _Service_Rollback_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/shortener.proto",
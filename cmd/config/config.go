@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"flag"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Options represents the configuration options for the application.
@@ -12,20 +14,49 @@ import (
 // - BaseAddr: base address
 // - FilePath: storage path
 // - Database: database DSN
+// - BoltPath: bbolt storage path
 // - Secure: enable HTTPS
 // - CfgFile: config file
 // - TrustedNet: trusted subnet
+// - TrustedProxies: comma-separated subnets of the proxies whose X-Real-IP header is trusted
+// - CodeGen: short code generator (random, counter or words)
+// - CodeLength: length of random short codes
+// - CodeAlphabet: alphabet of random short codes
+// - CacheSize: maximal number of cached short codes, 0 (the default) disables the cache (single instance only)
+// - CacheTTL: lifetime of a cached URI
+// - CacheNegativeTTL: lifetime of a cached miss
+// - RestorePeriod: time after the deletion during which a deleted link can be restored
+// - AnonymousLifetime: lifetime of an anonymous user (and of its cookie)
+// - JWTSecret: secret of the HS256 key signing the tokens
+// - JWTKeysFile: file of the keys signing the tokens (see auth.LoadKeyFile), it takes precedence over JWTSecret
 var Options struct {
-	HostAddr   string
-	BaseAddr   string
-	FilePath   string
-	Database   string
-	Secure     bool
-	CfgFile    string
-	TrustedNet string
+	HostAddr          string
+	BaseAddr          string
+	FilePath          string
+	Database          string
+	BoltPath          string
+	Secure            bool
+	CfgFile           string
+	TrustedNet        string
+	TrustedProxies    string
+	CodeGen           string
+	CodeLength        int
+	CodeAlphabet      string
+	CacheSize         int
+	CacheTTL          time.Duration
+	CacheNegativeTTL  time.Duration
+	RestorePeriod     time.Duration
+	AnonymousLifetime time.Duration
+	JWTSecret         string
+	JWTKeysFile       string
 }
 
-// Init initializes the application by calling the initFlags and initEnv functions.
+// explicit contains the names of the flags of the options set by the command line or the environment.
+// The config file sets only the other options, so its values take precedence over the defaults of the flags,
+// but not over the flags and the environment variables.
+var explicit = make(map[string]bool)
+
+// Init initializes the application by calling the initFlags, initEnv and initFile functions.
 func Init() {
 	initFlags()
 	initEnv()
@@ -38,33 +69,116 @@ func initFlags() {
 	flag.StringVar(&Options.BaseAddr, "b", "http://localhost:8080", "base address")
 	flag.StringVar(&Options.FilePath, "f", "/tmp/short-url-db.json", "storage path")
 	flag.StringVar(&Options.Database, "d", "", "database dsn")
+	flag.StringVar(&Options.BoltPath, "bolt", "", "bbolt storage path")
 	flag.BoolVar(&Options.Secure, "s", false, "enable HTTPS")
 	flag.StringVar(&Options.CfgFile, "c", "", "config file")
 	flag.StringVar(&Options.TrustedNet, "t", "", "trusted subnet")
+	flag.StringVar(&Options.TrustedProxies, "trusted-proxies", "", "comma-separated subnets of the trusted proxies")
+	flag.StringVar(&Options.CodeGen, "code-gen", "random", "short code generator: random, counter or words")
+	flag.IntVar(&Options.CodeLength, "code-len", 8, "length of random short codes")
+	flag.StringVar(&Options.CodeAlphabet, "code-alphabet", "", "alphabet of random short codes")
+	flag.IntVar(&Options.CacheSize, "cache-size", 0, "maximal number of cached short codes, 0 disables the cache (single instance only)")
+	flag.DurationVar(&Options.CacheTTL, "cache-ttl", time.Minute, "lifetime of a cached URI")
+	flag.DurationVar(&Options.CacheNegativeTTL, "cache-negative-ttl", 10*time.Second, "lifetime of a cached miss")
+	flag.DurationVar(&Options.RestorePeriod, "restore-period", 7*24*time.Hour, "time after the deletion during which a link can be restored")
+	flag.DurationVar(&Options.AnonymousLifetime, "anonymous-lifetime", 30*24*time.Hour, "lifetime of an anonymous user")
+	flag.StringVar(&Options.JWTSecret, "jwt-secret", "", "secret of the HS256 key signing the tokens")
+	flag.StringVar(&Options.JWTKeysFile, "jwt-keys", "", "file of the keys signing the tokens")
 	flag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
 }
 
 func initEnv() {
 	if envHostAddr := os.Getenv("SERVER_ADDRESS"); envHostAddr != "" {
 		Options.HostAddr = envHostAddr
+		explicit["a"] = true
 	}
 	if envBaseAddr := os.Getenv("BASE_URL"); envBaseAddr != "" {
 		Options.BaseAddr = envBaseAddr
+		explicit["b"] = true
 	}
 	if envFilePath := os.Getenv("FILE_STORAGE_PATH"); envFilePath != "" {
 		Options.FilePath = envFilePath
+		explicit["f"] = true
 	}
 	if envDatabase := os.Getenv("DATABASE_DSN"); envDatabase != "" {
 		Options.Database = envDatabase
+		explicit["d"] = true
+	}
+	if envBoltPath := os.Getenv("BOLT_PATH"); envBoltPath != "" {
+		Options.BoltPath = envBoltPath
+		explicit["bolt"] = true
 	}
 	if envSecure := os.Getenv("ENABLE_HTTPS"); envSecure != "" {
 		Options.Secure = true
+		explicit["s"] = true
 	}
 	if envCfgFile := os.Getenv("CONFIG"); envCfgFile != "" {
 		Options.CfgFile = envCfgFile
+		explicit["c"] = true
 	}
 	if envTrustNet := os.Getenv("TRUSTED_SUBNET"); envTrustNet != "" {
 		Options.TrustedNet = envTrustNet
+		explicit["t"] = true
+	}
+	if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+		Options.TrustedProxies = envTrustedProxies
+		explicit["trusted-proxies"] = true
+	}
+	if envCodeGen := os.Getenv("CODE_GENERATOR"); envCodeGen != "" {
+		Options.CodeGen = envCodeGen
+		explicit["code-gen"] = true
+	}
+	if envCodeLength := os.Getenv("CODE_LENGTH"); envCodeLength != "" {
+		if length, err := strconv.Atoi(envCodeLength); err == nil {
+			Options.CodeLength = length
+			explicit["code-len"] = true
+		}
+	}
+	if envCodeAlphabet := os.Getenv("CODE_ALPHABET"); envCodeAlphabet != "" {
+		Options.CodeAlphabet = envCodeAlphabet
+		explicit["code-alphabet"] = true
+	}
+	if envCacheSize := os.Getenv("CACHE_SIZE"); envCacheSize != "" {
+		if size, err := strconv.Atoi(envCacheSize); err == nil {
+			Options.CacheSize = size
+			explicit["cache-size"] = true
+		}
+	}
+	if envCacheTTL := os.Getenv("CACHE_TTL"); envCacheTTL != "" {
+		if ttl, err := time.ParseDuration(envCacheTTL); err == nil {
+			Options.CacheTTL = ttl
+			explicit["cache-ttl"] = true
+		}
+	}
+	if envCacheNegativeTTL := os.Getenv("CACHE_NEGATIVE_TTL"); envCacheNegativeTTL != "" {
+		if ttl, err := time.ParseDuration(envCacheNegativeTTL); err == nil {
+			Options.CacheNegativeTTL = ttl
+			explicit["cache-negative-ttl"] = true
+		}
+	}
+	if envRestorePeriod := os.Getenv("RESTORE_PERIOD"); envRestorePeriod != "" {
+		if period, err := time.ParseDuration(envRestorePeriod); err == nil {
+			Options.RestorePeriod = period
+			explicit["restore-period"] = true
+		}
+	}
+	if envAnonymousLifetime := os.Getenv("ANONYMOUS_LIFETIME"); envAnonymousLifetime != "" {
+		if lifetime, err := time.ParseDuration(envAnonymousLifetime); err == nil {
+			Options.AnonymousLifetime = lifetime
+			explicit["anonymous-lifetime"] = true
+		}
+	}
+	if envJWTSecret := os.Getenv("JWT_SECRET"); envJWTSecret != "" {
+		Options.JWTSecret = envJWTSecret
+		explicit["jwt-secret"] = true
+	}
+	if envJWTKeysFile := os.Getenv("JWT_KEYS_FILE"); envJWTKeysFile != "" {
+		Options.JWTKeysFile = envJWTKeysFile
+		explicit["jwt-keys"] = true
 	}
 }
 
@@ -78,13 +192,26 @@ func initFile() {
 		return
 	}
 
+	// значения указателей отличают отсутствующий параметр от нулевого (например, "cache_size": 0)
 	var options struct {
-		HostAddr   string `json:"server_address"`
-		BaseAddr   string `json:"base_url"`
-		FilePath   string `json:"file_storage_path"`
-		Database   string `json:"database_dsn"`
-		Secure     bool   `json:"enable_https"`
-		TrustedNet string `json:"trusted_subnet"`
+		HostAddr          *string `json:"server_address"`
+		BaseAddr          *string `json:"base_url"`
+		FilePath          *string `json:"file_storage_path"`
+		Database          *string `json:"database_dsn"`
+		BoltPath          *string `json:"bolt_path"`
+		Secure            *bool   `json:"enable_https"`
+		TrustedNet        *string `json:"trusted_subnet"`
+		TrustedProxies    *string `json:"trusted_proxies"`
+		CodeGen           *string `json:"code_generator"`
+		CodeLength        *int    `json:"code_length"`
+		CodeAlphabet      *string `json:"code_alphabet"`
+		CacheSize         *int    `json:"cache_size"`
+		CacheTTL          *string `json:"cache_ttl"`
+		CacheNegativeTTL  *string `json:"cache_negative_ttl"`
+		RestorePeriod     *string `json:"restore_period"`
+		AnonymousLifetime *string `json:"anonymous_lifetime"`
+		JWTSecret         *string `json:"jwt_secret"`
+		JWTKeysFile       *string `json:"jwt_keys_file"`
 	}
 
 	err = json.Unmarshal(file, &options)
@@ -92,22 +219,41 @@ func initFile() {
 		return
 	}
 
-	if Options.HostAddr == "" {
-		Options.HostAddr = options.HostAddr
-	}
-	if Options.BaseAddr == "" {
-		Options.BaseAddr = options.BaseAddr
-	}
-	if Options.FilePath == "" {
-		Options.FilePath = options.FilePath
-	}
-	if Options.Database == "" {
-		Options.Database = options.Database
+	fromFile(&Options.HostAddr, options.HostAddr, "a")
+	fromFile(&Options.BaseAddr, options.BaseAddr, "b")
+	fromFile(&Options.FilePath, options.FilePath, "f")
+	fromFile(&Options.Database, options.Database, "d")
+	fromFile(&Options.BoltPath, options.BoltPath, "bolt")
+	fromFile(&Options.Secure, options.Secure, "s")
+	fromFile(&Options.TrustedNet, options.TrustedNet, "t")
+	fromFile(&Options.TrustedProxies, options.TrustedProxies, "trusted-proxies")
+	fromFile(&Options.CodeGen, options.CodeGen, "code-gen")
+	fromFile(&Options.CodeLength, options.CodeLength, "code-len")
+	fromFile(&Options.CodeAlphabet, options.CodeAlphabet, "code-alphabet")
+	fromFile(&Options.CacheSize, options.CacheSize, "cache-size")
+	durationFromFile(&Options.CacheTTL, options.CacheTTL, "cache-ttl")
+	durationFromFile(&Options.CacheNegativeTTL, options.CacheNegativeTTL, "cache-negative-ttl")
+	durationFromFile(&Options.RestorePeriod, options.RestorePeriod, "restore-period")
+	durationFromFile(&Options.AnonymousLifetime, options.AnonymousLifetime, "anonymous-lifetime")
+	fromFile(&Options.JWTSecret, options.JWTSecret, "jwt-secret")
+	fromFile(&Options.JWTKeysFile, options.JWTKeysFile, "jwt-keys")
+}
+
+// fromFile sets the option to the value from the config file, if the file has the value
+// and the option is not set by its flag or environment variable (see explicit).
+func fromFile[T any](option *T, value *T, name string) {
+	if value != nil && !explicit[name] {
+		*option = *value
 	}
-	if !Options.Secure {
-		Options.Secure = options.Secure
+}
+
+// durationFromFile sets the option like fromFile, the value of the file is a duration like "1h30m".
+// An incorrect duration is ignored.
+func durationFromFile(option *time.Duration, value *string, name string) {
+	if value == nil || explicit[name] {
+		return
 	}
-	if Options.TrustedNet == "" {
-		Options.TrustedNet = options.TrustedNet
+	if d, err := time.ParseDuration(*value); err == nil {
+		*option = d
 	}
 }
@@ -7,6 +7,8 @@ import (
 	"sync"
 
 	"github.com/yury-kuznetsov/shortener/api/pb"
+	"github.com/yury-kuznetsov/shortener/internal/auth"
+	"github.com/yury-kuznetsov/shortener/internal/errmap"
 	"github.com/yury-kuznetsov/shortener/internal/grpcsrv"
 	"github.com/yury-kuznetsov/shortener/internal/uricoder"
 	"google.golang.org/grpc"
@@ -21,7 +23,7 @@ func startGrpcServer(coder *uricoder.Coder, wg *sync.WaitGroup) (*grpc.Server, n
 		return nil, nil, err
 	}
 
-	server := grpc.NewServer(grpc.UnaryInterceptor(unaryInterceptor))
+	server := grpc.NewServer(grpc.UnaryInterceptor(unaryInterceptor(coder)))
 	pb.RegisterServiceServer(server, grpcsrv.NewCoderServer(coder))
 
 	go func() {
@@ -34,18 +36,41 @@ func startGrpcServer(coder *uricoder.Coder, wg *sync.WaitGroup) (*grpc.Server, n
 	return server, listen, nil
 }
 
-func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
-	}
-	// псевдоавторизация (0 - гость, 1 - авторизованный)
-	userID := 0
-	tokens := md.Get("token")
-	if len(tokens) > 0 && tokens[0] == "SECRET_KEY" {
-		userID = 1
-	}
-	newCtx := context.WithValue(ctx, grpcsrv.KeyUserID, userID)
+func unaryInterceptor(coder *uricoder.Coder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
+		}
+
+		// API-ключ или токен пользователя в метаданных authorization
+		var token string
+		if values := md.Get("authorization"); len(values) > 0 {
+			var key string
+			key, token = auth.BearerCredentials(values[0])
+			if key == "" && token == "" {
+				return nil, status.Error(codes.Unauthenticated, "bearer API key or token is expected")
+			}
+			if key != "" {
+				apiKey, err := coder.AuthenticateAPIKey(ctx, key)
+				if err != nil {
+					return nil, errmap.GRPCError(err)
+				}
+				identity := auth.Identity{UserID: apiKey.UserID, KeyID: apiKey.ID, Scopes: apiKey.Scopes}
+				return handler(auth.WithIdentity(ctx, identity), req)
+			}
+		}
 
-	return handler(newCtx, req)
+		// токен пользователя, выданный HTTP-сервером (без токена - гость)
+		if tokens := md.Get("token"); len(tokens) > 0 {
+			token = tokens[0]
+		}
+		identity := auth.Identity{}
+		if token != "" {
+			identity = auth.ParseToken(token)
+		}
+		newCtx := auth.WithIdentity(ctx, identity)
+
+		return handler(newCtx, req)
+	}
 }
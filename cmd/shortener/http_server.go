@@ -51,17 +51,30 @@ func startHTTPServer(coder *uricoder.Coder, wg *sync.WaitGroup) (*http.Server, e
 
 func buildRouter(coder *uricoder.Coder) *chi.Mux {
 	sugar := logger.NewLogger()
+	authn := auth.NewMiddleware(coder)
 
 	r := chi.NewRouter()
-	r.Get("/{code}", auth.Handle(gzip.Handle(sugar.Handle(handlers.DecodeHandler(coder))), true))
-	r.Get("/ping", auth.Handle(gzip.Handle(sugar.Handle(handlers.PingHandler(coder))), true))
-	r.Get("/api/user/urls", auth.Handle(gzip.Handle(sugar.Handle(handlers.UserUrlsHandler(coder))), false))
-	r.Delete("/api/user/urls", auth.Handle(gzip.Handle(sugar.Handle(handlers.DeleteUrlsHandler(coder))), true))
-	r.Post("/api/shorten/batch", auth.Handle(gzip.Handle(sugar.Handle(handlers.EncodeBatchHandler(coder))), true))
-	r.Post("/api/shorten", auth.Handle(gzip.Handle(sugar.Handle(handlers.EncodeJSONHandler(coder))), true))
-	r.Post("/", auth.Handle(gzip.Handle(sugar.Handle(handlers.EncodeHandler(coder))), true))
+	r.Get("/{code}", authn.Handle(gzip.Handle(sugar.Handle(handlers.DecodeHandler(coder))), false))
+	r.Get("/ping", authn.Handle(gzip.Handle(sugar.Handle(handlers.PingHandler(coder))), false))
+	r.Get("/api/user/urls", authn.Handle(gzip.Handle(sugar.Handle(handlers.UserUrlsHandler(coder))), false))
+	r.Get("/api/user/urls/search", authn.Handle(gzip.Handle(sugar.Handle(handlers.SearchUrlsHandler(coder))), false))
+	r.Get("/api/user/urls/{code}/stats", authn.Handle(gzip.Handle(sugar.Handle(handlers.LinkStatsHandler(coder))), false))
+	r.Patch("/api/user/urls/{code}", authn.Handle(gzip.Handle(sugar.Handle(handlers.PatchLinkHandler(coder))), false))
+	r.Get("/api/user/urls/{code}/revisions", authn.Handle(gzip.Handle(sugar.Handle(handlers.RevisionsHandler(coder))), false))
+	r.Post("/api/user/urls/{code}/rollback", authn.Handle(gzip.Handle(sugar.Handle(handlers.RollbackHandler(coder))), false))
+	r.Get("/api/user/tags", authn.Handle(gzip.Handle(sugar.Handle(handlers.TagsHandler(coder))), false))
+	r.Delete("/api/user/urls", authn.Handle(gzip.Handle(sugar.Handle(handlers.DeleteUrlsHandler(coder))), true))
+	r.Post("/api/user/urls/restore", authn.Handle(gzip.Handle(sugar.Handle(handlers.RestoreUrlsHandler(coder))), false))
+	r.Post("/api/user/register", authn.Handle(gzip.Handle(sugar.Handle(handlers.RegisterHandler(coder))), false))
+	r.Post("/api/user/login", authn.Handle(gzip.Handle(sugar.Handle(handlers.LoginHandler(coder))), false))
+	r.Post("/api/user/keys", authn.Handle(gzip.Handle(sugar.Handle(handlers.CreateAPIKeyHandler(coder))), false))
+	r.Get("/api/user/keys", authn.Handle(gzip.Handle(sugar.Handle(handlers.APIKeysHandler(coder))), false))
+	r.Delete("/api/user/keys/{id}", authn.Handle(gzip.Handle(sugar.Handle(handlers.RevokeAPIKeyHandler(coder))), false))
+	r.Post("/api/shorten/batch", authn.Handle(gzip.Handle(sugar.Handle(handlers.EncodeBatchHandler(coder))), true))
+	r.Post("/api/shorten", authn.Handle(gzip.Handle(sugar.Handle(handlers.EncodeJSONHandler(coder))), true))
+	r.Post("/", authn.Handle(gzip.Handle(sugar.Handle(handlers.EncodeHandler(coder))), true))
 	r.Get("/api/internal/stats", subnet.Handle(gzip.Handle(sugar.Handle(handlers.GetStatsHandler(coder)))))
-	r.MethodNotAllowed(auth.Handle(gzip.Handle(sugar.Handle(handlers.NotAllowedHandler())), true))
+	r.MethodNotAllowed(authn.Handle(gzip.Handle(sugar.Handle(handlers.NotAllowedHandler())), false))
 
 	// обработчики для pprof
 	r.Handle("/debug/pprof/*", http.HandlerFunc(pprof.Index))
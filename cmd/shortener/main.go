@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -11,6 +13,9 @@ import (
 	"time"
 
 	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"github.com/yury-kuznetsov/shortener/internal/auth"
+	"github.com/yury-kuznetsov/shortener/internal/storage/bolt"
+	"github.com/yury-kuznetsov/shortener/internal/storage/cache"
 	"github.com/yury-kuznetsov/shortener/internal/storage/database"
 	"github.com/yury-kuznetsov/shortener/internal/storage/file"
 	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
@@ -22,11 +27,43 @@ func main() {
 
 	config.Init()
 
+	// подкоманды: shortener [flags] migrate status|up|down
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("Unknown command: %s", args[0])
+		}
+		if err := runMigrate(args[1:]); err != nil {
+			log.Fatalf("Migrate: %v", err)
+		}
+		return
+	}
+
+	keys, err := buildKeys()
+	if err != nil {
+		log.Fatalf("JWT keys: %v", err)
+	}
+	auth.SetKeys(keys)
+
 	storage, err := buildStorage()
 	if err != nil {
 		panic(err)
 	}
-	coder := uricoder.NewCoder(storage)
+	if config.Options.CacheSize > 0 {
+		storage = cache.NewStorage(storage,
+			cache.WithSize(config.Options.CacheSize),
+			cache.WithTTL(config.Options.CacheTTL),
+			cache.WithNegativeTTL(config.Options.CacheNegativeTTL),
+		)
+	}
+	generator, err := buildGenerator()
+	if err != nil {
+		panic(err)
+	}
+	coder := uricoder.NewCoder(storage,
+		uricoder.WithGenerator(generator),
+		uricoder.WithRestorePeriod(config.Options.RestorePeriod),
+		uricoder.WithAnonymousLifetime(config.Options.AnonymousLifetime),
+	)
 
 	// запустим два сервера: http и grpc
 	var wg sync.WaitGroup
@@ -62,14 +99,59 @@ func main() {
 
 	wg.Wait()
 	log.Println("Both servers are stopped successfully")
+
+	// закрываем хранилище, если оно держит открытые файлы
+	if closer, ok := storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Storage Close: %v", err)
+		}
+	}
 }
 
 func buildStorage() (uricoder.Storage, error) {
 	if len(config.Options.Database) > 0 {
 		return database.NewStorage(config.Options.Database)
 	}
+	if len(config.Options.BoltPath) > 0 {
+		return bolt.NewStorage(config.Options.BoltPath)
+	}
 	if len(config.Options.FilePath) > 0 {
 		return file.NewStorage(config.Options.FilePath)
 	}
-	return memory.NewStorage(), nil
+	// пользователи не переживают перезапуск, поэтому их идентификаторы не должны повторяться
+	storage := memory.NewStorage()
+	storage.SeedUserIDs(time.Now())
+	return storage, nil
+}
+
+func buildGenerator() (uricoder.CodeGenerator, error) {
+	switch config.Options.CodeGen {
+	case "", "random":
+		alphabet := config.Options.CodeAlphabet
+		if alphabet == "" {
+			alphabet = uricoder.DefaultAlphabet
+		}
+		return uricoder.NewRandomGenerator(alphabet, config.Options.CodeLength)
+	case "counter":
+		return uricoder.NewCounterGenerator(uint64(time.Now().UnixNano())), nil
+	case "words":
+		return uricoder.NewWordGenerator(), nil
+	}
+	return nil, fmt.Errorf("unknown code generator: %s", config.Options.CodeGen)
+}
+
+func buildKeys() (*auth.KeySet, error) {
+	if len(config.Options.JWTKeysFile) > 0 {
+		return auth.LoadKeyFile(config.Options.JWTKeysFile)
+	}
+	if len(config.Options.JWTSecret) > 0 {
+		key, err := auth.NewHMACKey(auth.DefaultKeyID, []byte(config.Options.JWTSecret))
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewKeySet(key)
+	}
+	// без настроенного ключа токены действуют только до перезапуска
+	log.Println("JWT signing key is not configured, a random key is used")
+	return auth.NewRandomKeySet()
 }
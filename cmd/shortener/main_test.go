@@ -14,6 +14,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
 	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
 	"github.com/yury-kuznetsov/shortener/internal/uricoder"
 )
@@ -49,7 +50,7 @@ func TestRequests(t *testing.T) {
 		return http.ErrUseLastResponse
 	}
 
-	code, _ := coder.ToCode(ctx, "https://google.com", 0)
+	code, _ := coder.ToCode(ctx, "https://google.com", 0, models.LinkOptions{})
 
 	tests := []testCase{
 		{
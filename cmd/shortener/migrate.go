@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"github.com/yury-kuznetsov/shortener/internal/storage/database"
+)
+
+// runMigrate executes the "migrate" subcommand: shortener [flags] migrate status|up|down
+func runMigrate(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: shortener [flags] migrate status|up|down")
+	}
+	if config.Options.Database == "" {
+		return errors.New("database dsn is not set")
+	}
+
+	db, err := database.Open(config.Options.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s: %s\n", status.Version, status.Name, state)
+		}
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied migrations: %d\n", applied)
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Println("Nothing to revert")
+			return nil
+		}
+		fmt.Printf("Reverted migration: %04d_%s\n", migration.Version, migration.Name)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+
+	return nil
+}
@@ -1,36 +1,45 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"github.com/yury-kuznetsov/shortener/internal/auth"
+	"github.com/yury-kuznetsov/shortener/internal/errmap"
 	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/subnet"
 	"github.com/yury-kuznetsov/shortener/internal/uricoder"
 )
 
 // DecodeHandler decodes the given code to a URI and redirects the user to the decoded URI.
+// Errors are answered with the status chosen by errmap.HTTPStatus: 404 Not Found for an unknown code,
+// 410 Gone for deleted and expired links.
+// Every successful redirect is recorded as a click event (see uricoder.Coder.RecordClick).
 func DecodeHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
-		if err != nil {
-			userID = 0
-		}
+		userID := auth.FromContext(req.Context()).UserID
 
 		code := strings.TrimLeft(req.URL.Path, "/")
 		uri, err := coder.ToURI(req.Context(), code, userID)
 		if err != nil {
-			if errors.Is(err, models.ErrRowDeleted) {
-				res.WriteHeader(http.StatusGone)
-				return
-			}
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
 			return
 		}
+		coder.RecordClick(models.Click{
+			Code:      code,
+			Time:      time.Now(),
+			Referrer:  req.Referer(),
+			UserAgent: req.UserAgent(),
+			IP:        clientIP(req),
+		})
 		http.Redirect(res, req, uri, http.StatusTemporaryRedirect)
 	}
 
@@ -39,37 +48,57 @@ func DecodeHandler(coder *uricoder.Coder) http.HandlerFunc {
 
 // EncodeBatchHandler handles batch encoding of URLs.
 // It receives a JSON array of EncodeBatchRequest and returns a JSON array of EncodeBatchResponse.
-// Each EncodeBatchRequest contains an original URL to be encoded.
+// Each EncodeBatchRequest contains an original URL to be encoded, an optional expiration time, an optional alias
+// and optional tags.
 // Each EncodeBatchResponse contains the correlation ID and the short URL.
-// If the encoding fails for any request, EncodeBatchHandler returns an error response.
-// The handler function decodes the request body and prepares the response.
-// For each request, it calls the ToCode method of the Coder instance to encode the original URL.
-// It appends the EncodeBatchResponse to the response array.
+// The batch is stored at once (see uricoder.Coder.ToCodes): if any URL cannot be encoded, none of them is stored
+// and EncodeBatchHandler returns an error response with the index of the failed item
+// (the status is chosen by errmap.HTTPStatus: 409 Conflict if the alias or the URL is already taken,
+// 400 Bad Request if the URL is incorrect).
 // Finally, it sets the content-type header, writes the response array as JSON, and returns the status code 201.
+//
+// With the "partial=true" query parameter the valid URLs are stored even if some others are not
+// (see uricoder.Coder.ToCodesPartial). Each EncodeBatchResponse then carries its own status:
+// "created", "conflict" with the existing short URL, or "invalid" with the reason,
+// and the handler returns the status code 207 Multi-Status.
 func EncodeBatchHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		if !allowed(res, req, models.ScopeLinksWrite) {
+			return
+		}
+
 		// принимаем запрос
 		var request []models.EncodeBatchRequest
 		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
+		userID := auth.FromContext(req.Context()).UserID
+
+		links := make([]models.LinkRequest, 0, len(request))
+		for _, v := range request {
+			links = append(links, models.LinkRequest{
+				URI:         v.OriginalURL,
+				LinkOptions: models.LinkOptions{ExpiresAt: v.ExpiresAt, Alias: v.Alias, Tags: v.Tags},
+			})
+		}
+		if partial, _ := strconv.ParseBool(req.URL.Query().Get("partial")); partial {
+			encodeBatchPartial(res, req, coder, request, links, userID)
+			return
+		}
+
+		// сохраняем весь пакет за один раз
+		codes, err := coder.ToCodes(req.Context(), links, userID)
 		if err != nil {
-			userID = 0
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
 		}
 
 		// готовим ответ
-		var response []models.EncodeBatchResponse
-		for _, v := range request {
-			code, err := coder.ToCode(req.Context(), v.OriginalURL, userID)
-			if err != nil {
-				http.Error(res, err.Error(), http.StatusBadRequest)
-				return
-			}
-
+		response := make([]models.EncodeBatchResponse, 0, len(codes))
+		for i, code := range codes {
 			response = append(response, models.EncodeBatchResponse{
-				CorrelationID: v.CorrelationID,
+				CorrelationID: request[i].CorrelationID,
 				ShortURL:      config.Options.BaseAddr + "/" + code,
 			})
 		}
@@ -86,24 +115,67 @@ func EncodeBatchHandler(coder *uricoder.Coder) http.HandlerFunc {
 	return handlerFunc
 }
 
+// encodeBatchPartial stores the valid links of the batch and writes the status of every item.
+func encodeBatchPartial(
+	res http.ResponseWriter,
+	req *http.Request,
+	coder *uricoder.Coder,
+	request []models.EncodeBatchRequest,
+	links []models.LinkRequest,
+	userID int,
+) {
+	results, err := coder.ToCodesPartial(req.Context(), links, userID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.EncodeBatchResponse, 0, len(results))
+	for i, result := range results {
+		item := models.EncodeBatchResponse{
+			CorrelationID: request[i].CorrelationID,
+			Status:        result.Status,
+		}
+		if result.Code != "" {
+			item.ShortURL = config.Options.BaseAddr + "/" + result.Code
+		}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		response = append(response, item)
+	}
+
+	res.Header().Set("content-type", "application/json")
+	res.WriteHeader(http.StatusMultiStatus)
+	if err := json.NewEncoder(res).Encode(response); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // EncodeJSONHandler encodes the given URL to a code and returns the code in a JSON response.
+// The request may contain the expiration time of the short URL, the alias to be used as the code
+// and the tags of the link.
+// If the alias is already taken, it returns 409 Conflict with the error message.
 func EncodeJSONHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		if !allowed(res, req, models.ScopeLinksWrite) {
+			return
+		}
+
 		// принимаем запрос
 		var request models.EncodeRequest
 		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
-		if err != nil {
-			userID = 0
-		}
+		userID := auth.FromContext(req.Context()).UserID
 
 		// запускаем обработку
-		code, err := coder.ToCode(req.Context(), request.URL, userID)
+		opts := models.LinkOptions{ExpiresAt: request.ExpiresAt, Alias: request.Alias, Tags: request.Tags}
+		code, err := coder.ToCode(req.Context(), request.URL, userID, opts)
 		if code == "" && err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
 			return
 		}
 
@@ -125,21 +197,21 @@ func EncodeJSONHandler(coder *uricoder.Coder) http.HandlerFunc {
 }
 
 // EncodeHandler encodes the given URI and returns the generated code.
-// If the code generation fails, it returns a Bad Request error with the corresponding error message.
+// If the code generation fails, it returns the error message with the status chosen by errmap.HTTPStatus.
 // It also sets the "content-type" header to "text/plain" and the response status code to StatusCreated if no error occurs.
 // Otherwise, it sets the response status code to StatusConflict.
 // Finally, it writes the base address concatenated with the generated code to the response body.
 func EncodeHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
 		// обрабатываем запрос
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
-		if err != nil {
-			userID = 0
+		userID := auth.FromContext(req.Context()).UserID
+		if !allowed(res, req, models.ScopeLinksWrite) {
+			return
 		}
 		uri, _ := io.ReadAll(req.Body)
-		code, err := coder.ToCode(req.Context(), string(uri), userID)
+		code, err := coder.ToCode(req.Context(), string(uri), userID, models.LinkOptions{})
 		if code == "" && err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
 			return
 		}
 
@@ -173,41 +245,103 @@ func PingHandler(coder *uricoder.Coder) http.HandlerFunc {
 	return handlerFunc
 }
 
-// UserUrlsHandler retrieves and returns a user's URL history in JSON format.
+// UserUrlsHandler retrieves and returns a page of the user's URL history in JSON format.
+// The page is selected by the query parameters (see uricoder.Coder.GetHistory):
+// "limit" (up to uricoder.MaxHistoryLimit, uricoder.DefaultHistoryLimit by default),
+// "cursor" (the X-Next-Cursor header of the previous page), "sort" ("created" or "clicks"),
+// "order" ("asc" by default or "desc"), "deleted" (true for the deleted links only, false for the live ones),
+// "domain" (the links to the domain or its subdomains) and "tag" (the links with the tag).
+// If there are more links, the cursor of the next page is returned in the X-Next-Cursor header.
+// It returns 401 Unauthorized for an anonymous user, 400 Bad Request for incorrect parameters
+// and 204 No Content if the page is empty.
 func UserUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set("content-type", "application/json")
 
 		// проверяем авторизацию
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksRead) {
+			return
+		}
+
+		// читаем параметры запроса
+		query, err := historyQuery(req)
 		if err != nil {
-			userID = 0
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// запускаем обработку запроса
+		page, err := coder.GetHistory(req.Context(), userID, query, req.URL.Query().Get("cursor"))
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+		if len(page.Links) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// возвращаем ответ
+		if page.NextCursor != "" {
+			res.Header().Set("X-Next-Cursor", page.NextCursor)
 		}
+		if err := json.NewEncoder(res).Encode(userUrlsResponse(page.Links)); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+
+	return handlerFunc
+}
+
+// SearchUrlsHandler searches the user's links by the "q" query parameter and returns them in JSON format
+// like UserUrlsHandler. A link matches if its code, destination URL or one of its tags contains the query
+// (see uricoder.Coder.Search); the newest links go first, up to the "limit" query parameter.
+// It returns 401 Unauthorized for an anonymous user, 400 Bad Request for an empty query or an incorrect limit
+// and 204 No Content if nothing is found.
+func SearchUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("content-type", "application/json")
+
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
 		if userID == 0 {
 			res.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if !allowed(res, req, models.ScopeLinksRead) {
+			return
+		}
+
+		// читаем параметры запроса
+		var limit int
+		if param := req.URL.Query().Get("limit"); param != "" {
+			var err error
+			if limit, err = strconv.Atoi(param); err != nil || limit <= 0 {
+				http.Error(res, "incorrect limit", http.StatusBadRequest)
+				return
+			}
+		}
 
 		// запускаем обработку запроса
-		data, err := coder.GetHistory(req.Context(), userID)
+		links, err := coder.Search(req.Context(), userID, req.URL.Query().Get("q"), limit)
 		if err != nil {
-			res.WriteHeader(http.StatusInternalServerError)
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
 			return
 		}
-		if len(data) == 0 {
+		if len(links) == 0 {
 			res.WriteHeader(http.StatusNoContent)
 			return
 		}
 
 		// возвращаем ответ
-		var response []models.GetByUserResponse
-		for _, v := range data {
-			response = append(response, models.GetByUserResponse{
-				ShortURL:    config.Options.BaseAddr + "/" + v.ShortURL,
-				OriginalURL: v.OriginalURL,
-			})
-		}
-		if err := json.NewEncoder(res).Encode(response); err != nil {
+		if err := json.NewEncoder(res).Encode(userUrlsResponse(links)); err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -217,6 +351,277 @@ func UserUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	return handlerFunc
 }
 
+// userUrlsResponse converts the links of the user into the response items with the full short URLs.
+func userUrlsResponse(links []models.UserLink) []models.GetByUserResponse {
+	response := make([]models.GetByUserResponse, 0, len(links))
+	for _, v := range links {
+		response = append(response, models.GetByUserResponse{
+			ShortURL:    config.Options.BaseAddr + "/" + v.Code,
+			OriginalURL: v.URI,
+			CreatedAt:   v.CreatedAt,
+			Clicks:      v.Clicks,
+			IsDeleted:   v.IsDeleted,
+			Tags:        v.Tags,
+		})
+	}
+	return response
+}
+
+// historyQuery reads the query of the user's URL history from the query parameters of the request.
+func historyQuery(req *http.Request) (models.HistoryQuery, error) {
+	params := req.URL.Query()
+	query := models.HistoryQuery{
+		SortBy: params.Get("sort"),
+		Domain: params.Get("domain"),
+		Tag:    params.Get("tag"),
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, errors.New("incorrect limit")
+		}
+		query.Limit = n
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errors.New("incorrect order")
+	}
+
+	if deleted := params.Get("deleted"); deleted != "" {
+		value, err := strconv.ParseBool(deleted)
+		if err != nil {
+			return query, errors.New("incorrect deleted flag")
+		}
+		query.Deleted = &value
+	}
+
+	return query, nil
+}
+
+// PatchLinkHandler changes the link of the user with the code from the "code" URL parameter.
+// The request is a JSON object (see models.PatchLinkRequest), only its fields which are set are applied:
+// "url" sets the new destination of the link as a new revision (see uricoder.Coder.Update),
+// "tags" replaces the tags of the link (see uricoder.Coder.SetTags).
+// Both fields are applied in a single change (see uricoder.Coder.UpdateWithTags),
+// so a failed request changes nothing.
+// It returns 401 Unauthorized for an anonymous user, 400 Bad Request for an incorrect request,
+// the status chosen by errmap.HTTPStatus for other errors (404 Not Found for an unknown code,
+// 403 Forbidden if the link belongs to another user) and 204 No Content on success.
+func PatchLinkHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksWrite) {
+			return
+		}
+
+		// принимаем запрос
+		var request models.PatchLinkRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.URL == nil && request.Tags == nil {
+			http.Error(res, "nothing to change", http.StatusBadRequest)
+			return
+		}
+		// запускаем обработку запроса: адрес и теги меняются одним изменением хранилища
+		code := chi.URLParam(req, "code")
+		var err error
+		switch {
+		case request.URL != nil && request.Tags != nil:
+			_, err = coder.UpdateWithTags(req.Context(), code, userID, *request.URL, *request.Tags)
+		case request.URL != nil:
+			_, err = coder.Update(req.Context(), code, userID, *request.URL)
+		default:
+			err = coder.SetTags(req.Context(), code, userID, *request.Tags)
+		}
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+
+	return handlerFunc
+}
+
+// RevisionsHandler returns the revisions of the destination of a link of the user in JSON format,
+// the oldest first (see uricoder.Coder.GetRevisions). The code of the link is taken from the "code" URL parameter.
+// It returns 401 Unauthorized for an anonymous user and the status chosen by errmap.HTTPStatus for other errors:
+// 404 Not Found for an unknown code and 403 Forbidden if the link belongs to another user.
+func RevisionsHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksRead) {
+			return
+		}
+
+		// запускаем обработку запроса
+		revisions, err := coder.GetRevisions(req.Context(), chi.URLParam(req, "code"), userID)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(res).Encode(revisions); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// RollbackHandler restores the destination of a link of the user from one of its revisions
+// (see uricoder.Coder.Rollback). The code of the link is taken from the "code" URL parameter,
+// the number of the revision from the JSON request (see models.RollbackRequest).
+// The rollback is saved as a new revision, which is returned in JSON format.
+// It returns 401 Unauthorized for an anonymous user, 400 Bad Request for an incorrect request
+// and the status chosen by errmap.HTTPStatus for other errors: 404 Not Found for an unknown code
+// or revision and 403 Forbidden if the link belongs to another user.
+func RollbackHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksWrite) {
+			return
+		}
+
+		// принимаем запрос
+		var request models.RollbackRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// запускаем обработку запроса
+		revision, err := coder.Rollback(req.Context(), chi.URLParam(req, "code"), userID, request.Revision)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(res).Encode(revision); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// TagsHandler returns the tags of the user's links with the number of links per tag in JSON format,
+// the most frequent tags first (see uricoder.Coder.GetTagCounts).
+// It returns 401 Unauthorized for an anonymous user and 204 No Content if the user has no tags.
+func TagsHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("content-type", "application/json")
+
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksRead) {
+			return
+		}
+
+		// запускаем обработку запроса
+		counts, err := coder.GetTagCounts(req.Context(), userID)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+		if len(counts) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// возвращаем ответ
+		if err := json.NewEncoder(res).Encode(counts); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// LinkStatsHandler returns the click statistics of a link of the user in JSON format
+// (see uricoder.Coder.GetLinkStats). The code of the link is taken from the "code" URL parameter.
+// The optional "from" and "to" query parameters limit the time range of the statistics (RFC 3339).
+// It returns 401 Unauthorized for an anonymous user, 400 Bad Request for an incorrect time range,
+// and the status chosen by errmap.HTTPStatus for other errors: 404 Not Found for an unknown code
+// and 403 Forbidden if the link belongs to another user.
+func LinkStatsHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeStatsRead) {
+			return
+		}
+
+		// читаем временной диапазон
+		var from, to time.Time
+		for name, value := range map[string]*time.Time{"from": &from, "to": &to} {
+			param := req.URL.Query().Get(name)
+			if param == "" {
+				continue
+			}
+			var err error
+			if *value, err = time.Parse(time.RFC3339, param); err != nil {
+				http.Error(res, uricoder.ErrIncorrectRange.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// запускаем обработку запроса
+		stats, err := coder.GetLinkStats(req.Context(), chi.URLParam(req, "code"), userID, from, to)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(res).Encode(stats); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
 // DeleteUrlsHandler deletes URLs based on the given codes.
 // It receives a URICoder instance and returns an http.HandlerFunc.
 // The handler decodes the incoming JSON request body to get the codes to be deleted.
@@ -225,9 +630,9 @@ func UserUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
 // After deleting the URLs, it sets the response status code to 202 Accepted.
 func DeleteUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
-		userID, err := strconv.Atoi(req.Header.Get("Content-User-ID"))
-		if err != nil {
-			userID = 0
+		userID := auth.FromContext(req.Context()).UserID
+		if !allowed(res, req, models.ScopeLinksDelete) {
+			return
 		}
 
 		var codes []string
@@ -244,22 +649,234 @@ func DeleteUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	return handlerFunc
 }
 
+// RestoreUrlsHandler restores the recently deleted URLs of the user (see uricoder.Coder.Restore).
+// It receives a JSON array of codes like DeleteUrlsHandler and returns a JSON array of the restored codes.
+// The codes which cannot be restored (unknown, owned by another user, not deleted or deleted
+// before the restore period) are skipped.
+// It returns 401 Unauthorized for an anonymous user and 400 Bad Request for an incorrect request.
+func RestoreUrlsHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID := auth.FromContext(req.Context()).UserID
+		if userID == 0 {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !allowed(res, req, models.ScopeLinksDelete) {
+			return
+		}
+
+		// принимаем запрос
+		var codes []string
+		if err := json.NewDecoder(req.Body).Decode(&codes); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// запускаем обработку запроса
+		restored, err := coder.Restore(req.Context(), codes, userID)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(res).Encode(restored); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// RegisterHandler creates a user account from the JSON credentials `{"login": "...", "password": "..."}`
+// (see uricoder.Coder.Register) and authorizes the client as the new user: the token is sent
+// in the cookie and in the Authorization header.
+// If the client is an anonymous user, its links are given to the account (see uricoder.Coder.Claim).
+// It returns 400 Bad Request for incorrect credentials and 409 Conflict if the login is already registered.
+func RegisterHandler(coder *uricoder.Coder) http.HandlerFunc {
+	return credentialsHandler(coder, coder.Register)
+}
+
+// LoginHandler authorizes the client as the user with the JSON credentials like RegisterHandler
+// (see uricoder.Coder.Login). The links of an anonymous client are given to the user as well.
+// It returns 400 Bad Request for an incorrect request and 401 Unauthorized for wrong credentials.
+func LoginHandler(coder *uricoder.Coder) http.HandlerFunc {
+	return credentialsHandler(coder, coder.Login)
+}
+
+// credentialsHandler returns a handler which finds the user by the credentials from the request,
+// gives the links of the anonymous client to the user and sends the token of the user.
+func credentialsHandler(
+	coder *uricoder.Coder,
+	authenticate func(context.Context, models.Credentials) (int, error),
+) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// принимаем запрос
+		var credentials models.Credentials
+		if err := json.NewDecoder(req.Body).Decode(&credentials); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// запускаем обработку запроса
+		userID, err := authenticate(req.Context(), credentials)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// переносим ссылки анонимного пользователя (если его уже нет, переносить нечего)
+		if identity := auth.FromContext(req.Context()); identity.Anonymous {
+			_, err = coder.Claim(req.Context(), identity.UserID, userID)
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				http.Error(res, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// возвращаем токен пользователя
+		if err = auth.Authorize(res, userID); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}
+
+	return handlerFunc
+}
+
+// CreateAPIKeyHandler creates an API key of the user with the name and the scopes from the JSON request
+// (see models.APIKeyRequest and uricoder.Coder.CreateAPIKey) and returns it with the key itself in JSON format
+// and the status code 201. The key is shown only once: only its hash is stored.
+// The keys are managed by the registered users authorized by the cookie (see keyOwner).
+// It returns 400 Bad Request for an incorrect request.
+func CreateAPIKeyHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID, ok := keyOwner(res, req)
+		if !ok {
+			return
+		}
+
+		// принимаем запрос
+		var request models.APIKeyRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// запускаем обработку запроса
+		response, err := coder.CreateAPIKey(req.Context(), userID, request)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		res.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(res).Encode(response); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// APIKeysHandler returns the API keys of the user without the keys themselves in JSON format,
+// the oldest first (see uricoder.Coder.GetAPIKeys). It returns 204 No Content if the user has no keys.
+func APIKeysHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID, ok := keyOwner(res, req)
+		if !ok {
+			return
+		}
+
+		// запускаем обработку запроса
+		keys, err := coder.GetAPIKeys(req.Context(), userID)
+		if err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+		if len(keys) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// возвращаем ответ
+		res.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(res).Encode(keys); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	return handlerFunc
+}
+
+// RevokeAPIKeyHandler revokes the API key of the user with the ID from the "id" URL parameter
+// (see uricoder.Coder.RevokeAPIKey) and returns 204 No Content.
+// It returns the status chosen by errmap.HTTPStatus for errors: 404 Not Found for an unknown key
+// and 403 Forbidden if the key belongs to another user.
+func RevokeAPIKeyHandler(coder *uricoder.Coder) http.HandlerFunc {
+	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// проверяем авторизацию
+		userID, ok := keyOwner(res, req)
+		if !ok {
+			return
+		}
+
+		// запускаем обработку запроса
+		if err := coder.RevokeAPIKey(req.Context(), chi.URLParam(req, "id"), userID); err != nil {
+			http.Error(res, err.Error(), errmap.HTTPStatus(err))
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+
+	return handlerFunc
+}
+
+// keyOwner returns the user who may manage the API keys: a registered user authorized by the cookie.
+// Otherwise, it answers 401 Unauthorized for a guest or an anonymous user
+// and 403 Forbidden for a request authorized by an API key (a key cannot create other keys).
+func keyOwner(res http.ResponseWriter, req *http.Request) (int, bool) {
+	identity := auth.FromContext(req.Context())
+	switch {
+	case identity.IsGuest() || identity.Anonymous:
+		res.WriteHeader(http.StatusUnauthorized)
+		return 0, false
+	case identity.IsAPIKey():
+		http.Error(res, "API keys cannot be managed with an API key", http.StatusForbidden)
+		return 0, false
+	}
+	return identity.UserID, true
+}
+
 // GetStatsHandler retrieves statistics from the `Coder` storage and returns them as a JSON response.
-// The returned response includes the number of stored URLs and the number of unique users.
+// The returned response includes the number of stored URLs (live and soft deleted), the number of users,
+// the number of links created per day, the top users by the number of links, the depth of the deletion queue
+// and the storage backend details (see uricoder.Coder.GetStats).
+// If the storage is wrapped with a cache, the cache hits and misses are included as well.
 // If an error occurs during the retrieval or encoding of the statistics, an internal server error is returned.
 func GetStatsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set("content-type", "application/json")
 
 		// получаем данные
-		urls, users, err := coder.GetStats(req.Context())
+		response, err := coder.GetStats(req.Context())
 		if err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		// возвращаем ответ
-		response := models.GetStatsResponse{Urls: urls, Users: users}
 		if err := json.NewEncoder(res).Encode(response); err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
@@ -270,6 +887,26 @@ func GetStatsHandler(coder *uricoder.Coder) http.HandlerFunc {
 	return handlerFunc
 }
 
+// allowed reports whether the request may use the scope (see auth.Identity.Allows).
+// Otherwise, it answers 403 Forbidden: the API key of the request lacks the scope.
+func allowed(res http.ResponseWriter, req *http.Request, scope string) bool {
+	if auth.FromContext(req.Context()).Allows(scope) {
+		return true
+	}
+	http.Error(res, "API key lacks the scope "+scope, http.StatusForbidden)
+	return false
+}
+
+// clientIP returns the IP address of the client: the address of the remote side of the connection,
+// or the X-Real-IP header if the connection comes from a trusted proxy (see subnet.RequestIP).
+func clientIP(req *http.Request) string {
+	ip := subnet.RequestIP(req)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
 // NotAllowedHandler handles requests that are not allowed.
 // If the request method is not GET or POST, it returns a "only GET/POST requests are allowed" error with status code 400.
 func NotAllowedHandler() http.HandlerFunc {
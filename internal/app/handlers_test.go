@@ -2,16 +2,23 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"github.com/yury-kuznetsov/shortener/internal/auth"
+	"github.com/yury-kuznetsov/shortener/internal/models"
 	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
 	"github.com/yury-kuznetsov/shortener/internal/uricoder"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestDecodeHandler(t *testing.T) {
@@ -21,8 +28,9 @@ func TestDecodeHandler(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	code1, _ := mapStorage.Set(ctx, "https://google.com", 0)
-	code2, _ := mapStorage.Set(ctx, "", 0)
+	code1, _ := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com"})
+	code2, _ := mapStorage.Set(ctx, models.Link{Code: "code2", URI: ""})
+	code3, _ := mapStorage.Set(ctx, models.Link{Code: "code3", URI: "https://ya.ru", ExpiresAt: time.Now().Add(-time.Second)})
 
 	tests := []struct {
 		name   string
@@ -35,9 +43,19 @@ func TestDecodeHandler(t *testing.T) {
 			status: http.StatusTemporaryRedirect,
 		},
 		{
-			name:   "bad request",
+			name:   "not found",
 			code:   code2,
-			status: http.StatusBadRequest,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "unknown code",
+			code:   "unknown",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "expired",
+			code:   code3,
+			status: http.StatusGone,
 		},
 	}
 
@@ -87,6 +105,554 @@ func TestEncodeHandler(t *testing.T) {
 	}
 }
 
+func TestEncodeJSONHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+
+	coder := uricoder.NewCoder(mapStorage)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{
+			name:   "google",
+			body:   `{"url":"https://google.com"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "alias",
+			body:   `{"url":"https://ya.ru","alias":"spring-sale"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "alias taken",
+			body:   `{"url":"https://site.com","alias":"spring-sale"}`,
+			status: http.StatusConflict,
+		},
+		{
+			name:   "reserved alias",
+			body:   `{"url":"https://site.com","alias":"api"}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(test.body))
+			EncodeJSONHandler(coder)(rec, req)
+			res := rec.Result()
+			require.Equal(t, test.status, res.StatusCode)
+			defer res.Body.Close()
+		})
+	}
+}
+
+func TestEncodeBatchHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+
+	coder := uricoder.NewCoder(mapStorage)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		urls   int
+	}{
+		{
+			name:   "created",
+			body:   `[{"correlation_id":"1","original_url":"https://google.com"},{"correlation_id":"2","original_url":"https://ya.ru","alias":"spring-sale"}]`,
+			status: http.StatusCreated,
+			urls:   2,
+		},
+		{
+			name:   "alias taken",
+			body:   `[{"correlation_id":"1","original_url":"https://site.com"},{"correlation_id":"2","original_url":"https://go.dev","alias":"spring-sale"}]`,
+			status: http.StatusConflict,
+			urls:   2,
+		},
+		{
+			name:   "incorrect URL",
+			body:   `[{"correlation_id":"1","original_url":"https://site.com"},{"correlation_id":"2","original_url":"site.com"}]`,
+			status: http.StatusBadRequest,
+			urls:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(test.body))
+			EncodeBatchHandler(coder)(rec, req)
+			res := rec.Result()
+			require.Equal(t, test.status, res.StatusCode)
+			defer res.Body.Close()
+
+			// пакет сохраняется целиком или не сохраняется совсем
+			stats, err := coder.GetStats(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, test.urls, stats.Urls)
+		})
+	}
+}
+
+func TestEncodeBatchHandlerPartial(t *testing.T) {
+	coder := uricoder.NewCoder(memory.NewStorage())
+
+	body := `[{"correlation_id":"1","original_url":"https://google.com","alias":"spring-sale"},` +
+		`{"correlation_id":"2","original_url":"site.com"},` +
+		`{"correlation_id":"3","original_url":"https://ya.ru","alias":"spring-sale"}]`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch?partial=true", strings.NewReader(body))
+	EncodeBatchHandler(coder)(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusMultiStatus, res.StatusCode)
+
+	var response []models.EncodeBatchResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	require.Len(t, response, 3)
+	assert.Equal(t, models.BatchStatusCreated, response[0].Status)
+	assert.True(t, strings.HasSuffix(response[0].ShortURL, "/spring-sale"))
+	assert.Equal(t, models.BatchStatusInvalid, response[1].Status)
+	assert.NotEmpty(t, response[1].Error)
+	assert.Equal(t, models.BatchStatusConflict, response[2].Status)
+	assert.NotEmpty(t, response[2].Error)
+}
+
+func TestUserUrlsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+
+	request := func(target string, userID string) *http.Response {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = withUser(req, userID)
+		UserUrlsHandler(coder)(rec, req)
+		return rec.Result()
+	}
+
+	// первая страница возвращает курсор следующей
+	res := request("/api/user/urls?limit=1", "1")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	cursor := res.Header.Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+	var response []models.GetByUserResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	require.Len(t, response, 1)
+	assert.True(t, strings.HasSuffix(response[0].ShortURL, "/code1"))
+
+	res = request("/api/user/urls?limit=1&cursor="+cursor, "1")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get("X-Next-Cursor"))
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	require.Len(t, response, 1)
+	assert.Equal(t, "https://ya.ru", response[0].OriginalURL)
+
+	tests := []struct {
+		name   string
+		target string
+		userID string
+		status int
+	}{
+		{name: "filtered out", target: "/api/user/urls?deleted=true", userID: "1", status: http.StatusNoContent},
+		{name: "other tag", target: "/api/user/urls?tag=sale", userID: "1", status: http.StatusNoContent},
+		{name: "incorrect limit", target: "/api/user/urls?limit=-1", userID: "1", status: http.StatusBadRequest},
+		{name: "incorrect order", target: "/api/user/urls?order=up", userID: "1", status: http.StatusBadRequest},
+		{name: "incorrect sort", target: "/api/user/urls?sort=name", userID: "1", status: http.StatusBadRequest},
+		{name: "incorrect cursor", target: "/api/user/urls?cursor=broken", userID: "1", status: http.StatusBadRequest},
+		{name: "anonymous", target: "/api/user/urls", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := request(tt.target, tt.userID)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
+
+func TestSearchUrlsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/pricing", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Set(ctx, models.Link{Code: "code2", URI: "https://site.com/about", UserID: 1})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		target string
+		userID string
+		status int
+		found  int
+	}{
+		{name: "found", target: "/api/user/urls/search?q=pricing", userID: "1", status: http.StatusOK, found: 1},
+		{name: "by code", target: "/api/user/urls/search?q=code", userID: "1", status: http.StatusOK, found: 2},
+		{name: "other user", target: "/api/user/urls/search?q=pricing", userID: "2", status: http.StatusNoContent},
+		{name: "empty query", target: "/api/user/urls/search?q=+", userID: "1", status: http.StatusBadRequest},
+		{name: "incorrect limit", target: "/api/user/urls/search?q=code&limit=x", userID: "1", status: http.StatusBadRequest},
+		{name: "anonymous", target: "/api/user/urls/search?q=pricing", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req = withUser(req, tt.userID)
+			SearchUrlsHandler(coder)(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+
+			if tt.status == http.StatusOK {
+				var response []models.GetByUserResponse
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+				assert.Len(t, response, tt.found)
+			}
+		})
+	}
+}
+
+func TestLinkStatsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	err = mapStorage.SaveClicks(ctx, []models.Click{{Code: "code1", Time: time.Now().Add(-time.Minute), IP: "10.0.0.1"}})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/user/urls/{code}/stats", LinkStatsHandler(coder))
+
+	tests := []struct {
+		name   string
+		target string
+		userID string
+		status int
+		clicks int
+	}{
+		{
+			name:   "owner",
+			target: "/api/user/urls/code1/stats",
+			userID: "1",
+			status: http.StatusOK,
+			clicks: 1,
+		},
+		{
+			name:   "time range",
+			target: "/api/user/urls/code1/stats?to=2024-05-01T00:00:00Z",
+			userID: "1",
+			status: http.StatusOK,
+			clicks: 0,
+		},
+		{
+			name:   "incorrect time",
+			target: "/api/user/urls/code1/stats?from=yesterday",
+			userID: "1",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "other user",
+			target: "/api/user/urls/code1/stats",
+			userID: "2",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unknown code",
+			target: "/api/user/urls/unknown/stats",
+			userID: "1",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "anonymous",
+			target: "/api/user/urls/code1/stats",
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req = withUser(req, tt.userID)
+			r.ServeHTTP(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+
+			if tt.status == http.StatusOK {
+				var stats models.LinkStats
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+				assert.Equal(t, tt.clicks, stats.Clicks)
+			}
+		})
+	}
+}
+
+func TestPatchLinkHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Patch("/api/user/urls/{code}", PatchLinkHandler(coder))
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		userID string
+		status int
+	}{
+		{name: "tags", target: "/api/user/urls/code1", body: `{"tags":["Sale","shoes"]}`, userID: "1", status: http.StatusNoContent},
+		{name: "url", target: "/api/user/urls/code1", body: `{"url":"https://google.com/new"}`, userID: "1", status: http.StatusNoContent},
+		{name: "incorrect url", target: "/api/user/urls/code1", body: `{"url":"google"}`, userID: "1", status: http.StatusBadRequest},
+		{
+			name:   "url and tags",
+			target: "/api/user/urls/code1",
+			body:   `{"url":"https://google.com/new","tags":["shoes","Sale"]}`,
+			userID: "1",
+			status: http.StatusNoContent,
+		},
+		{name: "incorrect tags", target: "/api/user/urls/code1", body: `{"tags":["a\tb"]}`, userID: "1", status: http.StatusBadRequest},
+		{
+			name:   "url with incorrect tags",
+			target: "/api/user/urls/code1",
+			body:   `{"url":"https://google.com/other","tags":["a\tb"]}`,
+			userID: "1",
+			status: http.StatusBadRequest,
+		},
+		{name: "nothing to change", target: "/api/user/urls/code1", body: `{}`, userID: "1", status: http.StatusBadRequest},
+		{name: "incorrect json", target: "/api/user/urls/code1", body: `{"tags":`, userID: "1", status: http.StatusBadRequest},
+		{name: "other user", target: "/api/user/urls/code1", body: `{"tags":[]}`, userID: "2", status: http.StatusForbidden},
+		{name: "unknown code", target: "/api/user/urls/unknown", body: `{"tags":[]}`, userID: "1", status: http.StatusNotFound},
+		{name: "anonymous", target: "/api/user/urls/code1", body: `{"tags":[]}`, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, tt.target, strings.NewReader(tt.body))
+			req = withUser(req, tt.userID)
+			r.ServeHTTP(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+
+	links, err := mapStorage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, []string{"sale", "shoes"}, links[0].Tags)
+	// запрос с неверными тегами не меняет и адрес
+	assert.Equal(t, "https://google.com/new", links[0].URI)
+}
+
+func TestRevisionsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Update(ctx, "code1", 1, "https://ya.ru", nil)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/user/urls/{code}/revisions", RevisionsHandler(coder))
+	r.Post("/api/user/urls/{code}/rollback", RollbackHandler(coder))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		userID string
+		status int
+	}{
+		{name: "revisions", method: http.MethodGet, target: "/api/user/urls/code1/revisions", userID: "1", status: http.StatusOK},
+		{name: "rollback", method: http.MethodPost, target: "/api/user/urls/code1/rollback", body: `{"revision":1}`, userID: "1", status: http.StatusOK},
+		{name: "unknown revision", method: http.MethodPost, target: "/api/user/urls/code1/rollback", body: `{"revision":9}`, userID: "1", status: http.StatusNotFound},
+		{name: "incorrect json", method: http.MethodPost, target: "/api/user/urls/code1/rollback", body: `{`, userID: "1", status: http.StatusBadRequest},
+		{name: "other user", method: http.MethodGet, target: "/api/user/urls/code1/revisions", userID: "2", status: http.StatusForbidden},
+		{name: "unknown code", method: http.MethodGet, target: "/api/user/urls/unknown/revisions", userID: "1", status: http.StatusNotFound},
+		{name: "anonymous", method: http.MethodPost, target: "/api/user/urls/code1/rollback", body: `{"revision":1}`, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req = withUser(req, tt.userID)
+			r.ServeHTTP(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+
+	// откат сохранен как третья ревизия
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls/code1/revisions", nil)
+	req = withUser(req, "1")
+	r.ServeHTTP(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var revisions []models.Revision
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&revisions))
+	require.Len(t, revisions, 3)
+	assert.Equal(t, "https://google.com", revisions[2].URI)
+}
+
+func TestTagsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.SetBatch(ctx, []models.Link{
+		{Code: "code1", URI: "https://site.com/shoes", UserID: 1, Tags: []string{"sale", "shoes"}},
+		{Code: "code2", URI: "https://site.com/hats", UserID: 1, Tags: []string{"sale"}},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		userID string
+		status int
+		want   []models.TagCount
+	}{
+		{name: "tags", userID: "1", status: http.StatusOK, want: []models.TagCount{{Tag: "sale", Links: 2}, {Tag: "shoes", Links: 1}}},
+		{name: "no tags", userID: "2", status: http.StatusNoContent},
+		{name: "anonymous", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/tags", nil)
+			req = withUser(req, tt.userID)
+			TagsHandler(coder)(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+
+			if tt.status == http.StatusOK {
+				var counts []models.TagCount
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&counts))
+				assert.Equal(t, tt.want, counts)
+			}
+		})
+	}
+}
+
+func TestRestoreUrlsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, mapStorage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}))
+
+	tests := []struct {
+		name     string
+		body     string
+		userID   string
+		status   int
+		restored []string
+	}{
+		{name: "other user", body: `["code1"]`, userID: "2", status: http.StatusOK, restored: []string{}},
+		{name: "restored", body: `["code1","code2","unknown"]`, userID: "1", status: http.StatusOK, restored: []string{"code1"}},
+		{name: "incorrect json", body: `{`, userID: "1", status: http.StatusBadRequest},
+		{name: "anonymous", body: `["code1"]`, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(tt.body))
+			req = withUser(req, tt.userID)
+			RestoreUrlsHandler(coder)(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+
+			if tt.status == http.StatusOK {
+				var restored []string
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&restored))
+				assert.Equal(t, tt.restored, restored)
+			}
+		})
+	}
+
+	uri, err := coder.ToURI(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", uri)
+}
+
+func TestGetStatsHandler(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	_, err = mapStorage.Set(ctx, models.Link{Code: "code3", URI: "https://site.com", UserID: 2})
+	require.NoError(t, err)
+	require.NoError(t, mapStorage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	GetStatsHandler(coder)(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var stats models.GetStatsResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	assert.Equal(t, 3, stats.Urls)
+	assert.Equal(t, 2, stats.Live)
+	assert.Equal(t, 1, stats.Deleted)
+	assert.Equal(t, 2, stats.Users)
+	assert.Equal(t, []models.UserCount{{UserID: 1, Links: 2}, {UserID: 2, Links: 1}}, stats.TopUsers)
+	require.Len(t, stats.CreatedPerDay, 1)
+	assert.Equal(t, 3, stats.CreatedPerDay[0].Links)
+	assert.Equal(t, 0, stats.DeletionQueue)
+	assert.Equal(t, "memory", stats.Backend)
+	assert.Nil(t, stats.Cache)
+}
+
 func TestNotAllowedHandler(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -151,3 +717,273 @@ func TestNotAllowedHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestRegisterAndLoginHandlers(t *testing.T) {
+	coder := uricoder.NewCoder(memory.NewStorage(), uricoder.WithPasswordCost(bcrypt.MinCost))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+	}{
+		{name: "register", handler: RegisterHandler(coder), body: `{"login":"alice","password":"password1"}`, status: http.StatusOK},
+		{name: "login taken", handler: RegisterHandler(coder), body: `{"login":"alice","password":"password2"}`, status: http.StatusConflict},
+		{name: "short password", handler: RegisterHandler(coder), body: `{"login":"bob","password":"1"}`, status: http.StatusBadRequest},
+		{name: "incorrect json", handler: RegisterHandler(coder), body: `{`, status: http.StatusBadRequest},
+		{name: "login", handler: LoginHandler(coder), body: `{"login":"alice","password":"password1"}`, status: http.StatusOK},
+		{name: "wrong password", handler: LoginHandler(coder), body: `{"login":"alice","password":"password2"}`, status: http.StatusUnauthorized},
+		{name: "unknown login", handler: LoginHandler(coder), body: `{"login":"bob","password":"password1"}`, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(tt.body))
+			tt.handler(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+
+			// при успехе клиент получает куку с токеном пользователя
+			if tt.status == http.StatusOK {
+				require.Len(t, res.Cookies(), 1)
+				assert.Equal(t, res.Cookies()[0].Value, res.Header.Get("Authorization"))
+			} else {
+				assert.Empty(t, res.Cookies())
+			}
+		})
+	}
+
+	// регистрация и вход выдают токен одного и того же пользователя
+	var identities []auth.Identity
+	for _, handler := range []http.HandlerFunc{RegisterHandler(coder), LoginHandler(coder)} {
+		rec := httptest.NewRecorder()
+		body := `{"login":"carol","password":"password1"}`
+		handler(rec, httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(body)))
+		res := rec.Result()
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		req.AddCookie(res.Cookies()[0])
+		auth.NewMiddleware(coder).Handle(func(res http.ResponseWriter, req *http.Request) {
+			identities = append(identities, auth.FromContext(req.Context()))
+		}, false)(httptest.NewRecorder(), req)
+	}
+	require.Len(t, identities, 2)
+	assert.Equal(t, identities[0], identities[1])
+	assert.GreaterOrEqual(t, identities[0].UserID, models.FirstUserID)
+}
+
+func TestRegisterHandlerClaimsLinks(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage, uricoder.WithPasswordCost(bcrypt.MinCost))
+	authn := auth.NewMiddleware(coder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// анонимный посетитель сокращает ссылку и получает куку
+	rec := httptest.NewRecorder()
+	authn.Handle(EncodeHandler(coder), true)(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://google.com")))
+	res := rec.Result()
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Len(t, res.Cookies(), 1)
+	anonymous := res.Cookies()[0]
+
+	// при регистрации с этой кукой ссылка переходит к аккаунту
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":"alice","password":"password1"}`))
+	req.AddCookie(anonymous)
+	authn.Handle(RegisterHandler(coder), false)(rec, req)
+	res = rec.Result()
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, res.Cookies(), 1)
+
+	user, err := mapStorage.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	links, err := mapStorage.GetByUser(ctx, user.ID, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "https://google.com", links[0].URI)
+
+	// анонимный пользователь удален, повторный вход с его кукой ничего не переносит
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"alice","password":"password1"}`))
+	req.AddCookie(anonymous)
+	authn.Handle(LoginHandler(coder), false)(rec, req)
+	res = rec.Result()
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestUserUrlsHandlerSpoofedHeader(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	// без куки заголовок клиента не дает доступа к чужим ссылкам
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req.Header.Set(auth.UserIDHeader, "1")
+	auth.NewMiddleware(coder).Handle(UserUrlsHandler(coder), false)(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	coder := uricoder.NewCoder(memory.NewStorage())
+	authn := auth.NewMiddleware(coder)
+
+	r := chi.NewRouter()
+	r.Post("/api/user/keys", authn.Handle(CreateAPIKeyHandler(coder), false))
+	r.Get("/api/user/keys", authn.Handle(APIKeysHandler(coder), false))
+	r.Delete("/api/user/keys/{id}", authn.Handle(RevokeAPIKeyHandler(coder), false))
+
+	alice, err := auth.NewToken(models.FirstUserID)
+	require.NoError(t, err)
+	bob, err := auth.NewToken(models.FirstUserID + 1)
+	require.NoError(t, err)
+	anonymous, err := auth.NewAnonymousToken(models.User{ID: models.FirstUserID + 2, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	send := func(method, target, body, cookie, key string) *http.Response {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: cookie})
+		}
+		if key != "" {
+			req.Header.Set("Authorization", auth.BearerPrefix+key)
+		}
+		r.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	// ключ показывается один раз при создании
+	res := send(http.MethodPost, "/api/user/keys", `{"name":"ci","scopes":["links:read"]}`, alice, "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created models.NewAPIKeyResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	assert.NotEmpty(t, created.Key)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		cookie string
+		key    string
+		status int
+	}{
+		{name: "unknown scope", method: http.MethodPost, target: "/api/user/keys", body: `{"scopes":["admin"]}`, cookie: alice, status: http.StatusBadRequest},
+		{name: "incorrect json", method: http.MethodPost, target: "/api/user/keys", body: `{`, cookie: alice, status: http.StatusBadRequest},
+		{name: "guest", method: http.MethodPost, target: "/api/user/keys", body: `{"scopes":["links:read"]}`, status: http.StatusUnauthorized},
+		{name: "anonymous", method: http.MethodGet, target: "/api/user/keys", cookie: anonymous, status: http.StatusUnauthorized},
+		{name: "by API key", method: http.MethodGet, target: "/api/user/keys", key: created.Key, status: http.StatusForbidden},
+		{name: "unknown API key", method: http.MethodGet, target: "/api/user/keys", key: "shk_unknown", status: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, target: "/api/user/keys", cookie: alice, status: http.StatusOK},
+		{name: "empty list", method: http.MethodGet, target: "/api/user/keys", cookie: bob, status: http.StatusNoContent},
+		{name: "revoke other user", method: http.MethodDelete, target: "/api/user/keys/" + created.ID, cookie: bob, status: http.StatusForbidden},
+		{name: "revoke", method: http.MethodDelete, target: "/api/user/keys/" + created.ID, cookie: alice, status: http.StatusNoContent},
+		{name: "revoke unknown", method: http.MethodDelete, target: "/api/user/keys/" + created.ID, cookie: alice, status: http.StatusNotFound},
+		{name: "revoked API key", method: http.MethodGet, target: "/api/user/keys", key: created.Key, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(tt.method, tt.target, tt.body, tt.cookie, tt.key)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	mapStorage := memory.NewStorage()
+	coder := uricoder.NewCoder(mapStorage)
+	authn := auth.NewMiddleware(coder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := mapStorage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: models.FirstUserID})
+	require.NoError(t, err)
+	created, err := coder.CreateAPIKey(ctx, models.FirstUserID, models.APIKeyRequest{Scopes: []string{models.ScopeLinksRead}})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Post("/", authn.Handle(EncodeHandler(coder), true))
+	r.Get("/api/user/urls", authn.Handle(UserUrlsHandler(coder), false))
+	r.Get("/api/user/urls/{code}/stats", authn.Handle(LinkStatsHandler(coder), false))
+	r.Delete("/api/user/urls", authn.Handle(DeleteUrlsHandler(coder), true))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "read", method: http.MethodGet, target: "/api/user/urls", status: http.StatusOK},
+		{name: "write", method: http.MethodPost, target: "/", body: "https://ya.ru", status: http.StatusForbidden},
+		{name: "stats", method: http.MethodGet, target: "/api/user/urls/code1/stats", status: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, target: "/api/user/urls", body: `["code1"]`, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", auth.BearerPrefix+created.Key)
+			r.ServeHTTP(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+			// запрос с API-ключом не создает анонимного пользователя
+			assert.Empty(t, res.Cookies())
+		})
+	}
+}
+
+// withUser возвращает запрос, в контексте которого передан пользователь.
+func withUser(req *http.Request, userID string) *http.Request {
+	id, _ := strconv.Atoi(userID)
+	return req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{UserID: id}))
+}
+
+func TestClientIP(t *testing.T) {
+	config.Options.TrustedProxies = "10.0.0.1/32"
+	defer func() {
+		config.Options.TrustedProxies = ""
+	}()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.7:1234", want: "192.0.2.7"},
+		// заголовок клиента, не являющегося прокси, игнорируется
+		{name: "spoofed header", remoteAddr: "192.0.2.7:1234", realIP: "203.0.113.9", want: "192.0.2.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", realIP: "203.0.113.9", want: "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/code", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, clientIP(req))
+		})
+	}
+}
@@ -1,94 +1,216 @@
 package auth
 
 import (
-	"fmt"
-	"math/rand"
+	"context"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/yury-kuznetsov/shortener/internal/errmap"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/uricoder"
 )
 
 // Claims represents the custom claims for a JWT token, which includes the standard RegisteredClaims and an additional UserID field.
+// The token of an anonymous user has the Anonymous flag and the UUID of the user in the subject.
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int
+	UserID    int
+	Anonymous bool `json:",omitempty"`
 }
 
-// TokenExp represents the expiration time for a token.
+// TokenExp represents the expiration time for a token of a registered user.
+// The token of an anonymous user expires together with the user.
 const TokenExp = time.Hour
 
-// SecretKey is a constant value used for signing and validating JWT tokens.
-// It should be kept secret and not shared publicly.
+// keys is the set of the keys which signs and verifies the tokens.
+// Until SetKeys is called, it is a random key, so the tokens do not survive a restart.
+var keys = mustRandomKeySet()
+
+// SetKeys sets the set of the keys which signs and verifies the tokens (see KeySet).
+// It should be called before the servers start.
+// Example usage:
+//
+//	keys, err := auth.LoadKeyFile("/etc/shortener/jwt-keys.json")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	auth.SetKeys(keys)
+func SetKeys(set *KeySet) {
+	keys = set
+}
+
+func mustRandomKeySet() *KeySet {
+	set, err := NewRandomKeySet()
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// Users is an interface of the source of the users (it is implemented by uricoder.Coder).
+// CreateAnonymous stores a new anonymous user and returns it.
+// AuthenticateAPIKey returns the stored API key for the key sent by a client
+// or an error of the models.ErrUnauthorized kind if the key is unknown.
+type Users interface {
+	CreateAnonymous(ctx context.Context) (models.User, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error)
+}
+
+// BearerPrefix is the prefix of the API key or the token in the Authorization header of a request.
+const BearerPrefix = "Bearer "
+
+// BearerCredentials splits the value of the Authorization header "Bearer <credentials>": it returns the API key
+// if the credentials have the prefix of the API keys (see uricoder.APIKeyPrefix), otherwise it returns
+// the credentials as the token, which the server sends in the Authorization header of the response.
+// Both are empty for another or an empty header.
+func BearerCredentials(authorization string) (apiKey string, token string) {
+	credentials, ok := strings.CutPrefix(authorization, BearerPrefix)
+	if !ok {
+		return "", ""
+	}
+	if strings.HasPrefix(credentials, uricoder.APIKeyPrefix) {
+		return credentials, ""
+	}
+	return "", credentials
+}
+
+// Middleware authenticates the requests by the API key in the Authorization header or by the token cookie
+// and creates the anonymous users for the new visitors.
 // Example usage:
-//   - In getUserID function, SecretKey is used as the key to validate the token and extract the user ID from it.
-//   - In buildToken function, SecretKey is used as the key to sign the token.
 //
-// Type: string
-const SecretKey = "SECRET_KEY"
+//	authn := auth.NewMiddleware(coder)
+//	r.Get("/api/user/urls", authn.Handle(handlers.UserUrlsHandler(coder), false))
+type Middleware struct {
+	users Users
+}
+
+// NewMiddleware creates a new Middleware, which stores the anonymous users in the given source.
+func NewMiddleware(users Users) *Middleware {
+	return &Middleware{users: users}
+}
 
 // Handle проверяет наличие и подлинность куки.
-// В случае неудачи создает новую куку, если `create` = true.
-func Handle(handler http.HandlerFunc, create bool) http.HandlerFunc {
+// В случае неудачи создает нового анонимного пользователя и его куку, если `create` = true.
+// Если в заголовке Authorization передан API-ключ, кука не используется: неизвестный ключ
+// отклоняется с 401 Unauthorized. Токен, переданный в заголовке Authorization (как его выдает сервер),
+// принимается так же, как кука, если куки нет.
+// Пользователь передается обработчику в контексте запроса (см. FromContext).
+func (m *Middleware) Handle(handler http.HandlerFunc, create bool) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
+		// идентификатор от клиента не принимаем ни в каком виде
+		req.Header.Del(UserIDHeader)
+		identity := Identity{}
+
+		key, token := BearerCredentials(req.Header.Get("Authorization"))
+		if key != "" {
+			apiKey, err := m.users.AuthenticateAPIKey(req.Context(), key)
+			if err != nil {
+				http.Error(res, err.Error(), errmap.HTTPStatus(err))
+				return
+			}
+			identity = Identity{UserID: apiKey.UserID, KeyID: apiKey.ID, Scopes: apiKey.Scopes}
+			handler(res, req.WithContext(WithIdentity(req.Context(), identity)))
+			return
+		}
+
 		cookie, _ := req.Cookie("token")
 
+		if cookie == nil && token != "" {
+			// клиент вернул токен из заголовка Authorization ответа
+			if identity = ParseToken(token); !identity.IsGuest() {
+				handler(res, req.WithContext(WithIdentity(req.Context(), identity)))
+				return
+			}
+		}
+
 		if cookie == nil && create {
-			if token, _ := buildToken(); token != "" {
-				cookie = &http.Cookie{
-					Name:    "token",
-					Value:   token,
-					Expires: time.Now().Add(TokenExp),
+			// без пользователя запрос обрабатывается как гостевой
+			if user, err := m.users.CreateAnonymous(req.Context()); err == nil {
+				if token, _ := NewAnonymousToken(user); token != "" {
+					cookie = tokenCookie(token, user.ExpiresAt)
 				}
 			}
 		}
 
 		if cookie != nil {
-			userID := getUserID(cookie.Value)
-			req.Header.Set("Content-User-ID", strconv.Itoa(userID))
+			identity = ParseToken(cookie.Value)
 			res.Header().Set("Authorization", cookie.Value)
 			http.SetCookie(res, cookie)
 		}
 
-		handler(res, req)
+		handler(res, req.WithContext(WithIdentity(req.Context(), identity)))
 	}
 
 	return handlerFunc
 }
 
-func getUserID(tokenString string) int {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return []byte(SecretKey), nil
-	})
+// Authorize issues a new token for the registered user and sends it in the cookie and in the Authorization header.
+// The cookie replaces the one sent by Middleware.Handle, if there is one.
+// Example usage:
+//
+//	if err := auth.Authorize(res, userID); err != nil {
+//	    http.Error(res, err.Error(), http.StatusInternalServerError)
+//	    return
+//	}
+func Authorize(res http.ResponseWriter, userID int) error {
+	token, err := NewToken(userID)
 	if err != nil {
-		return 0
-	}
-
-	if !token.Valid {
-		fmt.Println("Token is not valid")
-		return 0
+		return err
 	}
-
-	return claims.UserID
+	res.Header().Del("Set-Cookie")
+	res.Header().Set("Authorization", token)
+	http.SetCookie(res, tokenCookie(token, time.Now().Add(TokenExp)))
+	return nil
 }
 
-func buildToken() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// NewToken returns a new signed token with Claims of the registered user, which expires after TokenExp.
+func NewToken(userID int) (string, error) {
+	return signToken(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExp)),
 		},
-		UserID: rand.Intn(1000),
+		UserID: userID,
+	})
+}
+
+// NewAnonymousToken returns a new signed token with Claims of the anonymous user, which expires with the user.
+func NewAnonymousToken(user models.User) (string, error) {
+	return signToken(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.UUID,
+			ExpiresAt: jwt.NewNumericDate(user.ExpiresAt),
+		},
+		UserID:    user.ID,
+		Anonymous: true,
 	})
+}
 
-	tokenString, err := token.SignedString([]byte(SecretKey))
+// ParseToken verifies the token with the keys set by SetKeys and returns the identity of its user.
+// It returns the guest identity if the token is invalid or expired.
+func ParseToken(tokenString string) Identity {
+	claims := &Claims{}
+	token, err := keys.Parse(tokenString, claims)
 	if err != nil {
-		return "", err
+		return Identity{}
+	}
+
+	if !token.Valid {
+		return Identity{}
 	}
 
-	return tokenString, nil
+	return Identity{UserID: claims.UserID, Anonymous: claims.Anonymous}
+}
+
+func signToken(claims Claims) (string, error) {
+	return keys.Sign(claims)
+}
+
+func tokenCookie(token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:    "token",
+		Value:   token,
+		Expires: expires,
+	}
 }
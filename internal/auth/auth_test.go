@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// stubUsers выдает анонимных пользователей с последовательными идентификаторами
+// и принимает единственный API-ключ.
+type stubUsers struct {
+	nextID int
+	err    error
+	key    string
+	apiKey models.APIKey
+}
+
+func (s *stubUsers) CreateAnonymous(ctx context.Context) (models.User, error) {
+	if s.err != nil {
+		return models.User{}, s.err
+	}
+	s.nextID++
+	return models.User{ID: s.nextID, UUID: "uuid", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (s *stubUsers) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	if key != s.key {
+		return models.APIKey{}, models.NewError("invalid API key", models.ErrUnauthorized)
+	}
+	return s.apiKey, nil
+}
+
+func TestHandle(t *testing.T) {
+	const userID = 5
+	token, err := NewToken(userID)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		cookie   string
+		header   string
+		identity Identity
+	}{
+		{name: "spoofed header without cookie", header: "42"},
+		{name: "spoofed header with cookie", cookie: token, header: "42", identity: Identity{UserID: userID}},
+		{name: "cookie", cookie: token, identity: Identity{UserID: userID}},
+		{name: "invalid cookie", cookie: "broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var identity Identity
+			var header string
+			handler := func(res http.ResponseWriter, req *http.Request) {
+				identity = FromContext(req.Context())
+				header = req.Header.Get(UserIDHeader)
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			if tt.header != "" {
+				req.Header.Set(UserIDHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: tt.cookie})
+			}
+			NewMiddleware(&stubUsers{}).Handle(handler, false)(rec, req)
+
+			// заголовок клиента всегда удаляется
+			assert.Empty(t, header)
+			assert.Equal(t, tt.identity, identity)
+		})
+	}
+}
+
+func TestHandleNewCookie(t *testing.T) {
+	users := &stubUsers{nextID: models.FirstUserID}
+	var identity Identity
+	handler := func(res http.ResponseWriter, req *http.Request) {
+		identity = FromContext(req.Context())
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	NewMiddleware(users).Handle(handler, true)(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+
+	// новый посетитель получает сохраненного анонимного пользователя
+	assert.Equal(t, Identity{UserID: models.FirstUserID + 1, Anonymous: true}, identity)
+	require.Len(t, res.Cookies(), 1)
+	assert.Equal(t, identity, ParseToken(res.Cookies()[0].Value))
+
+	// если пользователя создать не удалось, запрос обрабатывается как гостевой
+	users.err = errors.New("storage is down")
+	rec = httptest.NewRecorder()
+	NewMiddleware(users).Handle(handler, true)(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.True(t, identity.IsGuest())
+}
+
+func TestHandleAPIKey(t *testing.T) {
+	users := &stubUsers{
+		key:    "shk_key",
+		apiKey: models.APIKey{ID: "k1", UserID: models.FirstUserID, Scopes: []string{models.ScopeLinksRead}},
+	}
+	token, err := NewToken(models.FirstUserID + 1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		identity      Identity
+	}{
+		{
+			name:          "valid key",
+			authorization: "Bearer shk_key",
+			status:        http.StatusOK,
+			identity:      Identity{UserID: models.FirstUserID, KeyID: "k1", Scopes: []string{models.ScopeLinksRead}},
+		},
+		{name: "unknown key", authorization: "Bearer shk_other", status: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "Basic shk_key", status: http.StatusOK, identity: Identity{UserID: models.FirstUserID + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var identity Identity
+			handler := func(res http.ResponseWriter, req *http.Request) {
+				identity = FromContext(req.Context())
+			}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+			NewMiddleware(users).Handle(handler, true)(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, tt.identity, identity)
+			if tt.identity.IsAPIKey() {
+				// с API-ключом кука не используется и не выдается
+				assert.Empty(t, res.Cookies())
+			}
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	assert.True(t, Identity{UserID: 7}.Allows(models.ScopeStatsRead))
+	assert.True(t, Identity{}.Allows(models.ScopeLinksWrite))
+
+	identity := Identity{UserID: 7, KeyID: "k1", Scopes: []string{models.ScopeLinksRead}}
+	assert.True(t, identity.Allows(models.ScopeLinksRead))
+	assert.False(t, identity.Allows(models.ScopeLinksWrite))
+}
+
+func TestNewAnonymousToken(t *testing.T) {
+	token, err := NewAnonymousToken(models.User{ID: 7, UUID: "uuid", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+
+	// токен истекает вместе с анонимным пользователем
+	assert.True(t, ParseToken(token).IsGuest())
+}
+
+func TestAuthorize(t *testing.T) {
+	rec := httptest.NewRecorder()
+	http.SetCookie(rec, &http.Cookie{Name: "token", Value: "anonymous"})
+	require.NoError(t, Authorize(rec, models.FirstUserID))
+	res := rec.Result()
+	defer res.Body.Close()
+
+	// новая кука заменяет куку анонимного пользователя
+	require.Len(t, res.Cookies(), 1)
+	assert.Equal(t, "token", res.Cookies()[0].Name)
+	assert.Equal(t, res.Cookies()[0].Value, res.Header.Get("Authorization"))
+	assert.Equal(t, Identity{UserID: models.FirstUserID}, ParseToken(res.Cookies()[0].Value))
+}
+
+func TestFromContext(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.True(t, FromContext(req.Context()).IsGuest())
+
+	ctx := WithIdentity(req.Context(), Identity{UserID: 7})
+	assert.Equal(t, Identity{UserID: 7}, FromContext(ctx))
+}
+
+func TestHandleBearerToken(t *testing.T) {
+	users := &stubUsers{key: "shk_key"}
+	token, err := NewToken(models.FirstUserID + 1)
+	require.NoError(t, err)
+
+	var identity Identity
+	handler := func(res http.ResponseWriter, req *http.Request) {
+		identity = FromContext(req.Context())
+	}
+
+	// токен из заголовка Authorization ответа, отправленный обратно без куки, не считается API-ключом
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req.Header.Set("Authorization", BearerPrefix+token)
+	NewMiddleware(users).Handle(handler, true)(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, Identity{UserID: models.FirstUserID + 1}, identity)
+	assert.Zero(t, users.nextID)
+}
+
+func TestBearerCredentials(t *testing.T) {
+	tests := []struct {
+		authorization string
+		apiKey        string
+		token         string
+	}{
+		{authorization: "Bearer shk_key", apiKey: "shk_key"},
+		{authorization: "Bearer eyJhbGciOi", token: "eyJhbGciOi"},
+		{authorization: "Basic shk_key"},
+		{authorization: ""},
+	}
+	for _, tt := range tests {
+		apiKey, token := BearerCredentials(tt.authorization)
+		assert.Equal(t, tt.apiKey, apiKey, tt.authorization)
+		assert.Equal(t, tt.token, token, tt.authorization)
+	}
+}
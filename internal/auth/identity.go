@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+type contextKey string
+
+// KeyUserID is the key of the Identity of the user in the request context.
+// It is shared by the HTTP middleware and the gRPC interceptor,
+// so the handlers of both servers read the user in the same way.
+const KeyUserID contextKey = "USER_ID"
+
+// UserIDHeader is the name of the header which was used to pass the user ID to the handlers.
+// The middleware always removes it from the incoming requests, so it cannot be spoofed by a client.
+const UserIDHeader = "Content-User-ID"
+
+// Identity is a struct representing the authenticated user of a request.
+// Anonymous is set for an anonymous user created for the visitor by the cookie (see Middleware).
+// KeyID and Scopes are set for a request authorized by an API key of the user.
+// The zero value means a guest without any user.
+type Identity struct {
+	UserID    int
+	Anonymous bool
+	KeyID     string
+	Scopes    []string
+}
+
+// IsGuest reports whether the identity has no user.
+func (i Identity) IsGuest() bool {
+	return i.UserID == 0
+}
+
+// IsAPIKey reports whether the request is authorized by an API key.
+func (i Identity) IsAPIKey() bool {
+	return i.KeyID != ""
+}
+
+// Allows reports whether the request may use the scope (see models.Scopes).
+// The requests authorized by the cookie and the guests may use any scope,
+// the requests authorized by an API key only the scopes of the key.
+//
+// Example usage:
+//
+//	if !auth.FromContext(ctx).Allows(models.ScopeLinksRead) {
+//	    // answer 403 Forbidden
+//	}
+func (i Identity) Allows(scope string) bool {
+	return !i.IsAPIKey() || slices.Contains(i.Scopes, scope)
+}
+
+// WithIdentity returns a copy of the context carrying the identity.
+//
+// Example usage:
+//
+//	ctx = auth.WithIdentity(ctx, auth.Identity{UserID: 1})
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, KeyUserID, identity)
+}
+
+// FromContext returns the identity carried by the context.
+// It returns the anonymous identity if the context carries none.
+//
+// Example usage:
+//
+//	userID := auth.FromContext(req.Context()).UserID
+func FromContext(ctx context.Context) Identity {
+	identity, _ := ctx.Value(KeyUserID).(Identity)
+	return identity
+}
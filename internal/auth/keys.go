@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DefaultKeyID is the kid of the key built from a single configured secret.
+const DefaultKeyID = "default"
+
+// MinSecretLength is the minimal length of a secret of the HS256 keys in bytes.
+const MinSecretLength = 32
+
+// ErrNoSigningKey is returned when a key set has no key which can sign the tokens.
+var ErrNoSigningKey = errors.New("no signing key")
+
+// Key is a key of the token signatures identified by the kid header of the tokens.
+// A key of the HS256 algorithm both signs and verifies the tokens. A key of the RS256 or EdDSA algorithm
+// signs the tokens with the private key and verifies them with the public key, which may be given
+// to other services; a key with only the public key verifies the tokens only.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+// NewHMACKey returns the HS256 key with the given kid and secret.
+// The secret must be at least MinSecretLength bytes long.
+// Example usage:
+//
+//	key, err := auth.NewHMACKey(auth.DefaultKeyID, []byte(os.Getenv("JWT_SECRET")))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+func NewHMACKey(id string, secret []byte) (Key, error) {
+	if len(secret) < MinSecretLength {
+		return Key{}, fmt.Errorf("key %q: secret is shorter than %d bytes", id, MinSecretLength)
+	}
+	return Key{ID: id, method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+}
+
+// CanSign reports whether the key has the secret or the private key to sign the tokens.
+func (k Key) CanSign() bool {
+	return k.sign != nil
+}
+
+// KeySet is a set of the keys of the token signatures, which allows to rotate them:
+// the tokens are signed with the newest key which can sign, and verified with the key from their kid header.
+// To rotate the keys, a new key is appended to the set; the previous key is removed (retired)
+// after the tokens signed with it have expired.
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeySet returns the set of the keys listed from the oldest to the newest.
+// It returns an error if the kids of the keys are not unique and ErrNoSigningKey if none of the keys can sign.
+func NewKeySet(keys ...Key) (*KeySet, error) {
+	s := &KeySet{keys: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if _, ok := s.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key.ID)
+		}
+		s.keys[key.ID] = key
+		if key.CanSign() {
+			s.signing = key
+		}
+	}
+	if !s.signing.CanSign() {
+		return nil, ErrNoSigningKey
+	}
+	return s, nil
+}
+
+// NewRandomKeySet returns the set of a single HS256 key with a random secret.
+// It is used when no key is configured: the tokens signed with it become invalid after a restart.
+func NewRandomKeySet() (*KeySet, error) {
+	secret := make([]byte, MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key, err := NewHMACKey("random", secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(key)
+}
+
+// keyFile is the format of the key file (see LoadKeyFile).
+type keyFile struct {
+	Keys []struct {
+		ID             string `json:"kid"`
+		Algorithm      string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+		Retired        bool   `json:"retired"`
+	} `json:"keys"`
+}
+
+// LoadKeyFile reads the set of the keys from the JSON file. The keys are listed from the oldest to the newest:
+//
+//	{"keys": [
+//	    {"kid": "2024-01", "alg": "HS256", "secret": "...", "retired": true},
+//	    {"kid": "2024-02", "alg": "HS256", "secret": "..."},
+//	    {"kid": "2024-03", "alg": "EdDSA", "private_key_file": "ed25519.pem"},
+//	    {"kid": "partner", "alg": "RS256", "public_key_file": "partner.pub.pem"}
+//	]}
+//
+// The algorithm is HS256 (the secret is required), RS256 or EdDSA (the PEM files are required,
+// the public key is derived from the private one if only the latter is given).
+// The paths of the PEM files are relative to the directory of the key file.
+// The retired keys neither sign nor verify the tokens, their files are not read.
+func LoadKeyFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	ids := make(map[string]bool, len(file.Keys))
+	keys := make([]Key, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		// идентификатор не должен повторяться даже у выведенного из работы ключа
+		if entry.ID == "" || ids[entry.ID] {
+			return nil, fmt.Errorf("key file %s: empty or duplicate kid %q", path, entry.ID)
+		}
+		ids[entry.ID] = true
+		if entry.Retired {
+			continue
+		}
+
+		var key Key
+		switch entry.Algorithm {
+		case jwt.SigningMethodHS256.Alg():
+			key, err = NewHMACKey(entry.ID, []byte(entry.Secret))
+		case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+			key, err = loadAsymmetricKey(entry.ID, entry.Algorithm,
+				resolvePath(dir, entry.PrivateKeyFile), resolvePath(dir, entry.PublicKeyFile))
+		default:
+			err = fmt.Errorf("key %q: unsupported algorithm %q", entry.ID, entry.Algorithm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...)
+}
+
+// loadAsymmetricKey reads the RS256 or EdDSA key from the PEM files (an empty path means no file).
+func loadAsymmetricKey(id, alg, privatePath, publicPath string) (Key, error) {
+	if privatePath == "" && publicPath == "" {
+		return Key{}, fmt.Errorf("key %q: private or public key file is required", id)
+	}
+	key := Key{ID: id, method: jwt.GetSigningMethod(alg)}
+
+	if privatePath != "" {
+		data, err := os.ReadFile(privatePath)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", id, err)
+		}
+		if alg == jwt.SigningMethodRS256.Alg() {
+			private, errParse := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if errParse != nil {
+				return Key{}, fmt.Errorf("key %q: %w", id, errParse)
+			}
+			key.sign, key.verify = private, &private.PublicKey
+		} else {
+			private, errParse := jwt.ParseEdPrivateKeyFromPEM(data)
+			if errParse != nil {
+				return Key{}, fmt.Errorf("key %q: %w", id, errParse)
+			}
+			key.sign, key.verify = private, private.(ed25519.PrivateKey).Public()
+		}
+	}
+
+	if publicPath != "" {
+		data, err := os.ReadFile(publicPath)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", id, err)
+		}
+		if alg == jwt.SigningMethodRS256.Alg() {
+			key.verify, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		} else {
+			key.verify, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: %w", id, err)
+		}
+	}
+
+	return key, nil
+}
+
+// resolvePath returns the path relative to the directory (an empty path stays empty).
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Sign returns the token with the claims signed with the newest key, which is named in the kid header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.sign)
+}
+
+// Parse verifies the token with the key named in its kid header and reads its claims.
+// The tokens without the kid header, with an unknown kid or with another algorithm than the one of the key
+// are rejected.
+func (s *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		id, _ := t.Header["kid"].(string)
+		key, ok := s.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown key: %q", id)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key.verify, nil
+	})
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, err := NewHMACKey("old", []byte(strings.Repeat("o", MinSecretLength)))
+	require.NoError(t, err)
+	newKey, err := NewHMACKey("new", []byte(strings.Repeat("n", MinSecretLength)))
+	require.NoError(t, err)
+
+	before, err := NewKeySet(oldKey)
+	require.NoError(t, err)
+	during, err := NewKeySet(oldKey, newKey)
+	require.NoError(t, err)
+	after, err := NewKeySet(newKey)
+	require.NoError(t, err)
+
+	oldToken, err := before.Sign(testClaims(7))
+	require.NoError(t, err)
+	newToken, err := during.Sign(testClaims(7))
+	require.NoError(t, err)
+
+	// подписывает самый новый ключ, проверяет любой из набора
+	token, err := during.Parse(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", token.Header["kid"])
+	_, err = during.Parse(oldToken, &Claims{})
+	assert.NoError(t, err)
+
+	// после вывода ключа из работы его токены не принимаются
+	_, err = after.Parse(oldToken, &Claims{})
+	assert.Error(t, err)
+	_, err = after.Parse(newToken, &Claims{})
+	assert.NoError(t, err)
+}
+
+func TestKeySetParse(t *testing.T) {
+	secret := []byte(strings.Repeat("s", MinSecretLength))
+	key, err := NewHMACKey(DefaultKeyID, secret)
+	require.NoError(t, err)
+	set, err := NewKeySet(key)
+	require.NoError(t, err)
+
+	// токен без kid, как у прежних версий
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims(7)).SignedString(secret)
+	require.NoError(t, err)
+	// токен с kid ключа, но другим алгоритмом
+	other := jwt.NewWithClaims(jwt.SigningMethodHS512, testClaims(7))
+	other.Header["kid"] = DefaultKeyID
+	mismatched, err := other.SignedString(secret)
+	require.NoError(t, err)
+
+	for _, token := range []string{legacy, mismatched, "broken"} {
+		_, err = set.Parse(token, &Claims{})
+		assert.Error(t, err)
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	_, err := NewHMACKey("short", []byte("SECRET_KEY"))
+	assert.Error(t, err)
+
+	key, err := NewHMACKey("k1", []byte(strings.Repeat("k", MinSecretLength)))
+	require.NoError(t, err)
+	_, err = NewKeySet(key, key)
+	assert.Error(t, err)
+	_, err = NewKeySet()
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// ключи RS256 и EdDSA в PEM-файлах
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "rsa.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	data, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "ed25519.pem"), "PRIVATE KEY", data)
+	data, err = x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "ed25519.pub.pem"), "PUBLIC KEY", data)
+
+	tests := []struct {
+		name    string
+		content string
+		alg     string
+		err     bool
+	}{
+		{
+			name: "rotation",
+			content: `{"keys": [
+				{"kid": "k1", "alg": "HS256", "secret": "` + strings.Repeat("1", MinSecretLength) + `"},
+				{"kid": "k2", "alg": "RS256", "private_key_file": "rsa.pem"},
+				{"kid": "k3", "alg": "EdDSA", "private_key_file": "missing.pem", "retired": true}
+			]}`,
+			alg: "RS256",
+		},
+		{name: "EdDSA", content: `{"keys": [{"kid": "k1", "alg": "EdDSA", "private_key_file": "ed25519.pem"}]}`, alg: "EdDSA"},
+		{name: "public key only", content: `{"keys": [{"kid": "k1", "alg": "EdDSA", "public_key_file": "ed25519.pub.pem"}]}`, err: true},
+		{name: "unknown algorithm", content: `{"keys": [{"kid": "k1", "alg": "none"}]}`, err: true},
+		{name: "short secret", content: `{"keys": [{"kid": "k1", "alg": "HS256", "secret": "SECRET_KEY"}]}`, err: true},
+		{name: "duplicate kid", content: `{"keys": [{"kid": "k1", "alg": "EdDSA", "private_key_file": "ed25519.pem"},
+			{"kid": "k1", "alg": "HS256", "retired": true}]}`, err: true},
+		{name: "empty kid", content: `{"keys": [{"alg": "EdDSA", "private_key_file": "ed25519.pem"}]}`, err: true},
+		{name: "incorrect json", content: `{`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "keys.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			set, err := LoadKeyFile(path)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			tokenString, err := set.Sign(testClaims(7))
+			require.NoError(t, err)
+			claims := &Claims{}
+			token, err := set.Parse(tokenString, claims)
+			require.NoError(t, err)
+			assert.Equal(t, tt.alg, token.Method.Alg())
+			assert.Equal(t, 7, claims.UserID)
+		})
+	}
+
+	// другой сервис проверяет токены по открытому ключу
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys.json"),
+		[]byte(`{"keys": [{"kid": "k1", "alg": "EdDSA", "private_key_file": "ed25519.pem"}]}`), 0o600))
+	signer, err := LoadKeyFile(filepath.Join(dir, "keys.json"))
+	require.NoError(t, err)
+	tokenString, err := signer.Sign(testClaims(7))
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(dir, "ed25519.pub.pem"))
+	require.NoError(t, err)
+	public, err := jwt.ParseEdPublicKeyFromPEM(data)
+	require.NoError(t, err)
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(*jwt.Token) (any, error) {
+		return public, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, token.Valid)
+}
+
+func TestSetKeys(t *testing.T) {
+	previous := keys
+	defer SetKeys(previous)
+
+	token, err := NewToken(7)
+	require.NoError(t, err)
+	assert.Equal(t, Identity{UserID: 7}, ParseToken(token))
+
+	// токены, подписанные прежними ключами, больше не принимаются
+	set, err := NewRandomKeySet()
+	require.NoError(t, err)
+	SetKeys(set)
+	assert.True(t, ParseToken(token).IsGuest())
+}
+
+// testClaims возвращает данные токена пользователя, действующего час.
+func testClaims(userID int) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		UserID:           userID,
+	}
+}
+
+// writePEM сохраняет блок PEM в файл.
+func writePEM(t *testing.T, path string, blockType string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+}
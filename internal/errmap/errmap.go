@@ -0,0 +1,66 @@
+// Package errmap turns the errors of the storages and the Coder into HTTP statuses and gRPC codes.
+// The errors are matched by their kinds declared in the models package (models.ErrNotFound and others),
+// so the handlers of both servers answer the same error in the same way.
+package errmap
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rule binds a kind of errors to the HTTP status and the gRPC code.
+type rule struct {
+	kind   error
+	status int
+	code   codes.Code
+}
+
+var rules = []rule{
+	{kind: models.ErrNotFound, status: http.StatusNotFound, code: codes.NotFound},
+	{kind: models.ErrConflict, status: http.StatusConflict, code: codes.AlreadyExists},
+	{kind: models.ErrDeleted, status: http.StatusGone, code: codes.FailedPrecondition},
+	{kind: models.ErrExpired, status: http.StatusGone, code: codes.FailedPrecondition},
+	{kind: models.ErrInvalidURL, status: http.StatusBadRequest, code: codes.InvalidArgument},
+	{kind: models.ErrInvalidArgument, status: http.StatusBadRequest, code: codes.InvalidArgument},
+	{kind: models.ErrForbidden, status: http.StatusForbidden, code: codes.PermissionDenied},
+	{kind: models.ErrUnauthorized, status: http.StatusUnauthorized, code: codes.Unauthenticated},
+}
+
+// HTTPStatus returns the HTTP status for the error.
+// The errors of unknown kinds are reported as 500 Internal Server Error.
+// Example usage:
+//
+//	http.Error(res, err.Error(), errmap.HTTPStatus(err))
+func HTTPStatus(err error) int {
+	for _, r := range rules {
+		if errors.Is(err, r.kind) {
+			return r.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCCode returns the gRPC code for the error.
+// The errors of unknown kinds are reported as codes.Internal.
+func GRPCCode(err error) codes.Code {
+	for _, r := range rules {
+		if errors.Is(err, r.kind) {
+			return r.code
+		}
+	}
+	return codes.Internal
+}
+
+// GRPCError returns the gRPC status error with the code for the error and its text.
+// Example usage:
+//
+//	if err != nil {
+//	    return nil, errmap.GRPCError(err)
+//	}
+func GRPCError(err error) error {
+	return status.Error(GRPCCode(err), err.Error())
+}
@@ -0,0 +1,52 @@
+package errmap
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   codes.Code
+	}{
+		{name: "not found", err: models.ErrNotFound, status: http.StatusNotFound, code: codes.NotFound},
+		{name: "code taken", err: models.ErrCodeTaken, status: http.StatusConflict, code: codes.AlreadyExists},
+		{
+			name:   "batch conflict",
+			err:    &models.BatchError{Index: 1, Err: models.ErrURIExists},
+			status: http.StatusConflict,
+			code:   codes.AlreadyExists,
+		},
+		{name: "deleted", err: models.ErrDeleted, status: http.StatusGone, code: codes.FailedPrecondition},
+		{name: "expired", err: models.ErrExpired, status: http.StatusGone, code: codes.FailedPrecondition},
+		{
+			name:   "invalid",
+			err:    fmt.Errorf("item: %w", models.ErrInvalidURL),
+			status: http.StatusBadRequest,
+			code:   codes.InvalidArgument,
+		},
+		{name: "invalid argument", err: models.ErrInvalidArgument, status: http.StatusBadRequest, code: codes.InvalidArgument},
+		{name: "forbidden", err: models.ErrForbidden, status: http.StatusForbidden, code: codes.PermissionDenied},
+		{name: "login taken", err: models.ErrLoginTaken, status: http.StatusConflict, code: codes.AlreadyExists},
+		{name: "unauthorized", err: models.ErrUnauthorized, status: http.StatusUnauthorized, code: codes.Unauthenticated},
+		{name: "unknown", err: errors.New("connection refused"), status: http.StatusInternalServerError, code: codes.Internal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.status, HTTPStatus(test.err))
+			assert.Equal(t, test.code, GRPCCode(test.err))
+			assert.Equal(t, test.code, status.Code(GRPCError(test.err)))
+		})
+	}
+}
@@ -2,22 +2,26 @@ package grpcsrv
 
 import (
 	"context"
-	"errors"
+	"net"
+	"time"
 
 	"github.com/yury-kuznetsov/shortener/api/pb"
 	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"github.com/yury-kuznetsov/shortener/internal/auth"
+	"github.com/yury-kuznetsov/shortener/internal/errmap"
 	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/subnet"
 	"github.com/yury-kuznetsov/shortener/internal/uricoder"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type contextKey string
-
-// KeyUserID is a constant of type `contextKey`.
-// It is used to retrieve the userID from the context in various methods.
-// It should be used as the key when setting the value in the context.
-const KeyUserID contextKey = "USER_ID"
+// KeyUserID is the key of the identity of the user in the context.
+// It is shared with the HTTP server, the value should be set with auth.WithIdentity.
+const KeyUserID = auth.KeyUserID
 
 // NewCoderServer creates a new instance of CoderServer with the provided Coder instance.
 func NewCoderServer(coder *uricoder.Coder) *CoderServer {
@@ -36,9 +40,9 @@ type CoderServer struct {
 // It returns a DecodeResponse and an error.
 // The context object is used to get the user ID from the context value.
 // It calls the ToURI method of the coder instance to get the URI for the provided code.
-// If an error occurs while decoding, it checks if the error is a "ErrRowDeleted" error and returns a status error with the relevant code and message.
-// If the error is not a "ErrRowDeleted" error, it returns a status error with the internal server error code and the error message.
-// It returns the URI in a DecodeResponse if decoding is successful.
+// If an error occurs while decoding, it returns a status error with the code chosen by errmap.GRPCCode
+// (NotFound for an unknown code, FailedPrecondition for deleted and expired links) and the error message.
+// It returns the URI in a DecodeResponse if decoding is successful and records the click event.
 // Example usage:
 //
 //	ctx := context.Background()
@@ -49,14 +53,12 @@ type CoderServer struct {
 //	}
 //	fmt.Println("Decoded URI:", response.Uri)
 func (s *CoderServer) Decode(ctx context.Context, in *pb.DecodeRequest) (*pb.DecodeResponse, error) {
-	userID := ctx.Value(KeyUserID).(int)
+	userID := auth.FromContext(ctx).UserID
 	uri, err := s.coder.ToURI(ctx, in.GetCode(), userID)
 	if err != nil {
-		if errors.Is(err, models.ErrRowDeleted) {
-			return nil, status.Error(codes.FailedPrecondition, err.Error())
-		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, errmap.GRPCError(err)
 	}
+	s.coder.RecordClick(newClick(ctx, in.GetCode()))
 	return &pb.DecodeResponse{Uri: uri}, nil
 }
 
@@ -64,8 +66,9 @@ func (s *CoderServer) Decode(ctx context.Context, in *pb.DecodeRequest) (*pb.Dec
 // It requires a context object and an EncodeRequest as input parameters.
 // It returns an EncodeResponse and an error.
 // The context object is used to get the user ID from the context value.
-// It calls the ToCode method of the coder instance to get the code for the provided URI.
-// If the code is empty and an error occurs, it returns a status error with the invalid argument code and the error message.
+// It calls the ToCode method of the coder instance to get the code for the provided URI, optional expiration time and alias.
+// If the code is empty and an error occurs, it returns a status error with the code chosen by errmap.GRPCCode
+// (AlreadyExists if the alias is already taken, InvalidArgument if the URI is incorrect) and the error message.
 // It constructs an EncodeResponse with the base address and the code.
 // If an error occurs while encoding, it returns the response along with a status error with the already exists code and the error message.
 // Otherwise, it returns the response and nil error.
@@ -79,14 +82,17 @@ func (s *CoderServer) Decode(ctx context.Context, in *pb.DecodeRequest) (*pb.Dec
 //	}
 //	fmt.Println("Encoded Code:", response.Code)
 func (s *CoderServer) Encode(ctx context.Context, in *pb.EncodeRequest) (*pb.EncodeResponse, error) {
-	userID := ctx.Value(KeyUserID).(int)
-	code, err := s.coder.ToCode(ctx, in.GetUri(), userID)
+	userID, err := scopedUser(ctx, models.ScopeLinksWrite)
+	if err != nil {
+		return nil, err
+	}
+	code, err := s.coder.ToCode(ctx, in.GetUri(), userID, linkOptions(in.GetExpiresAt(), in.GetAlias(), in.GetTags()))
 	if code == "" && err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, errmap.GRPCError(err)
 	}
 	response := &pb.EncodeResponse{Code: config.Options.BaseAddr + "/" + code}
 	if err != nil {
-		return response, status.Error(codes.AlreadyExists, err.Error())
+		return response, errmap.GRPCError(err)
 	}
 	return response, nil
 }
@@ -95,40 +101,102 @@ func (s *CoderServer) Encode(ctx context.Context, in *pb.EncodeRequest) (*pb.Enc
 // It requires a context object and an EncodeByIDRequest as input parameters.
 // It returns an EncodeByIDResponse and an error.
 // The context object is used to get the user ID from the context value.
-// It calls the ToCode method of the coder instance to get the code for the provided URI.
-// If the code is empty and err is not nil, it returns a status error with the invalid argument code and the error message.
+// It calls the ToCode method of the coder instance to get the code for the provided URI, optional expiration time and alias.
+// If the code is empty and err is not nil, it returns a status error with the code chosen by errmap.GRPCCode and the error message.
 // It constructs an EncodeByIDResponse with the provided ID and the code prefixed with the base address from the config options.
 // If err is not nil, it returns the response with a status error with the already exists code and the error message.
 // Otherwise, it returns the response and nil as the error.
+// In the partial mode the result is reported in the status and error fields of the response instead
+// (see uricoder.Coder.ToCodesPartial), only unexpected storage errors are returned as status errors.
 func (s *CoderServer) EncodeByID(ctx context.Context, in *pb.EncodeByIDRequest) (*pb.EncodeByIDResponse, error) {
-	userID := ctx.Value(KeyUserID).(int)
-	code, err := s.coder.ToCode(ctx, in.GetUri(), userID)
+	userID, err := scopedUser(ctx, models.ScopeLinksWrite)
+	if err != nil {
+		return nil, err
+	}
+	if in.GetPartial() {
+		link := models.LinkRequest{URI: in.GetUri(), LinkOptions: linkOptions(in.GetExpiresAt(), in.GetAlias(), in.GetTags())}
+		results, err := s.coder.ToCodesPartial(ctx, []models.LinkRequest{link}, userID)
+		if err != nil {
+			return nil, errmap.GRPCError(err)
+		}
+		return partialItem(in.GetId(), results[0]), nil
+	}
+	code, err := s.coder.ToCode(ctx, in.GetUri(), userID, linkOptions(in.GetExpiresAt(), in.GetAlias(), in.GetTags()))
 	if code == "" && err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, errmap.GRPCError(err)
 	}
 	response := &pb.EncodeByIDResponse{
 		Id:   in.GetId(),
 		Code: config.Options.BaseAddr + "/" + code,
 	}
 	if err != nil {
-		return response, status.Error(codes.AlreadyExists, err.Error())
+		return response, errmap.GRPCError(err)
+	}
+	return response, nil
+}
+
+// EncodeBatch is a method of CoderServer that encodes several URIs at once.
+// It requires a context object and an EncodeBatchRequest with the items to be encoded as input parameters.
+// It returns an EncodeBatchResponse with the ID and the short URL of every item and an error.
+// The items are stored by the ToCodes method of the coder instance, so either all of them are stored or none.
+// If an item cannot be stored, it returns a status error with the code chosen by errmap.GRPCCode
+// (AlreadyExists if an alias or a URI is already taken, InvalidArgument if an item is not valid).
+// In the partial mode the valid items are stored even if some others are not, and every item
+// of the response carries its own status: "created", "conflict" with the existing code, or "invalid" with the reason.
+func (s *CoderServer) EncodeBatch(ctx context.Context, in *pb.EncodeBatchRequest) (*pb.EncodeBatchResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksWrite)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]models.LinkRequest, 0, len(in.GetItems()))
+	for _, item := range in.GetItems() {
+		links = append(links, models.LinkRequest{
+			URI:         item.GetUri(),
+			LinkOptions: linkOptions(item.GetExpiresAt(), item.GetAlias(), item.GetTags()),
+		})
+	}
+
+	if in.GetPartial() {
+		results, err := s.coder.ToCodesPartial(ctx, links, userID)
+		if err != nil {
+			return nil, errmap.GRPCError(err)
+		}
+		response := &pb.EncodeBatchResponse{}
+		for i, result := range results {
+			response.Items = append(response.Items, partialItem(in.GetItems()[i].GetId(), result))
+		}
+		return response, nil
+	}
+
+	shortCodes, err := s.coder.ToCodes(ctx, links, userID)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+
+	response := &pb.EncodeBatchResponse{}
+	for i, code := range shortCodes {
+		response.Items = append(response.Items, &pb.EncodeByIDResponse{
+			Id:   in.GetItems()[i].GetId(),
+			Code: config.Options.BaseAddr + "/" + code,
+		})
 	}
 	return response, nil
 }
 
-// GetHistory is a method of CoderServer that retrieves the history of encoded URLs for a user.
-// It requires a context object as an input parameter.
+// History is a method of CoderServer that retrieves a page of the history of encoded URLs for a user.
+// It requires a context object and a GetHistoryRequest as input parameters.
 // It returns a GetHistoryResponse and an error.
 // The context object is used to get the user ID from the context value.
-// It calls the GetHistory method of the coder instance to retrieve the history data for the user.
-// If an error occurs while retrieving the history data, it returns a status error with the internal server error code and the error message.
-// It creates a list of History objects based on the retrieved data and appends them to the Histories slice in the GetHistoryResponse.
-// It returns the GetHistoryResponse with the list of histories if retrieval is successful.
+// The request sets the limit, the cursor, the order and the filters of the page (see uricoder.Coder.GetHistory).
+// If an error occurs while retrieving the history data, it returns a status error chosen by errmap.GRPCError
+// (InvalidArgument for an incorrect cursor or sort order).
+// It creates a list of History objects based on the retrieved data and returns them
+// with the cursor of the next page, which is empty on the last page.
 //
 // Example usage:
 //
 //	ctx := context.Background()
-//	response, err := coderServer.GetHistory(ctx)
+//	response, err := client.History(ctx, &pb.GetHistoryRequest{Limit: 50, Sort: "clicks", Desc: true})
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
@@ -136,20 +204,35 @@ func (s *CoderServer) EncodeByID(ctx context.Context, in *pb.EncodeByIDRequest)
 //	    fmt.Println("Code:", history.Code)
 //	    fmt.Println("URI :", history.Uri)
 //	}
-func (s *CoderServer) GetHistory(ctx context.Context) (*pb.GetHistoryResponse, error) {
-	userID := ctx.Value(KeyUserID).(int)
-	data, err := s.coder.GetHistory(ctx, userID)
+func (s *CoderServer) History(ctx context.Context, in *pb.GetHistoryRequest) (*pb.GetHistoryResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksRead)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
+	}
+	query := models.HistoryQuery{
+		Limit:   int(in.GetLimit()),
+		SortBy:  in.GetSort(),
+		Desc:    in.GetDesc(),
+		Deleted: in.Deleted,
+		Domain:  in.GetDomain(),
+		Tag:     in.GetTag(),
+	}
+	page, err := s.coder.GetHistory(ctx, userID, query, in.GetCursor())
+	if err != nil {
+		return nil, errmap.GRPCError(err)
 	}
 	var histories []*pb.History
-	for _, v := range data {
+	for _, v := range page.Links {
 		histories = append(histories, &pb.History{
-			Code: v.ShortURL,
-			Uri:  v.OriginalURL,
+			Code:      v.Code,
+			Uri:       v.URI,
+			CreatedAt: timestamppb.New(v.CreatedAt),
+			Clicks:    int64(v.Clicks),
+			Deleted:   v.IsDeleted,
+			Tags:      v.Tags,
 		})
 	}
-	return &pb.GetHistoryResponse{Histories: histories}, nil
+	return &pb.GetHistoryResponse{Histories: histories, NextCursor: page.NextCursor}, nil
 }
 
 // Delete is a method of CoderServer that deletes the URLs associated with the provided codes.
@@ -169,7 +252,312 @@ func (s *CoderServer) GetHistory(ctx context.Context) (*pb.GetHistoryResponse, e
 //
 // fmt.Println("Deletion completed successfully")
 func (s *CoderServer) Delete(ctx context.Context, in *pb.DeleteRequest) (*pb.DeleteResponse, error) {
-	userID := ctx.Value(KeyUserID).(int)
+	userID, err := scopedUser(ctx, models.ScopeLinksDelete)
+	if err != nil {
+		return nil, err
+	}
 	_ = s.coder.DeleteUrls(in.Codes, userID)
 	return &pb.DeleteResponse{}, nil
 }
+
+// Restore is a method of CoderServer that restores the recently deleted URLs of the user
+// (see uricoder.Coder.Restore) and returns the restored codes.
+// The codes which cannot be restored are skipped; an anonymous user gets the Unauthenticated code.
+// Example usage:
+//
+//	response, err := client.Restore(ctx, &pb.RestoreRequest{Codes: []string{"abc123"}})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("Restored:", response.Codes)
+func (s *CoderServer) Restore(ctx context.Context, in *pb.RestoreRequest) (*pb.RestoreResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksDelete)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	restored, err := s.coder.Restore(ctx, in.GetCodes(), userID)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+	return &pb.RestoreResponse{Codes: restored}, nil
+}
+
+// LinkStats is a method of CoderServer that returns the click statistics of a link of the user.
+// It requires a context object and a LinkStatsRequest as input parameters.
+// The context object is used to get the user ID from the context value; an anonymous user gets
+// the Unauthenticated code. The optional from and to fields limit the time range of the statistics.
+// It calls the GetLinkStats method of the coder instance and converts the statistics into a LinkStatsResponse.
+// Errors are converted by errmap.GRPCError: NotFound for an unknown code, PermissionDenied if the link
+// belongs to another user and InvalidArgument for an incorrect time range.
+// Example usage:
+//
+//	ctx := context.Background()
+//	request := &pb.LinkStatsRequest{Code: "abc123"}
+//	response, err := coderServer.LinkStats(ctx, request)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("Clicks:", response.Clicks)
+func (s *CoderServer) LinkStats(ctx context.Context, in *pb.LinkStatsRequest) (*pb.LinkStatsResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeStatsRead)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	var from, to time.Time
+	if in.GetFrom() != nil {
+		from = in.GetFrom().AsTime()
+	}
+	if in.GetTo() != nil {
+		to = in.GetTo().AsTime()
+	}
+	stats, err := s.coder.GetLinkStats(ctx, in.GetCode(), userID, from, to)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+
+	return &pb.LinkStatsResponse{
+		Code:          stats.Code,
+		From:          timestamppb.New(stats.From),
+		To:            timestamppb.New(stats.To),
+		Clicks:        int64(stats.Clicks),
+		Visitors:      int64(stats.Visitors),
+		Daily:         clickCounts(stats.Daily),
+		Hourly:        clickCounts(stats.Hourly),
+		TopReferrers:  topValues(stats.TopReferrers),
+		TopUserAgents: topValues(stats.TopUserAgents),
+	}, nil
+}
+
+// Tags is a method of CoderServer that returns the tags of the user's links with the number of links per tag,
+// the most frequent tags first (see uricoder.Coder.GetTagCounts).
+// Anonymous users get the Unauthenticated code.
+// Example usage:
+//
+//	response, err := client.Tags(ctx, &pb.TagsRequest{})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, tag := range response.Tags {
+//	    fmt.Println(tag.Tag, tag.Links)
+//	}
+func (s *CoderServer) Tags(ctx context.Context, _ *pb.TagsRequest) (*pb.TagsResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksRead)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	counts, err := s.coder.GetTagCounts(ctx, userID)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+
+	response := &pb.TagsResponse{}
+	for _, count := range counts {
+		response.Tags = append(response.Tags, &pb.TagCount{Tag: count.Tag, Links: int64(count.Links)})
+	}
+	return response, nil
+}
+
+// Update is a method of CoderServer that sets the new destination of a link of the user
+// (see uricoder.Coder.Update) and returns the new revision of the link.
+// Anonymous users get the Unauthenticated code, other errors are converted by errmap.GRPCError:
+// InvalidArgument for an incorrect URI, NotFound for an unknown code, PermissionDenied if the link
+// belongs to another user and FailedPrecondition if the link was deleted.
+// Example usage:
+//
+//	response, err := client.Update(ctx, &pb.UpdateRequest{Code: "abc123", Uri: "https://site.com/new"})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("Revision:", response.Revision.Number)
+func (s *CoderServer) Update(ctx context.Context, in *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksWrite)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	revision, err := s.coder.Update(ctx, in.GetCode(), userID, in.GetUri())
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+	return &pb.UpdateResponse{Revision: revisionMessage(revision)}, nil
+}
+
+// Revisions is a method of CoderServer that returns the revisions of a link of the user, the oldest first
+// (see uricoder.Coder.GetRevisions). Errors are converted like in Update.
+func (s *CoderServer) Revisions(ctx context.Context, in *pb.RevisionsRequest) (*pb.RevisionsResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksRead)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	revisions, err := s.coder.GetRevisions(ctx, in.GetCode(), userID)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+	response := &pb.RevisionsResponse{}
+	for _, revision := range revisions {
+		response.Revisions = append(response.Revisions, revisionMessage(revision))
+	}
+	return response, nil
+}
+
+// Rollback is a method of CoderServer that restores the destination of a link of the user
+// from the revision with the given number (see uricoder.Coder.Rollback) and returns the new revision.
+// Errors are converted like in Update, an unknown revision gets the NotFound code.
+func (s *CoderServer) Rollback(ctx context.Context, in *pb.RollbackRequest) (*pb.RollbackResponse, error) {
+	userID, err := scopedUser(ctx, models.ScopeLinksWrite)
+	if err != nil {
+		return nil, err
+	}
+	if userID == 0 {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	revision, err := s.coder.Rollback(ctx, in.GetCode(), userID, int(in.GetNumber()))
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+	return &pb.RollbackResponse{Revision: revisionMessage(revision)}, nil
+}
+
+// Stats is a method of CoderServer that returns the statistics for the operators (see uricoder.Coder.GetStats).
+// It requires a context object and a StatsRequest as input parameters.
+// Like the HTTP handler, it is available only from the trusted subnet (see subnet.TrustedContext),
+// other requests get the PermissionDenied code. The address of the client is the address of the peer;
+// the "x-real-ip" metadata is accepted only from a trusted proxy (see config.Options.TrustedProxies).
+// If an error occurs while retrieving the statistics, it returns a status error chosen by errmap.GRPCError.
+// Example usage:
+//
+//	// the client connects from the trusted subnet
+//	response, err := client.Stats(context.Background(), &pb.StatsRequest{})
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("Live URLs:", response.Live)
+func (s *CoderServer) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.StatsResponse, error) {
+	if !subnet.TrustedContext(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "access denied")
+	}
+
+	stats, err := s.coder.GetStats(ctx)
+	if err != nil {
+		return nil, errmap.GRPCError(err)
+	}
+
+	response := &pb.StatsResponse{
+		Urls:          int64(stats.Urls),
+		Live:          int64(stats.Live),
+		Deleted:       int64(stats.Deleted),
+		Users:         int64(stats.Users),
+		DeletionQueue: int64(stats.DeletionQueue),
+		Backend:       stats.Backend,
+		Details:       stats.Details,
+	}
+	for _, c := range stats.CreatedPerDay {
+		response.CreatedPerDay = append(response.CreatedPerDay, &pb.DayCount{Day: timestamppb.New(c.Day), Links: int64(c.Links)})
+	}
+	for _, c := range stats.TopUsers {
+		response.TopUsers = append(response.TopUsers, &pb.UserCount{UserId: int64(c.UserID), Links: int64(c.Links)})
+	}
+	if stats.Cache != nil {
+		response.Cache = &pb.CacheStats{Hits: stats.Cache.Hits, Misses: stats.Cache.Misses}
+	}
+	return response, nil
+}
+
+// clickCounts converts the numbers of clicks per period into the response messages.
+func clickCounts(counts []models.ClickCount) []*pb.ClickCount {
+	result := make([]*pb.ClickCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, &pb.ClickCount{Time: timestamppb.New(c.Time), Clicks: int64(c.Clicks)})
+	}
+	return result
+}
+
+// topValues converts the most frequent values into the response messages.
+func topValues(values []models.TopValue) []*pb.TopValue {
+	result := make([]*pb.TopValue, 0, len(values))
+	for _, v := range values {
+		result = append(result, &pb.TopValue{Value: v.Value, Clicks: int64(v.Clicks)})
+	}
+	return result
+}
+
+// scopedUser returns the user of the request if the request may use the scope (see auth.Identity.Allows).
+// Otherwise, it returns the PermissionDenied code: the API key of the request lacks the scope.
+func scopedUser(ctx context.Context, scope string) (int, error) {
+	identity := auth.FromContext(ctx)
+	if !identity.Allows(scope) {
+		return 0, status.Error(codes.PermissionDenied, "API key lacks the scope "+scope)
+	}
+	return identity.UserID, nil
+}
+
+// newClick builds the click event of the code from the metadata and the peer of the request.
+func newClick(ctx context.Context, code string) models.Click {
+	click := models.Click{Code: code, Time: time.Now()}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("referer"); len(values) > 0 {
+			click.Referrer = values[0]
+		}
+		if values := md.Get("user-agent"); len(values) > 0 {
+			click.UserAgent = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		click.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(click.IP); err == nil {
+			click.IP = host
+		}
+	}
+	return click
+}
+
+// partialItem builds the response item of the partial mode from the result of the link.
+func partialItem(id string, result models.BatchResult) *pb.EncodeByIDResponse {
+	item := &pb.EncodeByIDResponse{Id: id, Status: result.Status}
+	if result.Code != "" {
+		item.Code = config.Options.BaseAddr + "/" + result.Code
+	}
+	if result.Err != nil {
+		item.Error = result.Err.Error()
+	}
+	return item
+}
+
+// revisionMessage converts the revision of a link into its protobuf message.
+func revisionMessage(revision models.Revision) *pb.Revision {
+	return &pb.Revision{
+		Number:    int64(revision.Number),
+		Uri:       revision.URI,
+		CreatedAt: timestamppb.New(revision.CreatedAt),
+	}
+}
+
+// linkOptions builds the options of a new link from the request fields.
+// The missing expiration time means the link never expires.
+func linkOptions(expiresAt *timestamppb.Timestamp, alias string, tags []string) models.LinkOptions {
+	opts := models.LinkOptions{Alias: alias, Tags: tags}
+	if expiresAt != nil {
+		opts.ExpiresAt = expiresAt.AsTime()
+	}
+	return opts
+}
@@ -36,6 +36,16 @@ func NewLogger() *Logger {
 	return &logger
 }
 
+// Error logs the error of a background task, which has no caller to return it to.
+// Example usage:
+//
+//	if err := storage.SaveClicks(ctx, clicks); err != nil {
+//	    l.Error("save clicks", err)
+//	}
+func (l *Logger) Error(msg string, err error) {
+	l.sugar.Errorw(msg, "error", err)
+}
+
 // Handle method handles the HTTP request and response.
 func (l *Logger) Handle(handler http.HandlerFunc) http.HandlerFunc {
 	handlerFunc := func(res http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// The scopes of the API keys. Every handler requires one of them from a request authorized by an API key,
+// while the requests authorized by the cookie may use all of them.
+const (
+	ScopeLinksWrite  = "links:write"
+	ScopeLinksRead   = "links:read"
+	ScopeLinksDelete = "links:delete"
+	ScopeStatsRead   = "stats:read"
+)
+
+// Scopes is the list of all the scopes of the API keys.
+var Scopes = []string{ScopeLinksWrite, ScopeLinksRead, ScopeLinksDelete, ScopeStatsRead}
+
+// APIKey is a struct representing a long-lived key of a user for the programmatic clients.
+// Only the SHA-256 hash of the key is stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"-"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyRequest is a struct representing the body of the request to create an API key.
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// NewAPIKeyResponse is a struct representing the response with a new API key and the key itself.
+type NewAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "errors"
+
+// The kinds of errors returned by the storages and the Coder.
+// Every error is one of them or wraps one of them, so the callers check the kind with errors.Is
+// and turn it into an HTTP status or a gRPC code in one place (see the errmap package).
+// The texts of the errors are sent to the clients, so all of them are in English.
+var (
+	// ErrNotFound is a variable that represents the error when there is no link with the given code.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is a variable that represents the error when the link conflicts with an existing one.
+	ErrConflict = errors.New("conflict")
+
+	// ErrDeleted is a variable that represents the error when a link is already deleted.
+	ErrDeleted = errors.New("link is deleted")
+
+	// ErrInvalidURL is a variable that represents the error when the URI of a link is incorrect.
+	ErrInvalidURL = errors.New("incorrect URI")
+
+	// ErrInvalidArgument is a variable that represents the error when a parameter of a request other than the URI
+	// (an alias, tags, credentials and so on) is incorrect.
+	ErrInvalidArgument = errors.New("invalid argument")
+
+	// ErrExpired is a variable that represents the error when a link is expired.
+	ErrExpired = errors.New("link is expired")
+
+	// ErrForbidden is a variable that represents the error when the user is not allowed to access the link.
+	ErrForbidden = errors.New("access denied")
+
+	// ErrUnauthorized is a variable that represents the error when the user cannot be authenticated.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+var (
+	// ErrCodeTaken is a variable that represents the error when the short code (alias or generated code) is already taken.
+	ErrCodeTaken = NewError("short code is already taken", ErrConflict)
+
+	// ErrURIExists is a variable that represents the error when the URI is already shortened.
+	ErrURIExists = NewError("URI is already shortened", ErrConflict)
+
+	// ErrLoginTaken is a variable that represents the error when the login is already registered.
+	ErrLoginTaken = NewError("login is already taken", ErrConflict)
+)
+
+// NewError returns an error with the given text, which is of the given kind (for example, ErrConflict).
+// Example usage:
+//
+//	var ErrIncorrectAlias = models.NewError("incorrect alias", models.ErrInvalidArgument)
+//	errors.Is(ErrIncorrectAlias, models.ErrInvalidArgument) // true
+func NewError(text string, kind error) error {
+	return &kindError{text: text, kind: kind}
+}
+
+// kindError is an error with its own text, which unwraps to its kind.
+type kindError struct {
+	text string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.text
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort orders of the history of a user.
+const (
+	HistorySortCreated = "created"
+	HistorySortClicks  = "clicks"
+)
+
+// UserLink is a struct representing a link in the history of a user.
+// It contains the short Code, the original URI, the soft delete flag,
+// the creation time, the number of clicks and the tags of the link.
+type UserLink struct {
+	Code      string
+	URI       string
+	IsDeleted bool
+	CreatedAt time.Time
+	Clicks    int
+	Tags      []string
+}
+
+// HistoryCursor is a struct representing the position in the history of a user:
+// the sort keys of the last link of the previous page.
+type HistoryCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Clicks    int       `json:"n,omitempty"`
+	Code      string    `json:"c"`
+}
+
+// HistoryQuery is a struct representing a query of the history of a user passed to the storage.
+// Limit is the maximal number of links (zero means no limit).
+// SortBy is HistorySortCreated (the default) or HistorySortClicks; the ties are broken
+// by the creation time and the code. Desc reverses the order.
+// Deleted, if set, keeps only the deleted (true) or only the live (false) links.
+// Domain, if set, keeps only the links to the domain or its subdomains.
+// Tag, if set, keeps only the links with the tag.
+// After, if set, keeps only the links following the cursor in the order of the query.
+type HistoryQuery struct {
+	Limit   int
+	SortBy  string
+	Desc    bool
+	Deleted *bool
+	Domain  string
+	Tag     string
+	After   *HistoryCursor
+}
+
+// HistoryPage is a struct representing a page of the history of a user.
+// NextCursor is empty on the last page.
+type HistoryPage struct {
+	Links      []UserLink
+	NextCursor string
+}
+
+// Cursor returns the position of the link in the history.
+func (l UserLink) Cursor() HistoryCursor {
+	return HistoryCursor{CreatedAt: l.CreatedAt, Clicks: l.Clicks, Code: l.Code}
+}
+
+// Apply filters the links by the query, sorts them in the order of the query and cuts them to the limit.
+// It is used by the storages which cannot run the query by themselves.
+func (q HistoryQuery) Apply(links []UserLink) []UserLink {
+	result := make([]UserLink, 0, len(links))
+	for _, l := range links {
+		if q.Match(l) {
+			result = append(result, l)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return q.Less(result[i], result[j])
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result
+}
+
+// Match reports whether the link passes the filters and the cursor of the query.
+func (q HistoryQuery) Match(l UserLink) bool {
+	if q.Deleted != nil && l.IsDeleted != *q.Deleted {
+		return false
+	}
+	if q.Domain != "" && !MatchDomain(l.URI, q.Domain) {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(l.Tags, q.Tag) {
+		return false
+	}
+	if q.After != nil && !q.before(*q.After, l.Cursor()) {
+		return false
+	}
+	return true
+}
+
+// Less reports whether the link a goes before the link b in the order of the query.
+func (q HistoryQuery) Less(a, b UserLink) bool {
+	return q.before(a.Cursor(), b.Cursor())
+}
+
+// before reports whether the position a goes before the position b in the order of the query.
+func (q HistoryQuery) before(a, b HistoryCursor) bool {
+	if q.Desc {
+		a, b = b, a
+	}
+	if q.SortBy == HistorySortClicks && a.Clicks != b.Clicks {
+		return a.Clicks < b.Clicks
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Code < b.Code
+}
+
+// CountTags returns the number of links per tag of the given tag lists,
+// the most frequent tags first (the tags with equal counts are sorted by name).
+func CountTags(tagLists ...[]string) []TagCount {
+	counts := make(map[string]int)
+	for _, tags := range tagLists {
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	result := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		result = append(result, TagCount{Tag: tag, Links: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Links == result[j].Links {
+			return result[i].Tag < result[j].Tag
+		}
+		return result[i].Links > result[j].Links
+	})
+	return result
+}
+
+// MatchDomain reports whether the host of the URI is the domain or its subdomain (case-insensitive).
+func MatchDomain(uri string, domain string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
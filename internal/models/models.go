@@ -1,11 +1,20 @@
 package models
 
-import "errors"
+import (
+	"fmt"
+	"time"
+)
 
 // EncodeRequest is a struct representing the request for the EncodeJSONHandler method.
-// It contains the URL, which represents the original URL to be encoded.
+// It contains the URL, which represents the original URL to be encoded,
+// the optional ExpiresAt, after which the short URL stops redirecting,
+// the optional Alias, which is used as the short code instead of a generated one,
+// and the optional Tags of the link.
 type EncodeRequest struct {
-	URL string `json:"url"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Alias     string    `json:"alias"`
+	Tags      []string  `json:"tags"`
 }
 
 // EncodeResponse is a struct representing the response for the EncodeJSONHandler method.
@@ -24,32 +33,214 @@ type EncodeResponse struct {
 
 // EncodeBatchRequest is a struct representing the request for the EncodeBatchHandler method.
 // It contains the CorrelationID, which represents the correlation ID for batch encoding request,
-// and the OriginalURL, which represents the original URL to be encoded.
+// the OriginalURL, which represents the original URL to be encoded,
+// the optional ExpiresAt, after which the short URL stops redirecting,
+// the optional Alias, which is used as the short code instead of a generated one,
+// and the optional Tags of the link.
 type EncodeBatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string    `json:"correlation_id"`
+	OriginalURL   string    `json:"original_url"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	Alias         string    `json:"alias"`
+	Tags          []string  `json:"tags"`
 }
 
 // EncodeBatchResponse is a struct representing the response for the EncodeBatchHandler method.
 // It contains the CorrelationID, which represents the correlation ID for batch encoding request,
 // and the ShortURL, which represents the shortened URL.
+// In the partial mode it also contains the Status of the item (see BatchStatusCreated and others)
+// and the Error, which explains why the item was not created. For a conflict the ShortURL is the existing one.
 type EncodeBatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Statuses of the items of a batch encoded in the partial mode.
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusConflict = "conflict"
+	BatchStatusInvalid  = "invalid"
+)
+
+// BatchResult is a struct representing the result of a single item of a batch encoded in the partial mode.
+// It contains the Code (the new one, or the existing one for a conflict), the Status and the Err
+// of the item, which is nil for the created items.
+type BatchResult struct {
+	Code   string
+	Status string
+	Err    error
 }
 
-// GetByUserResponse is a struct representing the response for the GetByUser method.
-// It contains the ShortURL, which represents the shortened URL, and the OriginalURL, which is the original URL.
+// GetByUserResponse is a struct representing an item of the response for the UserUrlsHandler method.
+// It contains the ShortURL, which represents the shortened URL, the OriginalURL, which is the original URL,
+// the creation time, the number of clicks, the soft delete flag and the tags of the link.
 type GetByUserResponse struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int       `json:"clicks"`
+	IsDeleted   bool      `json:"is_deleted"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+// PatchLinkRequest is a struct representing the request for the PatchLinkHandler method.
+// Only the fields which are set are changed: URL sets the new destination of the link
+// (a new revision is added), Tags replaces all the tags of the link (an empty array removes them).
+type PatchLinkRequest struct {
+	URL  *string   `json:"url"`
+	Tags *[]string `json:"tags"`
+}
+
+// RollbackRequest is a struct representing the request for the RollbackHandler method.
+// Revision is the number of the revision whose destination is restored.
+type RollbackRequest struct {
+	Revision int `json:"revision"`
+}
+
+// TagCount is a struct representing the number of Links of a user with the Tag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int    `json:"links"`
 }
 
 // GetStatsResponse is a struct representing the response for the GetStatsHandler method.
-// It contains the number of URLs and users.
+// It contains the statistics of the storage (see StorageStats) and the number of links
+// waiting in the deletion queue of the Coder.
+// If the storage is wrapped with a cache, it also contains the cache counters.
 type GetStatsResponse struct {
-	Urls  int `json:"urls"`
-	Users int `json:"users"`
+	StorageStats
+	DeletionQueue int         `json:"deletion_queue"`
+	Cache         *CacheStats `json:"cache,omitempty"`
+}
+
+// StorageStats is a struct representing the statistics of the storage.
+// It contains the total number of URLs (Urls), the numbers of Live and soft Deleted ones,
+// the number of Users who have created them, the numbers of links created per day (in UTC)
+// for the requested period, the top users by the number of links,
+// the name of the storage Backend and its Details (for example, the path of the file).
+type StorageStats struct {
+	Urls          int               `json:"urls"`
+	Live          int               `json:"live"`
+	Deleted       int               `json:"deleted"`
+	Users         int               `json:"users"`
+	CreatedPerDay []DayCount        `json:"created_per_day"`
+	TopUsers      []UserCount       `json:"top_users"`
+	Backend       string            `json:"backend"`
+	Details       map[string]string `json:"details,omitempty"`
+}
+
+// DayCount is a struct representing the number of Links created on the Day.
+type DayCount struct {
+	Day   time.Time `json:"day"`
+	Links int       `json:"links"`
+}
+
+// UserCount is a struct representing the number of Links created by the user with UserID.
+type UserCount struct {
+	UserID int `json:"user_id"`
+	Links  int `json:"links"`
+}
+
+// CacheStats is a struct representing the counters of the storage cache.
+// Hits is the number of lookups served from the cache (including the cached misses),
+// Misses is the number of lookups passed to the storage.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// LinkOptions is a struct representing the optional parameters of a new short link.
+// It contains ExpiresAt, after which the link stops redirecting (zero value means the link never expires),
+// Alias, which is used as the short code instead of a generated one (if not empty), and the Tags of the link.
+type LinkOptions struct {
+	ExpiresAt time.Time
+	Alias     string
+	Tags      []string
+}
+
+// Link is a struct representing a new short link passed to the storage.
+// It contains the short Code, the original URI, the owner UserID, the optional ExpiresAt
+// and the normalized Tags (see uricoder.NormalizeTags).
+type Link struct {
+	Code      string
+	URI       string
+	UserID    int
+	ExpiresAt time.Time
+	Tags      []string
+}
+
+// Expired reports whether a link with the given expiration time is expired at the moment now.
+// The zero expiration time means the link never expires.
+func Expired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// LinkRequest is a struct representing a single link of a batch passed to the Coder.
+// It contains the original URI and the optional parameters of the link.
+type LinkRequest struct {
+	URI string
+	LinkOptions
+}
+
+// BatchError is a struct representing the error of a batch operation.
+// It contains the Index of the failed item, the existing Code if the URI of the item
+// is already shortened, and the cause Err. None of the items of a failed batch is stored.
+type BatchError struct {
+	Index int
+	Code  string
+	Err   error
+}
+
+// Error returns the text of the error.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Click is a struct representing a single redirect by a short link.
+// It contains the Code of the link, the Time of the redirect and the Referrer,
+// the UserAgent and the IP address of the client (empty if unknown).
+type Click struct {
+	Code      string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	IP        string
+}
+
+// LinkStats is a struct representing the response for the LinkStatsHandler method.
+// It contains the Code of the link, the time range [From, To) of the statistics,
+// the total number of Clicks, the number of unique Visitors (distinct pairs of IP and user agent),
+// the numbers of clicks per day and per hour (only the periods with clicks, in UTC),
+// and the most frequent referrers and user agents.
+type LinkStats struct {
+	Code          string       `json:"code"`
+	From          time.Time    `json:"from"`
+	To            time.Time    `json:"to"`
+	Clicks        int          `json:"clicks"`
+	Visitors      int          `json:"visitors"`
+	Daily         []ClickCount `json:"daily"`
+	Hourly        []ClickCount `json:"hourly"`
+	TopReferrers  []TopValue   `json:"top_referrers"`
+	TopUserAgents []TopValue   `json:"top_user_agents"`
+}
+
+// ClickCount is a struct representing the number of Clicks in the period starting at Time.
+type ClickCount struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+// TopValue is a struct representing the number of Clicks with the same Value (for example, a referrer).
+type TopValue struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
 }
 
 // RmvUrlsMsg is a struct representing a message for removing URLs.
@@ -58,6 +249,3 @@ type RmvUrlsMsg struct {
 	UserID int
 	Code   string
 }
-
-// ErrRowDeleted is a variable that represents the error when a row is already deleted.
-var ErrRowDeleted = errors.New("запись уже удалена")
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Revision is a struct representing a destination of a short link and the time it was set.
+// The revisions of a link are numbered from 1, the first one is the URI the link was created with.
+type Revision struct {
+	Number    int       `json:"revision"`
+	URI       string    `json:"original_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Revisions returns the stored revisions of a link or, if the link has never been changed,
+// its only revision made of the URI and the creation time of the link.
+func Revisions(revisions []Revision, uri string, createdAt time.Time) []Revision {
+	if len(revisions) == 0 {
+		return []Revision{{Number: 1, URI: uri, CreatedAt: createdAt}}
+	}
+	return revisions
+}
+
+// Revise returns the revisions of a link (see Revisions) followed by the new revision with the URI set at the given time.
+// The given slice is never modified, so the revisions of the copies of a link are not affected.
+func Revise(revisions []Revision, uri string, createdAt time.Time, newURI string, at time.Time) []Revision {
+	revisions = Revisions(revisions, uri, createdAt)
+	return append(slices.Clip(revisions), Revision{Number: len(revisions) + 1, URI: newURI, CreatedAt: at})
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// StatsBuilder collects the StorageStats link by link.
+// It is used by the storages which have to scan all the links to build the statistics.
+// Example usage:
+//
+//	builder := models.NewStatsBuilder(since)
+//	for _, r := range records {
+//	    builder.Add(r.UserID, r.IsDeleted, r.CreatedAt)
+//	}
+//	stats := builder.Stats(top)
+type StatsBuilder struct {
+	since   time.Time
+	urls    int
+	deleted int
+	days    map[time.Time]int
+	users   map[int]int
+}
+
+// NewStatsBuilder creates a StatsBuilder which counts the links created per day since the given time.
+func NewStatsBuilder(since time.Time) *StatsBuilder {
+	return &StatsBuilder{
+		since: since,
+		days:  make(map[time.Time]int),
+		users: make(map[int]int),
+	}
+}
+
+// Add counts a link of the user with the given deleted flag and creation time.
+func (b *StatsBuilder) Add(userID int, isDeleted bool, createdAt time.Time) {
+	b.urls++
+	if isDeleted {
+		b.deleted++
+	}
+	b.users[userID]++
+	if !createdAt.Before(b.since) {
+		b.days[createdAt.UTC().Truncate(24*time.Hour)]++
+	}
+}
+
+// Stats returns the collected statistics with up to top users with the most links.
+// The users with the same number of links are sorted by ID.
+// The Backend and Details of the result are left for the storage to fill.
+func (b *StatsBuilder) Stats(top int) StorageStats {
+	stats := StorageStats{
+		Urls:          b.urls,
+		Live:          b.urls - b.deleted,
+		Deleted:       b.deleted,
+		Users:         len(b.users),
+		CreatedPerDay: make([]DayCount, 0, len(b.days)),
+		TopUsers:      make([]UserCount, 0, len(b.users)),
+	}
+
+	for day, n := range b.days {
+		stats.CreatedPerDay = append(stats.CreatedPerDay, DayCount{Day: day, Links: n})
+	}
+	sort.Slice(stats.CreatedPerDay, func(i, j int) bool {
+		return stats.CreatedPerDay[i].Day.Before(stats.CreatedPerDay[j].Day)
+	})
+
+	for userID, n := range b.users {
+		stats.TopUsers = append(stats.TopUsers, UserCount{UserID: userID, Links: n})
+	}
+	sort.Slice(stats.TopUsers, func(i, j int) bool {
+		if stats.TopUsers[i].Links == stats.TopUsers[j].Links {
+			return stats.TopUsers[i].UserID < stats.TopUsers[j].UserID
+		}
+		return stats.TopUsers[i].Links > stats.TopUsers[j].Links
+	})
+	if len(stats.TopUsers) > top {
+		stats.TopUsers = stats.TopUsers[:top]
+	}
+
+	return stats
+}
+
+// ClickStatsBuilder collects the LinkStats of a link click by click.
+// It is used by the storages which have to read the click events to build the statistics.
+// Example usage:
+//
+//	builder := models.NewClickStatsBuilder(code, from, to)
+//	for _, click := range clicks {
+//	    builder.Add(click)
+//	}
+//	stats := builder.Stats(top)
+type ClickStatsBuilder struct {
+	stats      LinkStats
+	visitors   map[visitor]struct{}
+	daily      map[time.Time]int
+	hourly     map[time.Time]int
+	referrers  map[string]int
+	userAgents map[string]int
+}
+
+// visitor is a unique visitor of a link: a distinct pair of IP and user agent.
+type visitor struct {
+	ip        string
+	userAgent string
+}
+
+// NewClickStatsBuilder creates a ClickStatsBuilder of the link with the given code in the time range [from, to).
+// The caller adds only the clicks of the range.
+func NewClickStatsBuilder(code string, from, to time.Time) *ClickStatsBuilder {
+	return &ClickStatsBuilder{
+		stats:      LinkStats{Code: code, From: from, To: to},
+		visitors:   make(map[visitor]struct{}),
+		daily:      make(map[time.Time]int),
+		hourly:     make(map[time.Time]int),
+		referrers:  make(map[string]int),
+		userAgents: make(map[string]int),
+	}
+}
+
+// Add counts a click of the link.
+func (b *ClickStatsBuilder) Add(click Click) {
+	b.stats.Clicks++
+	b.visitors[visitor{click.IP, click.UserAgent}] = struct{}{}
+	t := click.Time.UTC()
+	b.daily[t.Truncate(24*time.Hour)]++
+	b.hourly[t.Truncate(time.Hour)]++
+	// прямые переходы и клиенты без заголовка в топ не попадают
+	if click.Referrer != "" {
+		b.referrers[click.Referrer]++
+	}
+	if click.UserAgent != "" {
+		b.userAgents[click.UserAgent]++
+	}
+}
+
+// Stats returns the collected statistics with up to top most frequent referrers and user agents.
+// The values with the same number of clicks are sorted by value.
+func (b *ClickStatsBuilder) Stats(top int) LinkStats {
+	stats := b.stats
+	stats.Visitors = len(b.visitors)
+	stats.Daily = clickCounts(b.daily)
+	stats.Hourly = clickCounts(b.hourly)
+	stats.TopReferrers = topValues(b.referrers, top)
+	stats.TopUserAgents = topValues(b.userAgents, top)
+	return stats
+}
+
+// clickCounts converts the numbers of clicks per period into a slice sorted by time.
+func clickCounts(counts map[time.Time]int) []ClickCount {
+	result := make([]ClickCount, 0, len(counts))
+	for t, n := range counts {
+		result = append(result, ClickCount{Time: t, Clicks: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// topValues returns up to top most frequent values, the values with equal counts are sorted by name.
+func topValues(counts map[string]int, top int) []TopValue {
+	result := make([]TopValue, 0, len(counts))
+	for value, n := range counts {
+		result = append(result, TopValue{Value: value, Clicks: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Clicks == result[j].Clicks {
+			return result[i].Value < result[j].Value
+		}
+		return result[i].Clicks > result[j].Clicks
+	})
+	if len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// FirstUserID is the ID of the first stored user.
+// The smaller IDs were taken by the random anonymous users of the old cookies,
+// so the storages start the IDs of the users from it and they never collide.
+const FirstUserID = 1000
+
+// User is a struct representing a user: the ID, the login, the hash of the password
+// (never the password itself) and the registration time.
+// An anonymous user has neither a login nor a password: it is identified by the UUID
+// and is valid until ExpiresAt (see IsAnonymous).
+type User struct {
+	ID           int
+	Login        string
+	PasswordHash string
+	UUID         string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// IsAnonymous reports whether the user is an anonymous one.
+func (u User) IsAnonymous() bool {
+	return u.Login == ""
+}
+
+// Credentials is a struct representing the body of the registration and login requests.
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
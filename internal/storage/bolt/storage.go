@@ -0,0 +1,1078 @@
+// Package bolt provides a storage of short links on top of an embedded bbolt database file.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/search"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var (
+	// bucketCodes stores the links by their codes.
+	bucketCodes = []byte("codes")
+	// bucketURIs is the reverse index: URI -> code.
+	// The links whose destination has been changed are removed from it.
+	bucketURIs = []byte("uris")
+	// bucketUsers contains a nested bucket for every user.
+	// The keys of a nested bucket are the creation time followed by the code,
+	// so the cursor walks the links of the user in the order of their creation.
+	bucketUsers = []byte("users")
+	// bucketClicks contains a nested bucket of click events for every code.
+	// The keys of a nested bucket are the time of the click followed by a sequence number.
+	bucketClicks = []byte("clicks")
+	// bucketClickCounts stores the number of the clicks of every code (see clickCount),
+	// so the history does not walk the click events.
+	bucketClickCounts = []byte("click_counts")
+	// bucketAccounts stores the registered users by their logins.
+	// Its sequence gives the IDs of the users (see models.FirstUserID).
+	bucketAccounts = []byte("accounts")
+	// bucketAnonymous stores the anonymous users by their IDs (see userKey).
+	// Their IDs are given by the sequence of the accounts bucket as well.
+	bucketAnonymous = []byte("anonymous")
+	// bucketKeys stores the API keys by their IDs.
+	bucketKeys = []byte("keys")
+	// bucketKeyHashes is the reverse index: hash of an API key -> ID of the key.
+	bucketKeyHashes = []byte("key_hashes")
+)
+
+// record represents a link stored in the codes bucket.
+type record struct {
+	URI       string     `json:"uri"`
+	UserID    int        `json:"user_id"`
+	IsDeleted bool       `json:"is_deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	// Revisions is empty if the destination has never been changed (see models.Revisions).
+	Revisions []models.Revision `json:"revisions,omitempty"`
+}
+
+// userLink returns the record of the code as a link in the history of the user.
+// The number of clicks is read from the click counts bucket.
+func (r record) userLink(code []byte, counts *bbolt.Bucket) models.UserLink {
+	return models.UserLink{
+		Code:      string(code),
+		URI:       r.URI,
+		IsDeleted: r.IsDeleted,
+		CreatedAt: r.CreatedAt,
+		Tags:      r.Tags,
+		Clicks:    clickCount(counts, code),
+	}
+}
+
+// clickCount returns the number of the clicks of the code stored in the click counts bucket.
+func clickCount(counts *bbolt.Bucket, code []byte) int {
+	data := counts.Get(code)
+	if data == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// accountRecord represents a registered user stored in the accounts bucket.
+type accountRecord struct {
+	ID           int       `json:"id"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// anonymousRecord represents an anonymous user stored in the anonymous bucket.
+type anonymousRecord struct {
+	UUID      string    `json:"uuid"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// apiKeyRecord represents an API key stored in the keys bucket.
+type apiKeyRecord struct {
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiKey returns the record as the API key with the given ID.
+func (r apiKeyRecord) apiKey(id []byte) models.APIKey {
+	return models.APIKey{
+		ID:        string(id),
+		UserID:    r.UserID,
+		Name:      r.Name,
+		Scopes:    r.Scopes,
+		Hash:      r.Hash,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+// clickRecord represents a click event stored in the clicks bucket.
+type clickRecord struct {
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// Storage represents a storage backed by an embedded bbolt database.
+// Every change is committed to the file in its own transaction, so the links survive
+// restarts without running a database server and without rewriting the whole file.
+// The codes and the URIs are also kept in an in-memory trigram index (see search.Index) for Search,
+// which is built from the file on start.
+// Example usage:
+//
+//	value, err := storage.Get(ctx, code, userID)
+type Storage struct {
+	db    *bbolt.DB
+	index *search.Index
+}
+
+// NewStorage opens (or creates) the bbolt database file at the given path
+// and makes sure all the buckets exist.
+// If the file is locked by another process for more than a second, an error is returned.
+// Example usage:
+//
+//	storage, err := NewStorage("/tmp/short-url.db")
+//	if err != nil {
+//	    // handle error
+//	}
+//	defer storage.Close()
+func NewStorage(path string) (*Storage, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	index := search.NewIndex()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		// счетчики кликов файлов прежних версий считаем один раз при открытии
+		countClicks := tx.Bucket(bucketClickCounts) == nil
+		for _, name := range [][]byte{bucketCodes, bucketURIs, bucketUsers, bucketClicks, bucketClickCounts, bucketAccounts, bucketAnonymous, bucketKeys, bucketKeyHashes} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if countClicks {
+			counts := tx.Bucket(bucketClickCounts)
+			clicks := tx.Bucket(bucketClicks)
+			err := clicks.ForEachBucket(func(code []byte) error {
+				n := clicks.Bucket(code).Stats().KeyN
+				return counts.Put(code, binary.BigEndian.AppendUint64(nil, uint64(n)))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// строим индекс поиска по сохраненным ссылкам
+		return tx.Bucket(bucketCodes).ForEach(func(code, data []byte) error {
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			index.Add(string(code), search.LinkTexts(string(code), r.URI, r.Tags)...)
+			return nil
+		})
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Storage{db: db, index: index}, nil
+}
+
+// Close closes the database file.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Get retrieves the value associated with the given code from the storage.
+// If the code is not found in the storage, it returns an empty string and models.ErrNotFound.
+// If the link was soft deleted, it returns models.ErrDeleted.
+// If the link is expired, it returns models.ErrExpired.
+// Example usage:
+//
+//	value, err := storage.Get(ctx, "code123", userID)
+//	if err != nil {
+//	    // handle error
+//	}
+//	// use value
+func (s *Storage) Get(ctx context.Context, code string, userID int) (string, error) {
+	link, err := s.GetLink(ctx, code)
+	return link.URI, err
+}
+
+// GetLink returns the link with the given code with the same errors as Get.
+func (s *Storage) GetLink(ctx context.Context, code string) (models.Link, error) {
+	var r record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketCodes).Get([]byte(code))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		return json.Unmarshal(data, &r)
+	})
+	if err != nil {
+		return models.Link{}, err
+	}
+
+	if r.IsDeleted {
+		return models.Link{}, models.ErrDeleted
+	}
+	link := models.Link{Code: code, URI: r.URI, UserID: r.UserID, Tags: r.Tags}
+	if r.ExpiresAt != nil {
+		if models.Expired(*r.ExpiresAt, time.Now()) {
+			return models.Link{}, models.ErrExpired
+		}
+		link.ExpiresAt = *r.ExpiresAt
+	}
+
+	return link, nil
+}
+
+// Set adds a new link to the storage in a single transaction.
+// The link is stored under its code, and the URI and the user indexes are updated.
+// If the code is already taken, it returns models.ErrCodeTaken.
+// If the URI is already shortened, it returns the existing code and models.ErrURIExists.
+// Otherwise, it returns the code and nil.
+// Example usage:
+//
+//	code, err := storage.Set(ctx, link)
+//	if err != nil {
+//	    // handle error
+//	}
+//	// use code
+func (s *Storage) Set(ctx context.Context, link models.Link) (string, error) {
+	var existing string
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		existing, err = put(tx, link, time.Now())
+		return err
+	})
+	if err != nil {
+		return existing, err
+	}
+	s.index.Add(link.Code, search.LinkTexts(link.Code, link.URI, link.Tags)...)
+
+	return link.Code, nil
+}
+
+// SetBatch adds all the given links to the storage in a single transaction.
+// If one of the links cannot be stored, the transaction is rolled back and *models.BatchError
+// with the index of the link is returned: models.ErrCodeTaken if the code is already taken,
+// models.ErrURIExists along with the existing code if the URI is already shortened.
+// Otherwise, it returns the codes in the order of the links.
+func (s *Storage) SetBatch(ctx context.Context, links []models.Link) ([]string, error) {
+	codes := make([]string, 0, len(links))
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		for i, link := range links {
+			existing, err := put(tx, link, now)
+			if errors.Is(err, models.ErrCodeTaken) || errors.Is(err, models.ErrURIExists) {
+				return &models.BatchError{Index: i, Code: existing, Err: err}
+			}
+			if err != nil {
+				return err
+			}
+			codes = append(codes, link.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		s.index.Add(link.Code, search.LinkTexts(link.Code, link.URI, link.Tags)...)
+	}
+
+	return codes, nil
+}
+
+// GetByUser retrieves the links created by a specific user, including the soft deleted ones,
+// filtered, sorted and limited by the query (see models.HistoryQuery).
+// Each link carries the number of its clicks.
+// The keys of the user bucket are sorted by the creation time and the code, so for the default order
+// the cursor seeks the position of the query and reads only the links of the page.
+// The links sorted by the number of clicks are read all and sorted in memory.
+// Example usage:
+//
+//	data, err := storage.GetByUser(ctx, userID, models.HistoryQuery{Limit: 100})
+//	if err != nil {
+//		// handle error
+//	}
+//	// use data
+func (s *Storage) GetByUser(ctx context.Context, userID int, query models.HistoryQuery) ([]models.UserLink, error) {
+	var links []models.UserLink
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		user := tx.Bucket(bucketUsers).Bucket(userKey(userID))
+		if user == nil {
+			return nil
+		}
+		codes := tx.Bucket(bucketCodes)
+		counts := tx.Bucket(bucketClickCounts)
+		userLink := func(k []byte) (models.UserLink, error) {
+			code := k[8:]
+			var r record
+			if err := json.Unmarshal(codes.Get(code), &r); err != nil {
+				return models.UserLink{}, err
+			}
+			return r.userLink(code, counts), nil
+		}
+
+		if query.SortBy == models.HistorySortClicks {
+			err := user.ForEach(func(k, _ []byte) error {
+				link, err := userLink(k)
+				if err != nil {
+					return err
+				}
+				links = append(links, link)
+				return nil
+			})
+			links = query.Apply(links)
+			return err
+		}
+
+		// курсор ставим на позицию страницы, сам курсор страницы отсекает Match
+		c := user.Cursor()
+		next := c.Next
+		var k []byte
+		switch {
+		case query.After != nil:
+			k, _ = c.Seek(indexKey(query.After.CreatedAt, query.After.Code))
+			if query.Desc {
+				next = c.Prev
+				if k == nil {
+					k, _ = c.Last()
+				}
+			}
+		case query.Desc:
+			k, _ = c.Last()
+			next = c.Prev
+		default:
+			k, _ = c.First()
+		}
+		for ; k != nil && (query.Limit <= 0 || len(links) < query.Limit); k, _ = next() {
+			link, err := userLink(k)
+			if err != nil {
+				return err
+			}
+			if query.Match(link) {
+				links = append(links, link)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Search returns up to limit links of the user (the newest first), whose code, URI or tag contains the query
+// (case-insensitive). The candidates are taken from the trigram index, the queries shorter than
+// three letters are checked against all the links of the user.
+func (s *Storage) Search(ctx context.Context, userID int, query string, limit int) ([]models.UserLink, error) {
+	candidates, indexed := s.index.Candidates(query)
+
+	var links []models.UserLink
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		codes := tx.Bucket(bucketCodes)
+		counts := tx.Bucket(bucketClickCounts)
+		check := func(code []byte) error {
+			data := codes.Get(code)
+			if data == nil {
+				return nil
+			}
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if r.UserID != userID || !search.Match(query, search.LinkTexts(string(code), r.URI, r.Tags)...) {
+				return nil
+			}
+			links = append(links, r.userLink(code, counts))
+			return nil
+		}
+
+		if indexed {
+			for _, code := range candidates {
+				if err := check([]byte(code)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		user := tx.Bucket(bucketUsers).Bucket(userKey(userID))
+		if user == nil {
+			return nil
+		}
+		return user.ForEach(func(k, _ []byte) error {
+			return check(k[8:])
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return models.HistoryQuery{Desc: true, Limit: limit}.Apply(links), nil
+}
+
+// SetTags replaces the tags of the link with the given code.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) SetTags(ctx context.Context, code string, userID int, tags []string) error {
+	var old record
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		codes := tx.Bucket(bucketCodes)
+		data := codes.Get([]byte(code))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.UserID != userID {
+			return models.ErrForbidden
+		}
+		old = r
+		r.Tags = tags
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return codes.Put([]byte(code), data)
+	})
+	if err != nil {
+		return err
+	}
+	s.index.Remove(code, search.LinkTexts(code, old.URI, old.Tags)...)
+	s.index.Add(code, search.LinkTexts(code, old.URI, tags)...)
+
+	return nil
+}
+
+// GetTagCounts returns the number of links of the user per tag, the most frequent tags first.
+func (s *Storage) GetTagCounts(ctx context.Context, userID int) ([]models.TagCount, error) {
+	var tagLists [][]string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		user := tx.Bucket(bucketUsers).Bucket(userKey(userID))
+		if user == nil {
+			return nil
+		}
+		codes := tx.Bucket(bucketCodes)
+
+		return user.ForEach(func(k, _ []byte) error {
+			var r record
+			if err := json.Unmarshal(codes.Get(k[8:]), &r); err != nil {
+				return err
+			}
+			tagLists = append(tagLists, r.Tags)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return models.CountTags(tagLists...), nil
+}
+
+// Update sets the new destination of the link with the given code in a single transaction
+// and returns the new revision. If the tags are not nil, they replace the tags of the link in the same transaction.
+// The link is removed from the URI index, so it is not returned for the old or the new URI by Set.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+// If the link was soft deleted, it returns models.ErrDeleted.
+func (s *Storage) Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error) {
+	var oldTexts, newTexts []string
+	var revision models.Revision
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		codes := tx.Bucket(bucketCodes)
+		data := codes.Get([]byte(code))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.UserID != userID {
+			return models.ErrForbidden
+		}
+		if r.IsDeleted {
+			return models.ErrDeleted
+		}
+
+		uris := tx.Bucket(bucketURIs)
+		if string(uris.Get([]byte(r.URI))) == code {
+			if err := uris.Delete([]byte(r.URI)); err != nil {
+				return err
+			}
+		}
+		oldTexts = search.LinkTexts(code, r.URI, r.Tags)
+		r.Revisions = models.Revise(r.Revisions, r.URI, r.CreatedAt, uri, time.Now())
+		r.URI = uri
+		if tags != nil {
+			r.Tags = *tags
+		}
+		revision = r.Revisions[len(r.Revisions)-1]
+		newTexts = search.LinkTexts(code, r.URI, r.Tags)
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return codes.Put([]byte(code), data)
+	})
+	if err != nil {
+		return models.Revision{}, err
+	}
+	s.index.Remove(code, oldTexts...)
+	s.index.Add(code, newTexts...)
+
+	return revision, nil
+}
+
+// GetRevisions returns the revisions of the link with the given code, the oldest first.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) GetRevisions(ctx context.Context, code string, userID int) ([]models.Revision, error) {
+	var r record
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketCodes).Get([]byte(code))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		return json.Unmarshal(data, &r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.UserID != userID {
+		return nil, models.ErrForbidden
+	}
+
+	return models.Revisions(r.Revisions, r.URI, r.CreatedAt), nil
+}
+
+// SoftDelete marks the links from the given messages as deleted in a single transaction.
+// A link is marked only if it belongs to the user from the message, other codes are ignored.
+// Example usage:
+//
+//	err := storage.SoftDelete(ctx, messages)
+//	if err != nil {
+//	    // handle error
+//	}
+//	// soft delete operation succeeded
+func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error {
+	now := time.Now()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		codes := tx.Bucket(bucketCodes)
+		for _, msg := range messages {
+			data := codes.Get([]byte(msg.Code))
+			if data == nil {
+				continue
+			}
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if r.UserID != msg.UserID || r.IsDeleted {
+				continue
+			}
+			r.IsDeleted = true
+			r.DeletedAt = &now
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err = codes.Put([]byte(msg.Code), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Restore clears the deleted flag of the links from the given messages in a single transaction.
+// A link is restored only if it belongs to the user from the message and was deleted at or after
+// deletedSince, other codes are ignored. It returns the codes of the restored links.
+func (s *Storage) Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error) {
+	var restored []string
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		codes := tx.Bucket(bucketCodes)
+		for _, msg := range messages {
+			data := codes.Get([]byte(msg.Code))
+			if data == nil {
+				continue
+			}
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if r.UserID != msg.UserID || !r.IsDeleted || r.DeletedAt == nil || r.DeletedAt.Before(deletedSince) {
+				continue
+			}
+			r.IsDeleted = false
+			r.DeletedAt = nil
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err = codes.Put([]byte(msg.Code), data); err != nil {
+				return err
+			}
+			restored = append(restored, msg.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// SaveClicks stores the click events of the links and increases the click counters of their codes
+// in a single transaction.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		added := make(map[string]uint64)
+		for _, click := range clicks {
+			bucket, err := tx.Bucket(bucketClicks).CreateBucketIfNotExists([]byte(click.Code))
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(clickRecord{
+				Time:      click.Time,
+				Referrer:  click.Referrer,
+				UserAgent: click.UserAgent,
+				IP:        click.IP,
+			})
+			if err != nil {
+				return err
+			}
+			key := binary.BigEndian.AppendUint64(timeKey(click.Time), seq)
+			if err = bucket.Put(key, data); err != nil {
+				return err
+			}
+			added[click.Code]++
+		}
+
+		counts := tx.Bucket(bucketClickCounts)
+		for code, n := range added {
+			n += uint64(clickCount(counts, []byte(code)))
+			if err := counts.Put([]byte(code), binary.BigEndian.AppendUint64(nil, n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetLinkStats returns the statistics of the clicks of the link with the given code in the time range [from, to)
+// with up to top most frequent referrers and user agents.
+// The keys of the clicks are sorted by time, so only the events of the range are read.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) GetLinkStats(ctx context.Context, code string, userID int, from, to time.Time, top int) (models.LinkStats, error) {
+	builder := models.NewClickStatsBuilder(code, from, to)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketCodes).Get([]byte(code))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.UserID != userID {
+			return models.ErrForbidden
+		}
+
+		bucket := tx.Bucket(bucketClicks).Bucket([]byte(code))
+		if bucket == nil {
+			return nil
+		}
+		end := timeKey(to)
+		c := bucket.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+			var cr clickRecord
+			if err := json.Unmarshal(v, &cr); err != nil {
+				return err
+			}
+			builder.Add(models.Click{
+				Code:      code,
+				Time:      cr.Time,
+				Referrer:  cr.Referrer,
+				UserAgent: cr.UserAgent,
+				IP:        cr.IP,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+	return builder.Stats(top), nil
+}
+
+// CreateUser registers the user with the given login and password hash and returns the ID of the user.
+// The ID is the next value of the sequence of the accounts bucket shifted by models.FirstUserID.
+// If the login is already registered, it returns models.ErrLoginTaken.
+func (s *Storage) CreateUser(ctx context.Context, login string, passwordHash string) (int, error) {
+	var userID int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		accounts := tx.Bucket(bucketAccounts)
+		if accounts.Get([]byte(login)) != nil {
+			return models.ErrLoginTaken
+		}
+		var err error
+		if userID, err = nextUserID(tx); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(accountRecord{ID: userID, PasswordHash: passwordHash, CreatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		return accounts.Put([]byte(login), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// GetUser returns the registered user with the given login.
+// If the login is not registered, it returns models.ErrNotFound.
+func (s *Storage) GetUser(ctx context.Context, login string) (models.User, error) {
+	var r accountRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketAccounts).Get([]byte(login))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		return json.Unmarshal(data, &r)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return models.User{ID: r.ID, Login: login, PasswordHash: r.PasswordHash, CreatedAt: r.CreatedAt}, nil
+}
+
+// CreateAnonymousUser stores the anonymous user with the given UUID, which is valid until expiresAt,
+// and returns the ID of the user.
+func (s *Storage) CreateAnonymousUser(ctx context.Context, uuid string, expiresAt time.Time) (int, error) {
+	var userID int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		if userID, err = nextUserID(tx); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(anonymousRecord{UUID: uuid, CreatedAt: time.Now(), ExpiresAt: expiresAt})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketAnonymous).Put(userKey(userID), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// ClaimLinks gives all the links of the anonymous user to the user and removes the anonymous user
+// in a single transaction. It returns the number of the given links.
+// If there is no such anonymous user or it is expired, it returns models.ErrNotFound.
+func (s *Storage) ClaimLinks(ctx context.Context, anonymousID int, userID int) (int, error) {
+	claimed := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		anonymous := tx.Bucket(bucketAnonymous)
+		data := anonymous.Get(userKey(anonymousID))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		var a anonymousRecord
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+		if models.Expired(a.ExpiresAt, time.Now()) {
+			return models.ErrNotFound
+		}
+		if err := anonymous.Delete(userKey(anonymousID)); err != nil {
+			return err
+		}
+
+		links := tx.Bucket(bucketUsers).Bucket(userKey(anonymousID))
+		if links == nil {
+			return nil
+		}
+		owner, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists(userKey(userID))
+		if err != nil {
+			return err
+		}
+		codes := tx.Bucket(bucketCodes)
+		err = links.ForEach(func(key, _ []byte) error {
+			code := key[8:]
+			var r record
+			if err := json.Unmarshal(codes.Get(code), &r); err != nil {
+				return err
+			}
+			r.UserID = userID
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err = codes.Put(code, data); err != nil {
+				return err
+			}
+			claimed++
+			return owner.Put(key, nil)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketUsers).DeleteBucket(userKey(anonymousID))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return claimed, nil
+}
+
+// PurgeAnonymousUsers removes the anonymous users which are expired at the moment now in a single transaction
+// and returns the number of the removed users. The links of the removed users are kept.
+func (s *Storage) PurgeAnonymousUsers(ctx context.Context, now time.Time) (int, error) {
+	purged := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		anonymous := tx.Bucket(bucketAnonymous)
+		// ключи удаляем после обхода: менять бакет внутри ForEach нельзя
+		var expired [][]byte
+		err := anonymous.ForEach(func(key, data []byte) error {
+			var a anonymousRecord
+			if err := json.Unmarshal(data, &a); err != nil {
+				return err
+			}
+			if models.Expired(a.ExpiresAt, now) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err = anonymous.Delete(key); err != nil {
+				return err
+			}
+		}
+		purged = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// CreateAPIKey stores the API key and its hash in the reverse index in a single transaction.
+// If the ID or the hash of the key is already taken, it returns models.ErrConflict.
+func (s *Storage) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket(bucketKeys)
+		hashes := tx.Bucket(bucketKeyHashes)
+		if keys.Get([]byte(key.ID)) != nil || hashes.Get([]byte(key.Hash)) != nil {
+			return models.ErrConflict
+		}
+
+		r := apiKeyRecord{UserID: key.UserID, Name: key.Name, Scopes: key.Scopes, Hash: key.Hash, CreatedAt: key.CreatedAt}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err = keys.Put([]byte(key.ID), data); err != nil {
+			return err
+		}
+		return hashes.Put([]byte(key.Hash), []byte(key.ID))
+	})
+}
+
+// GetAPIKeys returns the API keys of the user, the oldest first.
+// The keys are found by a scan of the keys bucket: a user has only a few of them.
+func (s *Storage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	keys := make([]models.APIKey, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketKeys).ForEach(func(id, data []byte) error {
+			var r apiKeyRecord
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			if r.UserID == userID {
+				keys = append(keys, r.apiKey(id))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the API key with the given hash.
+// If there is no such key, it returns models.ErrNotFound.
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(bucketKeyHashes).Get([]byte(hash))
+		if id == nil {
+			return models.ErrNotFound
+		}
+		var r apiKeyRecord
+		if err := json.Unmarshal(tx.Bucket(bucketKeys).Get(id), &r); err != nil {
+			return err
+		}
+		key = r.apiKey(id)
+		return nil
+	})
+	return key, err
+}
+
+// DeleteAPIKey removes the API key and its hash from the reverse index in a single transaction.
+// If the key is not found, it returns models.ErrNotFound.
+// If the key belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string, userID int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket(bucketKeys)
+		data := keys.Get([]byte(id))
+		if data == nil {
+			return models.ErrNotFound
+		}
+		var r apiKeyRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.UserID != userID {
+			return models.ErrForbidden
+		}
+		if err := tx.Bucket(bucketKeyHashes).Delete([]byte(r.Hash)); err != nil {
+			return err
+		}
+		return keys.Delete([]byte(id))
+	})
+}
+
+// HealthCheck checks that the database file is still open.
+func (s *Storage) HealthCheck(ctx context.Context) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// GetStats returns the statistics of the links. It reads all the links in a single transaction.
+// The details contain the path and the size of the database file.
+func (s *Storage) GetStats(ctx context.Context, since time.Time, top int) (models.StorageStats, error) {
+	builder := models.NewStatsBuilder(since)
+	var size int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		size = tx.Size()
+		return tx.Bucket(bucketCodes).ForEach(func(_, data []byte) error {
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			builder.Add(r.UserID, r.IsDeleted, r.CreatedAt)
+			return nil
+		})
+	})
+	if err != nil {
+		return models.StorageStats{}, err
+	}
+
+	stats := builder.Stats(top)
+	stats.Backend = "bolt"
+	stats.Details = map[string]string{
+		"path": s.db.Path(),
+		"size": strconv.FormatInt(size, 10),
+	}
+	return stats, nil
+}
+
+// put stores the link and updates the indexes within the transaction.
+// If the URI is already shortened, it returns the existing code and models.ErrURIExists.
+func put(tx *bbolt.Tx, link models.Link, createdAt time.Time) (string, error) {
+	codes := tx.Bucket(bucketCodes)
+	if codes.Get([]byte(link.Code)) != nil {
+		return "", models.ErrCodeTaken
+	}
+	uris := tx.Bucket(bucketURIs)
+	if code := uris.Get([]byte(link.URI)); code != nil {
+		return string(code), models.ErrURIExists
+	}
+
+	r := record{URI: link.URI, UserID: link.UserID, CreatedAt: createdAt, Tags: link.Tags}
+	if !link.ExpiresAt.IsZero() {
+		r.ExpiresAt = &link.ExpiresAt
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	if err = codes.Put([]byte(link.Code), data); err != nil {
+		return "", err
+	}
+	if err = uris.Put([]byte(link.URI), []byte(link.Code)); err != nil {
+		return "", err
+	}
+	user, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists(userKey(link.UserID))
+	if err != nil {
+		return "", err
+	}
+	return "", user.Put(indexKey(r.CreatedAt, link.Code), nil)
+}
+
+// nextUserID returns the next ID of a user given by the sequence of the accounts bucket.
+func nextUserID(tx *bbolt.Tx) (int, error) {
+	seq, err := tx.Bucket(bucketAccounts).NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	return models.FirstUserID + int(seq) - 1, nil
+}
+
+// userKey returns the name of the nested bucket of the user.
+func userKey(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+// indexKey returns the key of the link in the user bucket: the creation time (see timeKey) followed by the code.
+func indexKey(createdAt time.Time, code string) []byte {
+	return append(timeKey(createdAt), code...)
+}
+
+// timeKey returns the time in nanoseconds encoded in big-endian, so that the keys are sorted by time.
+func timeKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 16), uint64(t.UnixNano()))
+}
@@ -0,0 +1,652 @@
+package bolt
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func TestStorage(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := storage.Set(ctx, models.Link{Code: "key", URI: "https://site.com"})
+	assert.Equal(t, "key", key)
+	assert.NoError(t, err)
+
+	uri, err := storage.Get(ctx, key, 0)
+	assert.Equal(t, "https://site.com", uri)
+	assert.NoError(t, err)
+
+	uri, err = storage.Get(ctx, "not-exists", 0)
+	assert.Empty(t, uri)
+	assert.Error(t, err)
+
+	_, err = storage.Set(ctx, models.Link{Code: "key", URI: "https://ya.ru"})
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+
+	// повторное сокращение возвращает существующий код
+	code, err := storage.Set(ctx, models.Link{Code: "other", URI: "https://site.com"})
+	assert.Equal(t, "key", code)
+	assert.ErrorIs(t, err, models.ErrURIExists)
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	assert.Empty(t, data)
+	assert.NoError(t, err)
+
+	stats, err := storage.GetStats(ctx, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Urls)
+	assert.Equal(t, 1, stats.Live)
+	assert.Equal(t, 1, stats.Users)
+	assert.Equal(t, "bolt", stats.Backend)
+
+	assert.NoError(t, storage.HealthCheck(ctx))
+}
+
+func TestStorageUserLinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code3", URI: "https://site.com", UserID: 2})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{
+		Code:      "code4",
+		URI:       "https://old.com",
+		UserID:    2,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	// чужие ссылки не удаляются
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{
+		{UserID: 1, Code: "code1"},
+		{UserID: 1, Code: "code3"},
+	})
+	require.NoError(t, err)
+
+	// после переоткрытия файла данные сохраняются
+	require.NoError(t, storage.Close())
+	assert.Error(t, storage.HealthCheck(ctx))
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	assert.Equal(t, "code1", data[0].Code)
+	assert.Equal(t, "https://google.com", data[0].URI)
+	assert.Equal(t, "code2", data[1].Code)
+	assert.Equal(t, "https://ya.ru", data[1].URI)
+
+	uri, err := storage.Get(ctx, "code1", 1)
+	assert.Empty(t, uri)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+
+	uri, err = storage.Get(ctx, "code3", 2)
+	assert.Equal(t, "https://site.com", uri)
+	assert.NoError(t, err)
+
+	uri, err = storage.Get(ctx, "code4", 2)
+	assert.Empty(t, uri)
+	assert.ErrorIs(t, err, models.ErrExpired)
+
+	stats, err := storage.GetStats(ctx, time.Time{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Urls)
+	assert.Equal(t, 3, stats.Live)
+	assert.Equal(t, 1, stats.Deleted)
+	assert.Equal(t, 2, stats.Users)
+	assert.Equal(t, []models.UserCount{{UserID: 1, Links: 2}}, stats.TopUsers)
+}
+
+func TestStorageSetBatch(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "taken", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	codes, err := storage.SetBatch(ctx, []models.Link{
+		{Code: "code1", URI: "https://ya.ru", UserID: 1},
+		{Code: "code2", URI: "https://site.com", UserID: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1", "code2"}, codes)
+
+	// пакет с занятым кодом или URI не сохраняется
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code3", URI: "https://go.dev", UserID: 1},
+		{Code: "taken", URI: "https://go.dev/doc", UserID: 1},
+	})
+	var batchErr *models.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code3", URI: "https://go.dev", UserID: 1},
+		{Code: "code4", URI: "https://google.com", UserID: 1},
+	})
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.Equal(t, "taken", batchErr.Code)
+	assert.ErrorIs(t, err, models.ErrURIExists)
+
+	_, err = storage.Get(ctx, "code3", 1)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, data, 3)
+}
+
+func TestStorageSaveClicks(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	err = storage.SaveClicks(ctx, []models.Click{
+		{Code: "code1", Time: now, Referrer: "https://ya.ru"},
+		{Code: "code1", Time: now},
+		{Code: "code2", Time: now},
+	})
+	require.NoError(t, err)
+
+	counts := make(map[string]int)
+	err = storage.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketClicks).ForEachBucket(func(code []byte) error {
+			counts[string(code)] = tx.Bucket(bucketClicks).Bucket(code).Stats().KeyN
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"code1": 2, "code2": 1}, counts)
+
+	// счетчики кликов обновляются вместе с событиями
+	err = storage.SaveClicks(ctx, []models.Click{{Code: "code1", Time: now}})
+	require.NoError(t, err)
+	err = storage.db.View(func(tx *bbolt.Tx) error {
+		counts := tx.Bucket(bucketClickCounts)
+		assert.Equal(t, 3, clickCount(counts, []byte("code1")))
+		assert.Equal(t, 1, clickCount(counts, []byte("code2")))
+		assert.Equal(t, 0, clickCount(counts, []byte("code3")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestStorageClickCountsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	now := time.Now()
+	err = storage.SaveClicks(ctx, []models.Click{{Code: "code1", Time: now}, {Code: "code1", Time: now}})
+	require.NoError(t, err)
+
+	// файл прежней версии без счетчиков кликов
+	err = storage.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucketClickCounts)
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	links, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, 2, links[0].Clicks)
+}
+
+func TestStorageGetLinkStats(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	err = storage.SaveClicks(ctx, []models.Click{
+		{Code: "code1", Time: now.Add(-2 * time.Hour)},
+		{Code: "code1", Time: now.Add(-time.Hour), Referrer: "https://ya.ru", UserAgent: "curl/8.0", IP: "10.0.0.1"},
+		{Code: "code1", Time: now},
+	})
+	require.NoError(t, err)
+
+	// правая граница диапазона не включается
+	stats, err := storage.GetLinkStats(ctx, "code1", 1, now.Add(-time.Hour), now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Clicks)
+	assert.Equal(t, 1, stats.Visitors)
+	assert.Equal(t, []models.TopValue{{Value: "https://ya.ru", Clicks: 1}}, stats.TopReferrers)
+	assert.Equal(t, []models.TopValue{{Value: "curl/8.0", Clicks: 1}}, stats.TopUserAgents)
+
+	_, err = storage.GetLinkStats(ctx, "code1", 2, now.Add(-time.Hour), now, 10)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	_, err = storage.GetLinkStats(ctx, "not-exists", 1, now.Add(-time.Hour), now, 10)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestStorageGetByUserQuery(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code2", URI: "https://news.ya.ru", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code3", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveClicks(ctx, []models.Click{{Code: "code2", Time: time.Now()}}))
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{SortBy: models.HistorySortClicks, Desc: true, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	assert.Equal(t, "code2", data[0].Code)
+	assert.Equal(t, 1, data[0].Clicks)
+	assert.Equal(t, "code3", data[1].Code)
+
+	// следующая страница начинается после курсора
+	data, err = storage.GetByUser(ctx, 1, models.HistoryQuery{Domain: "ya.ru", After: &models.HistoryCursor{
+		CreatedAt: data[0].CreatedAt,
+		Code:      data[0].Code,
+	}})
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	assert.Equal(t, "code3", data[0].Code)
+}
+
+func TestStorageGetByUserPages(t *testing.T) {
+	storage, err := NewStorage(filepath.Join(t.TempDir(), "short-url.db"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// ссылки пакета создаются в одно время и упорядочены по коду
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "b1", URI: "https://ya.ru/1", UserID: 1},
+		{Code: "a1", URI: "https://google.com/1", UserID: 1},
+		{Code: "c1", URI: "https://ya.ru/2", UserID: 1},
+	})
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		code := "code" + strconv.Itoa(i)
+		_, err = storage.Set(ctx, models.Link{Code: code, URI: "https://ya.ru/" + code, UserID: 1})
+		require.NoError(t, err)
+	}
+	all, err := storage.GetByUser(ctx, 1, models.HistoryQuery{SortBy: models.HistorySortClicks})
+	require.NoError(t, err)
+	require.Len(t, all, 7)
+
+	// постраничное чтение курсором совпадает с сортировкой всех ссылок
+	for _, query := range []models.HistoryQuery{
+		{Limit: 2},
+		{Limit: 2, Desc: true},
+		{Limit: 3, Domain: "ya.ru"},
+		{Limit: 3, Domain: "ya.ru", Desc: true},
+	} {
+		var got []models.UserLink
+		for {
+			page, errPage := storage.GetByUser(ctx, 1, query)
+			require.NoError(t, errPage)
+			got = append(got, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			cursor := page[len(page)-1].Cursor()
+			query.After = &cursor
+		}
+		query.After = nil
+		query.Limit = 0
+		assert.Equal(t, query.Apply(all), got)
+	}
+}
+
+func TestStorageSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/pricing", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code2", URI: "https://site.com/about", UserID: 1, Tags: []string{"team-page"}},
+		{Code: "code3", URI: "https://other.com/pricing", UserID: 2},
+	})
+	require.NoError(t, err)
+
+	// индекс поиска восстанавливается после переоткрытия файла
+	require.NoError(t, storage.Close())
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	links, err := storage.Search(ctx, 1, "Pricing", 10)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "code1", links[0].Code)
+
+	links, err = storage.Search(ctx, 1, "co", 10)
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+
+	// ссылка находится по тегу, в том числе после его замены
+	links, err = storage.Search(ctx, 1, "team", 10)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "code2", links[0].Code)
+	require.NoError(t, storage.SetTags(ctx, "code2", 1, []string{"staff"}))
+	links, err = storage.Search(ctx, 1, "team", 10)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+	links, err = storage.Search(ctx, 1, "staff", 10)
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+}
+
+func TestStorageTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/shoes", UserID: 1, Tags: []string{"shoes", "spring"}})
+	require.NoError(t, err)
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code2", URI: "https://site.com/hats", UserID: 1, Tags: []string{"spring"}},
+		{Code: "code3", URI: "https://site.com/bags", UserID: 2, Tags: []string{"spring"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.SetTags(ctx, "code2", 1, []string{"hats"}))
+	assert.ErrorIs(t, storage.SetTags(ctx, "code3", 1, nil), models.ErrForbidden)
+	assert.ErrorIs(t, storage.SetTags(ctx, "unknown", 1, nil), models.ErrNotFound)
+
+	links, err := storage.GetByUser(ctx, 1, models.HistoryQuery{Tag: "hats"})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "code2", links[0].Code)
+	assert.Equal(t, []string{"hats"}, links[0].Tags)
+
+	counts, err := storage.GetTagCounts(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "hats", Links: 1}, {Tag: "shoes", Links: 1}, {Tag: "spring", Links: 1}}, counts)
+
+	counts, err = storage.GetTagCounts(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestStorageUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/old", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code2", URI: "https://site.com/taken", UserID: 1})
+	require.NoError(t, err)
+
+	// новый адрес может совпадать с адресом другой ссылки
+	revision, err := storage.Update(ctx, "code1", 1, "https://site.com/taken", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, revision.Number)
+
+	uri, err := storage.Get(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/taken", uri)
+
+	// измененная ссылка больше не считается сокращением ни старого, ни нового адреса
+	_, err = storage.Set(ctx, models.Link{Code: "code3", URI: "https://site.com/old", UserID: 1})
+	assert.NoError(t, err)
+	existing, err := storage.Set(ctx, models.Link{Code: "code4", URI: "https://site.com/taken", UserID: 1})
+	assert.ErrorIs(t, err, models.ErrURIExists)
+	assert.Equal(t, "code2", existing)
+
+	revisions, err := storage.GetRevisions(ctx, "code1", 1)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "https://site.com/old", revisions[0].URI)
+	assert.Equal(t, "https://site.com/taken", revisions[1].URI)
+
+	// теги меняются в той же транзакции
+	_, err = storage.Update(ctx, "code1", 1, "https://site.com/tagged", &[]string{"sale"})
+	require.NoError(t, err)
+	link, err := storage.GetLink(ctx, "code1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sale"}, link.Tags)
+
+	_, err = storage.Update(ctx, "code1", 2, "https://site.com/other", nil)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = storage.GetRevisions(ctx, "unknown", 1)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, code := range []string{"code1", "code2"} {
+		_, err = storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: 1})
+		require.NoError(t, err)
+	}
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}})
+	require.NoError(t, err)
+
+	// ссылки, удаленные раньше периода, не восстанавливаются
+	restored, err := storage.Restore(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+
+	restored, err = storage.Restore(ctx, []models.RmvUrlsMsg{
+		{UserID: 2, Code: "code1"},
+		{UserID: 1, Code: "code1"},
+		{UserID: 1, Code: "code2"},
+	}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1"}, restored)
+
+	uri, err := storage.Get(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/code1", uri)
+}
+
+func TestStorageUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	aliceID, err := storage.CreateUser(ctx, "alice", "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, models.FirstUserID, aliceID)
+	_, err = storage.CreateUser(ctx, "alice", "hash2")
+	assert.ErrorIs(t, err, models.ErrLoginTaken)
+	_, err = storage.GetUser(ctx, "bob")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	require.NoError(t, storage.Close())
+
+	// последовательность идентификаторов хранится в файле
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	user, err := storage.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, aliceID, user.ID)
+	assert.Equal(t, "hash1", user.PasswordHash)
+
+	bobID, err := storage.CreateUser(ctx, "bob", "hash3")
+	require.NoError(t, err)
+	assert.Equal(t, aliceID+1, bobID)
+}
+
+func TestStorageClaimLinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid1", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	expiredID, err := storage.CreateAnonymousUser(ctx, "uuid2", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	assert.Equal(t, []int{models.FirstUserID, models.FirstUserID + 1, models.FirstUserID + 2}, []int{anonymousID, expiredID, userID})
+
+	for _, code := range []string{"code1", "code2"} {
+		_, err = storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: anonymousID})
+		require.NoError(t, err)
+	}
+
+	claimed, err := storage.ClaimLinks(ctx, anonymousID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, claimed)
+	links, err := storage.GetByUser(ctx, userID, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+	require.NoError(t, storage.SetTags(ctx, "code1", userID, []string{"claimed"}))
+
+	// анонимный пользователь удален, истекший передать ссылки не может
+	_, err = storage.ClaimLinks(ctx, anonymousID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = storage.ClaimLinks(ctx, expiredID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestStoragePurgeAnonymousUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid1", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	for _, uuid := range []string{"uuid2", "uuid3"} {
+		_, err = storage.CreateAnonymousUser(ctx, uuid, time.Now().Add(-time.Second))
+		require.NoError(t, err)
+	}
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+
+	purged, err := storage.PurgeAnonymousUsers(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	purged, err = storage.PurgeAnonymousUsers(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	// действующий анонимный пользователь остается
+	_, err = storage.ClaimLinks(ctx, anonymousID, userID)
+	assert.NoError(t, err)
+}
+
+func TestStorageAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short-url.db")
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key1 := models.APIKey{ID: "k1", UserID: 1, Name: "ci", Scopes: []string{models.ScopeLinksRead}, Hash: "hash1", CreatedAt: now}
+	key2 := models.APIKey{ID: "k2", UserID: 1, Scopes: []string{models.ScopeLinksWrite}, Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	key3 := models.APIKey{ID: "k0", UserID: 2, Scopes: []string{models.ScopeStatsRead}, Hash: "hash3", CreatedAt: now}
+	for _, key := range []models.APIKey{key2, key1, key3} {
+		require.NoError(t, storage.CreateAPIKey(ctx, key))
+	}
+	assert.ErrorIs(t, storage.CreateAPIKey(ctx, key1), models.ErrConflict)
+	require.NoError(t, storage.Close())
+
+	// ключи хранятся в файле, ключи пользователя возвращаются от старых к новым
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	keys, err := storage.GetAPIKeys(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.APIKey{key1, key2}, keys)
+	key, err := storage.GetAPIKeyByHash(ctx, "hash3")
+	require.NoError(t, err)
+	assert.Equal(t, key3, key)
+
+	// отозвать ключ может только его владелец
+	assert.ErrorIs(t, storage.DeleteAPIKey(ctx, "k1", 2), models.ErrForbidden)
+	require.NoError(t, storage.DeleteAPIKey(ctx, "k1", 1))
+	assert.ErrorIs(t, storage.DeleteAPIKey(ctx, "k1", 1), models.ErrNotFound)
+	_, err = storage.GetAPIKeyByHash(ctx, "hash1")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
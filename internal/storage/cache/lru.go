@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entry is a cached result of a lookup: either the URI or the error returned by the storage.
+type entry struct {
+	code      string
+	uri       string
+	err       error
+	expiresAt time.Time
+}
+
+// lookup is a lookup of a code in the storage which is in progress.
+// The version is increased by every removal of the code during the lookup.
+type lookup struct {
+	version uint64
+	readers int
+}
+
+// lru is a bounded map of entries which evicts the least recently used entry when it is full.
+// Every entry also has its own expiration time.
+// The codes being looked up in the storage are versioned: an entry read from the storage before
+// a removal of its code may be stale, so put ignores it (see version). The removals of other codes
+// do not affect the entry.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	lookups map[string]*lookup
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+		lookups: make(map[string]*lookup),
+	}
+}
+
+// get returns the entry of the code, if it exists and is not expired at the moment now.
+func (c *lru) get(code string, now time.Time) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[code]
+	if !ok {
+		return entry{}, false
+	}
+	e := el.Value.(*entry)
+	if !now.Before(e.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, code)
+		return entry{}, false
+	}
+	c.order.MoveToFront(el)
+	return *e, true
+}
+
+// version starts a lookup of the code in the storage and returns the current version of the code.
+// The lookup must be finished with put or release.
+func (c *lru) version(code string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.lookups[code]
+	if !ok {
+		l = &lookup{}
+		c.lookups[code] = l
+	}
+	l.readers++
+	return l.version
+}
+
+// release finishes the lookup of the code started by version without storing an entry.
+func (c *lru) release(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.finish(code)
+}
+
+// put finishes the lookup of the code of the entry and stores the entry, evicting the least recently
+// used one if the cache is full. The entry is ignored if its code has been removed since the version was taken.
+func (c *lru) put(e entry, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.finish(e.code) != version {
+		return
+	}
+	if el, ok := c.entries[e.code]; ok {
+		*el.Value.(*entry) = e
+		c.order.MoveToFront(el)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).code)
+	}
+	c.entries[e.code] = c.order.PushFront(&e)
+}
+
+// finish finishes a lookup of the code and returns the version of the code.
+// The version is forgotten after the last lookup. The caller must hold c.mu.
+func (c *lru) finish(code string) uint64 {
+	l := c.lookups[code]
+	l.readers--
+	if l.readers == 0 {
+		delete(c.lookups, code)
+	}
+	return l.version
+}
+
+// remove deletes the entry of the code.
+func (c *lru) remove(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.lookups[code]; ok {
+		l.version++
+	}
+	if el, ok := c.entries[code]; ok {
+		c.order.Remove(el)
+		delete(c.entries, code)
+	}
+}
+
+// len returns the number of entries in the cache.
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,186 @@
+// Package cache provides a read-through cache for any uricoder.Storage.
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/uricoder"
+)
+
+const (
+	// DefaultSize is the default maximal number of cached codes.
+	DefaultSize = 10000
+	// DefaultTTL is the default lifetime of a cached URI.
+	DefaultTTL = time.Minute
+	// DefaultNegativeTTL is the default lifetime of a cached miss (unknown, deleted or expired code).
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// Option is a function that configures the Storage in NewStorage.
+type Option func(*Storage)
+
+// WithSize sets the maximal number of cached codes.
+func WithSize(size int) Option {
+	return func(s *Storage) {
+		s.size = size
+	}
+}
+
+// WithTTL sets the lifetime of a cached URI.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.ttl = ttl
+	}
+}
+
+// WithNegativeTTL sets the lifetime of a cached miss.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.negativeTTL = ttl
+	}
+}
+
+// Storage is a decorator which caches the results of Get of the wrapped storage.
+// The cache is a bounded LRU map of codes: the found URIs are kept for the TTL,
+// while the misses (models.ErrNotFound, models.ErrDeleted and models.ErrExpired)
+// are kept for the negative TTL. Other errors are never cached.
+// The codes are removed from the cache when they are set, updated, soft deleted or restored.
+// A URI of an expiring link is kept no longer than until the link expires.
+// The codes are removed only from the cache of this process: when several instances share a database,
+// the others keep returning the old results for up to the TTL, so the cache suits a single instance only.
+// Example usage:
+//
+//	storage := cache.NewStorage(database, cache.WithSize(1000))
+//	value, err := storage.Get(ctx, code, userID)
+type Storage struct {
+	uricoder.Storage
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     *lru
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewStorage wraps the given storage with a cache.
+// By default, it keeps up to DefaultSize codes for DefaultTTL (DefaultNegativeTTL for the misses).
+func NewStorage(s uricoder.Storage, opts ...Option) *Storage {
+	instance := &Storage{
+		Storage:     s,
+		size:        DefaultSize,
+		ttl:         DefaultTTL,
+		negativeTTL: DefaultNegativeTTL,
+	}
+	for _, opt := range opts {
+		opt(instance)
+	}
+	if instance.size <= 0 {
+		instance.size = 1
+	}
+	instance.entries = newLRU(instance.size)
+
+	return instance
+}
+
+// Get retrieves the value associated with the given code from the cache.
+// If the code is not cached, it is retrieved from the wrapped storage and the result is cached.
+func (s *Storage) Get(ctx context.Context, code string, userID int) (string, error) {
+	now := time.Now()
+	if e, ok := s.entries.get(code, now); ok {
+		s.hits.Add(1)
+		return e.uri, e.err
+	}
+	s.misses.Add(1)
+
+	// версию кода запоминаем до запроса, чтобы не закешировать устаревший ответ
+	version := s.entries.version(code)
+	link, err := s.Storage.GetLink(ctx, code)
+	switch {
+	case err == nil:
+		expiresAt := now.Add(s.ttl)
+		if !link.ExpiresAt.IsZero() && link.ExpiresAt.Before(expiresAt) {
+			expiresAt = link.ExpiresAt
+		}
+		s.entries.put(entry{code: code, uri: link.URI, expiresAt: expiresAt}, version)
+	case isMiss(err):
+		s.entries.put(entry{code: code, err: err, expiresAt: now.Add(s.negativeTTL)}, version)
+	default:
+		s.entries.release(code)
+	}
+
+	return link.URI, err
+}
+
+// Set adds a new link to the wrapped storage.
+// The code is removed from the cache, since a miss may have been cached for it.
+func (s *Storage) Set(ctx context.Context, link models.Link) (string, error) {
+	code, err := s.Storage.Set(ctx, link)
+	s.entries.remove(link.Code)
+	return code, err
+}
+
+// SetBatch adds the links to the wrapped storage and removes their codes from the cache.
+func (s *Storage) SetBatch(ctx context.Context, links []models.Link) ([]string, error) {
+	codes, err := s.Storage.SetBatch(ctx, links)
+	for _, link := range links {
+		s.entries.remove(link.Code)
+	}
+	return codes, err
+}
+
+// Update sets the new destination of the link in the wrapped storage and removes its code from the cache.
+func (s *Storage) Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error) {
+	revision, err := s.Storage.Update(ctx, code, userID, uri, tags)
+	s.entries.remove(code)
+	return revision, err
+}
+
+// SoftDelete marks the links from the given messages as deleted in the wrapped storage
+// and removes their codes from the cache.
+func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error {
+	err := s.Storage.SoftDelete(ctx, messages)
+	for _, msg := range messages {
+		s.entries.remove(msg.Code)
+	}
+	return err
+}
+
+// Restore clears the deleted flag of the links from the given messages in the wrapped storage
+// and removes their codes from the cache, since the deletion may have been cached.
+func (s *Storage) Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error) {
+	restored, err := s.Storage.Restore(ctx, messages, deletedSince)
+	for _, msg := range messages {
+		s.entries.remove(msg.Code)
+	}
+	return restored, err
+}
+
+// CacheStats returns the number of cache hits and misses.
+func (s *Storage) CacheStats() models.CacheStats {
+	return models.CacheStats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
+}
+
+// Close closes the wrapped storage, if it can be closed.
+func (s *Storage) Close() error {
+	if closer, ok := s.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// isMiss reports whether the error means that there is no link to redirect to.
+func isMiss(err error) bool {
+	return errors.Is(err, models.ErrNotFound) ||
+		errors.Is(err, models.ErrDeleted) ||
+		errors.Is(err, models.ErrExpired)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+// countingStorage counts the calls of GetLink and fails them on demand.
+type countingStorage struct {
+	*memory.Storage
+	gets int
+	err  error
+}
+
+func (s *countingStorage) GetLink(ctx context.Context, code string) (models.Link, error) {
+	s.gets++
+	if s.err != nil {
+		return models.Link{}, s.err
+	}
+	return s.Storage.GetLink(ctx, code)
+}
+
+func TestStorage(t *testing.T) {
+	backend := &countingStorage{Storage: memory.NewStorage()}
+	storage := NewStorage(backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com", UserID: 1})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		uri, err := storage.Get(ctx, "code1", 0)
+		assert.Equal(t, "https://site.com", uri)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, backend.gets)
+
+	// промахи тоже кешируются
+	for i := 0; i < 2; i++ {
+		_, err = storage.Get(ctx, "code2", 0)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	}
+	assert.Equal(t, 2, backend.gets)
+
+	// новая ссылка сбрасывает закешированный промах
+	_, err = storage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	uri, err := storage.Get(ctx, "code2", 0)
+	assert.Equal(t, "https://ya.ru", uri)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, backend.gets)
+
+	// изменение адреса сбрасывает закешированную ссылку
+	_, err = storage.Update(ctx, "code2", 1, "https://ya.ru/new", nil)
+	require.NoError(t, err)
+	uri, err = storage.Get(ctx, "code2", 0)
+	assert.Equal(t, "https://ya.ru/new", uri)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, backend.gets)
+
+	// удаление сбрасывает закешированную ссылку
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}})
+	require.NoError(t, err)
+	_, err = storage.Get(ctx, "code1", 0)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+	assert.Equal(t, 5, backend.gets)
+
+	// прочие ошибки не кешируются
+	backend.err = errors.New("connection refused")
+	_, err = storage.Get(ctx, "code3", 0)
+	assert.Error(t, err)
+	backend.err = nil
+	_, err = storage.Get(ctx, "code3", 0)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.Equal(t, 7, backend.gets)
+
+	assert.Equal(t, models.CacheStats{Hits: 3, Misses: 7}, storage.CacheStats())
+}
+
+func TestStorageEviction(t *testing.T) {
+	backend := &countingStorage{Storage: memory.NewStorage()}
+	storage := NewStorage(backend, WithSize(2), WithTTL(50*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, code := range []string{"code1", "code2", "code3"} {
+		_, err := storage.Set(ctx, models.Link{Code: code, URI: "https://" + code + ".com"})
+		require.NoError(t, err)
+	}
+
+	_, _ = storage.Get(ctx, "code1", 0)
+	_, _ = storage.Get(ctx, "code2", 0)
+	_, _ = storage.Get(ctx, "code1", 0)
+	_, _ = storage.Get(ctx, "code3", 0) // вытесняет code2
+	assert.Equal(t, 2, storage.entries.len())
+	assert.Equal(t, 3, backend.gets)
+
+	_, _ = storage.Get(ctx, "code1", 0)
+	assert.Equal(t, 3, backend.gets)
+	_, _ = storage.Get(ctx, "code2", 0)
+	assert.Equal(t, 4, backend.gets)
+
+	// по истечении TTL ссылка читается из хранилища заново
+	time.Sleep(60 * time.Millisecond)
+	_, _ = storage.Get(ctx, "code1", 0)
+	assert.Equal(t, 5, backend.gets)
+}
+
+func TestStorageLinkExpiration(t *testing.T) {
+	storage := NewStorage(memory.NewStorage(), WithTTL(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com", ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+	require.NoError(t, err)
+	uri, err := storage.Get(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com", uri)
+
+	// закешированная ссылка истекает вместе со ссылкой, а не по TTL
+	time.Sleep(60 * time.Millisecond)
+	_, err = storage.Get(ctx, "code1", 0)
+	assert.ErrorIs(t, err, models.ErrExpired)
+}
+
+func TestLRUVersions(t *testing.T) {
+	c := newLRU(10)
+	now := time.Now()
+
+	// удаление другого кода не мешает закешировать ответ
+	version := c.version("code1")
+	c.remove("code2")
+	c.put(entry{code: "code1", uri: "https://site.com", expiresAt: now.Add(time.Hour)}, version)
+	_, ok := c.get("code1", now)
+	assert.True(t, ok)
+
+	// ответ, прочитанный до удаления своего кода, устарел
+	version = c.version("code3")
+	c.remove("code3")
+	c.put(entry{code: "code3", uri: "https://ya.ru", expiresAt: now.Add(time.Hour)}, version)
+	_, ok = c.get("code3", now)
+	assert.False(t, ok)
+
+	// завершенные запросы не оставляют версий
+	c.version("code4")
+	c.release("code4")
+	assert.Empty(t, c.lookups)
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS urls (
+    code       varchar not null constraint urls_pk unique,
+    uri        varchar not null constraint urls_pk2 unique,
+    user_id    integer default 0 not null,
+    is_deleted boolean default false not null
+);
@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz default now() not null;
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id, created_at);
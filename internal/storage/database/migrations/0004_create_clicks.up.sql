@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS clicks (
+    id bigserial constraint clicks_pk primary key,
+    code varchar not null,
+    clicked_at timestamptz not null,
+    referrer varchar not null default '',
+    user_agent varchar not null default '',
+    ip varchar not null default ''
+);
+CREATE INDEX IF NOT EXISTS clicks_code_idx ON clicks (code, clicked_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_uri_trgm_idx;
+DROP INDEX IF EXISTS urls_code_trgm_idx;
@@ -0,0 +1,18 @@
+-- pg_trgm может установить только суперпользователь или владелец базы, поэтому его стоит установить заранее
+-- (CREATE EXTENSION pg_trgm). Без расширения миграция не создает индексы, и поиск работает тем же запросом ILIKE
+-- по ссылкам пользователя без них; после установки расширения индексы можно создать командами ниже.
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+EXCEPTION WHEN insufficient_privilege OR undefined_file THEN
+    RAISE NOTICE 'pg_trgm is not available, the search works without the trigram indexes';
+END
+$$;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm') THEN
+        CREATE INDEX IF NOT EXISTS urls_code_trgm_idx ON urls USING gin (code gin_trgm_ops);
+        CREATE INDEX IF NOT EXISTS urls_uri_trgm_idx ON urls USING gin (uri gin_trgm_ops);
+    END IF;
+END
+$$;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_tags_idx;
+ALTER TABLE urls DROP COLUMN IF EXISTS tags;
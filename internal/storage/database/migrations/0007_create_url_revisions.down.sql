@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS url_revisions;
+DROP INDEX IF EXISTS urls_uri_idx;
+DELETE FROM urls u USING urls o
+WHERE u.uri = o.uri AND u.code <> o.code
+  AND (o.revision, o.created_at, o.code) < (u.revision, u.created_at, u.code);
+ALTER TABLE urls DROP COLUMN IF EXISTS revision;
+ALTER TABLE urls ADD CONSTRAINT urls_pk2 UNIQUE (uri);
@@ -0,0 +1,10 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS revision integer default 1 not null;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_pk2;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_uri_idx ON urls (uri) WHERE revision = 1;
+CREATE TABLE IF NOT EXISTS url_revisions (
+    code       varchar not null,
+    revision   integer not null,
+    uri        varchar not null,
+    created_at timestamptz default now() not null,
+    constraint url_revisions_pk primary key (code, revision)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users (
+    id integer generated by default as identity (start with 1000) constraint users_pk primary key,
+    login varchar not null constraint users_login_key unique,
+    password_hash varchar not null,
+    created_at timestamptz not null default now()
+);
@@ -0,0 +1,5 @@
+DELETE FROM users WHERE login IS NULL;
+DROP INDEX IF EXISTS users_uuid_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS uuid;
+ALTER TABLE users ALTER COLUMN login SET NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE users ALTER COLUMN login DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS uuid uuid;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+CREATE UNIQUE INDEX IF NOT EXISTS users_uuid_idx ON users (uuid);
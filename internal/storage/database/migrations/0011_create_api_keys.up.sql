@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id         varchar constraint api_keys_pk primary key,
+    user_id    integer not null,
+    name       varchar not null default '',
+    scopes     text[] not null,
+    key_hash   varchar not null constraint api_keys_hash_key unique,
+    created_at timestamptz default now() not null
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS users_anonymous_expires_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS users_anonymous_expires_idx ON users (expires_at) WHERE login IS NULL;
@@ -5,9 +5,10 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,125 +25,247 @@ type Storage struct {
 
 // NewStorage creates a new instance of Storage initialized with a PostgreSQL database connection.
 // It takes a DSN (Data Source Name) string as a parameter and returns a pointer to a Storage instance and an error.
+// All pending migrations are applied before the storage is returned.
 func NewStorage(dsn string) (*Storage, error) {
-	db, err := sql.Open("pgx", dsn)
+	db, err := Open(dsn)
 	s := Storage{db: db}
 
 	if err != nil {
 		return &s, err
 	}
 
-	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS urls (" +
-		"code varchar not null constraint urls_pk unique," +
-		"uri varchar not null constraint urls_pk2 unique," +
-		"user_id integer default 0 not null," +
-		"is_deleted boolean default false not null" +
-		")")
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return &s, err
+	}
+	_, err = migrator.Up(context.Background())
 
 	return &s, err
 }
 
+// Open opens a PostgreSQL database connection using the given DSN.
+func Open(dsn string) (*sql.DB, error) {
+	return sql.Open("pgx", dsn)
+}
+
 // Get retrieves the original URI associated with the given code and user ID.
 // It takes a context, a code string, and a userID int as parameters.
 // It returns the URI string and an error.
-// The code queries the database to fetch the URI and is_deleted flag for the given code.
-// If the row scan fails, it returns an error.
-// If the is_deleted flag is true, it returns models.ErrRowDeleted.
+// The code queries the database to fetch the URI, is_deleted flag and expiration time for the given code.
+// If there is no such code, it returns models.ErrNotFound, if the row scan fails, it returns an error.
+// If the is_deleted flag is true, it returns models.ErrDeleted.
+// If the expiration time has passed, it returns models.ErrExpired.
 // Otherwise, it returns the URI string and nil error.
 func (s *Storage) Get(ctx context.Context, code string, userID int) (string, error) {
-	//defer s.db.Close()
+	link, err := s.GetLink(ctx, code)
+	return link.URI, err
+}
+
+// GetLink returns the link with the given code (without the tags) with the same errors as Get.
+func (s *Storage) GetLink(ctx context.Context, code string) (models.Link, error) {
 	row := s.db.QueryRowContext(
 		ctx,
-		"SELECT uri, is_deleted FROM urls WHERE code = $1",
+		"SELECT uri, user_id, is_deleted, expires_at FROM urls WHERE code = $1",
 		code,
 	)
 
-	var uri string
+	link := models.Link{Code: code}
 	var isDeleted bool
-	if err := row.Scan(&uri, &isDeleted); err != nil {
-		return "", err
+	var expiresAt sql.NullTime
+	if err := row.Scan(&link.URI, &link.UserID, &isDeleted, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Link{}, models.ErrNotFound
+		}
+		return models.Link{}, err
 	}
 
 	if isDeleted {
-		return "", models.ErrRowDeleted
+		return models.Link{}, models.ErrDeleted
+	}
+	if expiresAt.Valid {
+		if models.Expired(expiresAt.Time, time.Now()) {
+			return models.Link{}, models.ErrExpired
+		}
+		link.ExpiresAt = expiresAt.Time
 	}
 
-	return uri, nil
+	return link, nil
 }
 
-// Set adds a new URL to the storage with a generated code.
+// Set adds a new URL to the storage under the code of the link.
 //
-// It takes a context, a value string, and a userID int as parameters.
-// It returns the generated code string and an error.
+// It takes a context and a link (code, URI, user ID and expiration time) as parameters.
+// It returns the code string and an error.
 //
-// The method generates a new code using the `generateKey` function.
-// It then attempts to insert the code, URI, and user ID into the `urls` table.
+// The method attempts to insert the code, URI, user ID and expiration time into the `urls` table.
 // If the insertion fails, it checks if the error is a unique violation error.
-// If it is, it queries the `urls` table to find the existing code for the given URI.
+// If the code is taken, it returns models.ErrCodeTaken, so that the caller can try another code.
+// If the URI is taken, it queries the `urls` table to find the existing code for the given URI
+// (only the links with the first revision are unique by URI, see the migration 0007).
 // If the query and scan fail, it returns an empty string and the scan error.
-// Otherwise, it returns the existing code and the original error.
+// Otherwise, it returns the existing code and models.ErrURIExists.
 //
-// If the insertion is successful, it returns the generated code and nil error.
+// If the insertion is successful, it returns the code and nil error.
 //
 // Example usage:
 //
-//	value := "http://example.com"
-//	userID := 123
-//	code, err := storage.Set(ctx, value, userID)
+//	link := models.Link{Code: "abc", URI: "http://example.com", UserID: 123}
+//	code, err := storage.Set(ctx, link)
 //	if err != nil {
 //	  log.Fatal(err)
 //	}
-//	fmt.Println("Generated code:", code)
+//	fmt.Println("Stored code:", code)
 //
 // Note: The `Storage` type must have a field `db` of type `*sql.DB`.
-// The `generateKey` function must be defined in the same package as the `Set` method.
-func (s *Storage) Set(ctx context.Context, value string, userID int) (string, error) {
+func (s *Storage) Set(ctx context.Context, link models.Link) (string, error) {
 	//defer s.db.Close()
-	key := generateKey()
-
 	_, err := s.db.ExecContext(
 		ctx,
-		"INSERT INTO urls (code, uri, user_id) VALUES($1,$2,$3)",
-		key, value, userID,
+		"INSERT INTO urls (code, uri, user_id, expires_at, tags) VALUES($1,$2,$3,$4,$5)",
+		link.Code, link.URI, link.UserID, nullTime(link.ExpiresAt), tagsArray(link.Tags),
 	)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			row := s.db.QueryRowContext(ctx, "SELECT code FROM urls WHERE uri = $1", value)
-			if errScan := row.Scan(&key); errScan != nil {
+			if pgErr.ConstraintName == "urls_pk" {
+				return "", models.ErrCodeTaken
+			}
+			var code string
+			row := s.db.QueryRowContext(ctx, "SELECT code FROM urls WHERE uri = $1 AND revision = 1", link.URI)
+			if errScan := row.Scan(&code); errScan != nil {
 				return "", errScan
 			}
-			return key, err
+			return code, models.ErrURIExists
 		}
 		return "", err
 	}
 
-	return key, nil
+	return link.Code, nil
+}
+
+// batchChunkSize is the number of rows inserted by a single statement of SetBatch and SaveClicks.
+// Every row takes at most 5 parameters, while PostgreSQL allows at most 65535 parameters per statement.
+const batchChunkSize = 1000
+
+// SetBatch adds all the given links to the storage in a single transaction.
+//
+// The links are inserted by multi-row INSERT statements (batchChunkSize rows each),
+// the rows which violate a unique constraint are skipped by ON CONFLICT DO NOTHING
+// and detected by the missing codes in the RETURNING clause.
+// If a row is skipped, the transaction is rolled back and *models.BatchError with the index
+// of the link is returned: models.ErrURIExists along with the existing code if the URI is taken,
+// models.ErrCodeTaken otherwise.
+// If the insertion is successful, it returns the codes in the order of the links.
+func (s *Storage) SetBatch(ctx context.Context, links []models.Link) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(links); start += batchChunkSize {
+		chunk := links[start:min(start+batchChunkSize, len(links))]
+
+		values := make([]string, 0, len(chunk))
+		args := make([]any, 0, 5*len(chunk))
+		for i, link := range chunk {
+			base := i * 5
+			values = append(values, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d)", base+1, base+2, base+3, base+4, base+5))
+			args = append(args, link.Code, link.URI, link.UserID, nullTime(link.ExpiresAt), tagsArray(link.Tags))
+		}
+		query := "INSERT INTO urls (code, uri, user_id, expires_at, tags) VALUES " + strings.Join(values, ",") +
+			" ON CONFLICT DO NOTHING RETURNING code"
+
+		inserted, err := queryCodes(ctx, tx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		if len(inserted) == len(chunk) {
+			continue
+		}
+		for i, link := range chunk {
+			if _, ok := inserted[link.Code]; !ok {
+				return nil, conflict(ctx, tx, links, start+i)
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, len(links))
+	for _, link := range links {
+		codes = append(codes, link.Code)
+	}
+	return codes, nil
+}
+
+// queryCodes runs the query returning codes within the transaction.
+func queryCodes(ctx context.Context, tx *sql.Tx, query string, args ...any) (map[string]struct{}, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make(map[string]struct{})
+	for rows.Next() {
+		var code string
+		if err = rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes[code] = struct{}{}
+	}
+
+	return codes, rows.Err()
 }
 
-// GetByUser retrieves all URLs associated with the given user ID.
-// It takes a context and a userID int as parameters.
-// It returns a slice of models.GetByUserResponse and an error.
-// The code queries the database to fetch the code and URI for URLs associated with the given user ID.
-// It scans the retrieved rows into models.GetByUserResponse struct and appends them to the response slice.
-// If the row scan fails, it returns an error.
-// It then checks for any errors during the iteration of the rows.
-// If an error occurs, it returns the error.
-// Otherwise, it returns the response slice and nil error.
-func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUserResponse, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT code, uri FROM urls WHERE user_id = $1", userID)
+// conflict finds out why the link with the given index was not inserted.
+// The existing code is reported only if it is stored outside the batch,
+// since the rows of the batch are rolled back.
+func conflict(ctx context.Context, tx *sql.Tx, links []models.Link, index int) error {
+	var code string
+	row := tx.QueryRowContext(ctx, "SELECT code FROM urls WHERE uri = $1 AND revision = 1", links[index].URI)
+	err := row.Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.BatchError{Index: index, Err: models.ErrCodeTaken}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links[:index] {
+		if link.Code == code {
+			return &models.BatchError{Index: index, Err: models.ErrURIExists}
+		}
+	}
+	return &models.BatchError{Index: index, Code: code, Err: models.ErrURIExists}
+}
+
+// GetByUser retrieves the URLs associated with the given user ID, including the soft deleted ones.
+// It takes a context, a userID int and a query (see models.HistoryQuery) as parameters.
+// It returns a slice of models.UserLink and an error.
+// The SQL statement is built by historyQuery: the filters, the cursor, the order and the limit
+// are applied by the database, the number of clicks is counted from the `clicks` table.
+// It scans the retrieved rows into models.UserLink structs and appends them to the response slice.
+// If the query or the row scan fails, it returns an error.
+func (s *Storage) GetByUser(ctx context.Context, userID int, query models.HistoryQuery) ([]models.UserLink, error) {
+	statement, args := historyQuery(userID, query)
+	rows, err := s.db.QueryContext(ctx, statement, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	response := make([]models.GetByUserResponse, 0)
+	response := make([]models.UserLink, 0)
 
 	for rows.Next() {
-		var data models.GetByUserResponse
-		if err = rows.Scan(&data.ShortURL, &data.OriginalURL); err != nil {
+		link, err := scanUserLink(rows)
+		if err != nil {
 			return nil, err
 		}
-		response = append(response, data)
+		response = append(response, link)
 	}
 
 	err = rows.Err()
@@ -153,6 +276,294 @@ func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUser
 	return response, nil
 }
 
+// scanUserLink scans a row of GetByUser or Search into models.UserLink.
+// The tags are selected as a JSON array, since database/sql cannot scan PostgreSQL arrays.
+func scanUserLink(rows *sql.Rows) (models.UserLink, error) {
+	var link models.UserLink
+	var tags []byte
+	if err := rows.Scan(&link.Code, &link.URI, &link.IsDeleted, &link.CreatedAt, &link.Clicks, &tags); err != nil {
+		return models.UserLink{}, err
+	}
+	if err := json.Unmarshal(tags, &link.Tags); err != nil {
+		return models.UserLink{}, err
+	}
+	return link, nil
+}
+
+// historyQuery builds the SQL statement and its arguments for GetByUser.
+// The domain of a URI is extracted by a regular expression (the host without the user info and the port).
+// The cursor is compared as a row value, so the index on (user_id, created_at) can be used.
+func historyQuery(userID int, query models.HistoryQuery) (string, []any) {
+	args := []any{userID}
+	param := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conditions := []string{"TRUE"}
+	if query.Deleted != nil {
+		conditions = append(conditions, "is_deleted = "+param(*query.Deleted))
+	}
+	if query.Domain != "" {
+		domain := param(strings.ToLower(query.Domain))
+		conditions = append(conditions, "(domain = "+domain+" OR domain LIKE '%.' || "+domain+")")
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "tags @> ARRAY["+param(query.Tag)+"]::text[]")
+	}
+
+	keys := "created_at, code"
+	if query.SortBy == models.HistorySortClicks {
+		keys = "clicks, created_at, code"
+	}
+	if query.After != nil {
+		cursor := param(query.After.CreatedAt) + ", " + param(query.After.Code)
+		if query.SortBy == models.HistorySortClicks {
+			cursor = param(query.After.Clicks) + ", " + cursor
+		}
+		operator := ">"
+		if query.Desc {
+			operator = "<"
+		}
+		conditions = append(conditions, "("+keys+") "+operator+" ("+cursor+")")
+	}
+
+	order := keys
+	if query.Desc {
+		order = strings.ReplaceAll(keys, ",", " DESC,") + " DESC"
+	}
+
+	statement := "SELECT code, uri, is_deleted, created_at, clicks, to_json(tags) FROM (" +
+		"SELECT code, uri, is_deleted, created_at, tags, " +
+		"(SELECT COUNT(*) FROM clicks WHERE clicks.code = urls.code) AS clicks, " +
+		"lower(substring(uri from '^[^:]+://(?:[^/?#@]*@)?([^/?#:]+)')) AS domain " +
+		"FROM urls WHERE user_id = $1) AS links " +
+		"WHERE " + strings.Join(conditions, " AND ") + " ORDER BY " + order
+	if query.Limit > 0 {
+		statement += " LIMIT " + param(query.Limit)
+	}
+
+	return statement, args
+}
+
+// Search returns up to limit URLs of the user (the newest first), whose code, URI or tag contains the query
+// (case-insensitive). The ILIKE conditions on the code and the URI are served by the trigram indexes
+// of the `urls` table (see the migration 0005), the tags are checked among the URLs of the user;
+// the special characters of the query are escaped. The indexes require
+// the pg_trgm extension, which should be installed by a superuser beforehand; without it the same query
+// reads all the URLs of the user.
+func (s *Storage) Search(ctx context.Context, userID int, query string, limit int) ([]models.UserLink, error) {
+	pattern := "%" + likeReplacer.Replace(query) + "%"
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT code, uri, is_deleted, created_at, "+
+			"(SELECT COUNT(*) FROM clicks WHERE clicks.code = urls.code), to_json(tags) "+
+			"FROM urls WHERE user_id = $1 "+
+			"AND (code ILIKE $2 OR uri ILIKE $2 OR EXISTS (SELECT 1 FROM unnest(tags) AS tag WHERE tag ILIKE $2)) "+
+			"ORDER BY created_at DESC, code DESC LIMIT $3",
+		userID, pattern, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := make([]models.UserLink, 0)
+	for rows.Next() {
+		link, err := scanUserLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, link)
+	}
+
+	return response, rows.Err()
+}
+
+// likeReplacer escapes the special characters of the LIKE patterns.
+var likeReplacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SetTags replaces the tags of the URL with the given code.
+// If the code is not found, it returns models.ErrNotFound.
+// If the URL belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) SetTags(ctx context.Context, code string, userID int, tags []string) error {
+	result, err := s.db.ExecContext(
+		ctx,
+		"UPDATE urls SET tags = $1 WHERE code = $2 AND user_id = $3",
+		tagsArray(tags), code, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// ничего не обновили: ссылки нет или она чужая
+	var owner int
+	row := s.db.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE code = $1", code)
+	if err = row.Scan(&owner); errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return models.ErrForbidden
+}
+
+// GetTagCounts returns the number of URLs of the user per tag, the most frequent tags first.
+// The tag arrays are expanded by unnest, the condition on user_id is served by the index on (user_id, created_at).
+func (s *Storage) GetTagCounts(ctx context.Context, userID int) ([]models.TagCount, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT tag, COUNT(*) AS links FROM urls, unnest(tags) AS tag "+
+			"WHERE user_id = $1 GROUP BY tag ORDER BY links DESC, tag",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]models.TagCount, 0)
+	for rows.Next() {
+		var count models.TagCount
+		if err = rows.Scan(&count.Tag, &count.Links); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// tagsArray returns the tags as a parameter of a text[] column, which is NOT NULL.
+func tagsArray(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// Update sets the new destination of the URL with the given code and returns the new revision.
+// The row of the URL is locked, its revision number is incremented and the new revision is added
+// to the `url_revisions` table. The first change also saves the original destination as the revision 1.
+// If the tags are not nil, they replace the tags of the URL in the same transaction.
+// The URL is no longer unique by URI after the change (see the migration 0007).
+// If the code is not found, it returns models.ErrNotFound.
+// If the URL belongs to another user, it returns models.ErrForbidden.
+// If the URL was soft deleted, it returns models.ErrDeleted.
+func (s *Storage) Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	defer tx.Rollback()
+
+	var current models.Revision
+	var owner int
+	var isDeleted bool
+	row := tx.QueryRowContext(
+		ctx,
+		"SELECT revision, uri, created_at, user_id, is_deleted FROM urls WHERE code = $1 FOR UPDATE",
+		code,
+	)
+	err = row.Scan(&current.Number, &current.URI, &current.CreatedAt, &owner, &isDeleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Revision{}, models.ErrNotFound
+	}
+	if err != nil {
+		return models.Revision{}, err
+	}
+	if owner != userID {
+		return models.Revision{}, models.ErrForbidden
+	}
+	if isDeleted {
+		return models.Revision{}, models.ErrDeleted
+	}
+
+	// исходный адрес сохраняем как первую ревизию при первом изменении
+	if current.Number == 1 {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO url_revisions (code, revision, uri, created_at) VALUES($1,1,$2,$3) ON CONFLICT DO NOTHING",
+			code, current.URI, current.CreatedAt,
+		)
+		if err != nil {
+			return models.Revision{}, err
+		}
+	}
+
+	revision := models.Revision{Number: current.Number + 1, URI: uri}
+	row = tx.QueryRowContext(
+		ctx,
+		"INSERT INTO url_revisions (code, revision, uri) VALUES($1,$2,$3) RETURNING created_at",
+		code, revision.Number, uri,
+	)
+	if err = row.Scan(&revision.CreatedAt); err != nil {
+		return models.Revision{}, err
+	}
+	if tags == nil {
+		_, err = tx.ExecContext(ctx, "UPDATE urls SET uri = $1, revision = $2 WHERE code = $3", uri, revision.Number, code)
+	} else {
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE urls SET uri = $1, revision = $2, tags = $3 WHERE code = $4",
+			uri, revision.Number, tagsArray(*tags), code,
+		)
+	}
+	if err != nil {
+		return models.Revision{}, err
+	}
+
+	return revision, tx.Commit()
+}
+
+// GetRevisions returns the revisions of the URL with the given code, the oldest first.
+// The URL which has never been changed has no rows in the `url_revisions` table,
+// so its only revision is made of the columns of the `urls` table.
+// If the code is not found, it returns models.ErrNotFound.
+// If the URL belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) GetRevisions(ctx context.Context, code string, userID int) ([]models.Revision, error) {
+	var current models.Revision
+	var owner int
+	row := s.db.QueryRowContext(ctx, "SELECT revision, uri, created_at, user_id FROM urls WHERE code = $1", code)
+	err := row.Scan(&current.Number, &current.URI, &current.CreatedAt, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner != userID {
+		return nil, models.ErrForbidden
+	}
+	if current.Number == 1 {
+		return []models.Revision{current}, nil
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT revision, uri, created_at FROM url_revisions WHERE code = $1 ORDER BY revision",
+		code,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.Revision, 0, current.Number)
+	for rows.Next() {
+		var revision models.Revision
+		if err = rows.Scan(&revision.Number, &revision.URI, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
 // SoftDelete marks the URLs associated with the given messages as deleted.
 // It takes a context and a slice of models.RmvUrlsMsg as parameters.
 // It returns an error.
@@ -161,6 +572,7 @@ func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUser
 // y is derived from the message's index plus one.
 // It appends these params to a values slice and the message's code and userID to an args slice.
 // It then constructs the UPDATE query with the WHERE clause joined by OR.
+// The time of the deletion is saved for Restore, the URLs which are already deleted keep it.
 // Finally, it executes the query and returns any resulting error.
 func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error {
 	var values []string
@@ -173,12 +585,365 @@ func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg)
 		args = append(args, msg.Code, msg.UserID)
 	}
 
-	query := "UPDATE urls SET is_deleted = true WHERE " + strings.Join(values, " OR ") + ";"
+	query := "UPDATE urls SET is_deleted = true, deleted_at = now() WHERE is_deleted = false AND (" +
+		strings.Join(values, " OR ") + ");"
 	_, err := s.db.ExecContext(ctx, query, args...)
 
 	return err
 }
 
+// Restore clears the deleted flag of the URLs associated with the given messages, which were deleted
+// at or after deletedSince. The WHERE clause is built like in SoftDelete.
+// The URLs deleted before the migration 0008 have no time of the deletion and cannot be restored.
+// It returns the codes of the restored URLs.
+func (s *Storage) Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error) {
+	if len(messages) == 0 {
+		return []string{}, nil
+	}
+
+	values := make([]string, 0, len(messages))
+	args := []any{deletedSince}
+	for i, msg := range messages {
+		base := i*2 + 1
+		values = append(values, fmt.Sprintf("(code = $%d AND user_id = $%d)", base+1, base+2))
+		args = append(args, msg.Code, msg.UserID)
+	}
+
+	query := "UPDATE urls SET is_deleted = false, deleted_at = NULL WHERE is_deleted AND deleted_at >= $1 AND (" +
+		strings.Join(values, " OR ") + ") RETURNING code"
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	restored := make([]string, 0, len(messages))
+	for rows.Next() {
+		var code string
+		if err = rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		restored = append(restored, code)
+	}
+
+	return restored, rows.Err()
+}
+
+// SaveClicks stores the click events in the `clicks` table.
+// The events are inserted by multi-row INSERT statements (batchChunkSize rows each) in a single transaction.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(clicks); start += batchChunkSize {
+		chunk := clicks[start:min(start+batchChunkSize, len(clicks))]
+
+		values := make([]string, 0, len(chunk))
+		args := make([]any, 0, 5*len(chunk))
+		for i, click := range chunk {
+			base := i * 5
+			values = append(values, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d)", base+1, base+2, base+3, base+4, base+5))
+			args = append(args, click.Code, click.Time, click.Referrer, click.UserAgent, click.IP)
+		}
+		query := "INSERT INTO clicks (code, clicked_at, referrer, user_agent, ip) VALUES " + strings.Join(values, ",")
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetLinkStats returns the statistics of the clicks of the link with the given code in the time range [from, to)
+// with up to top most frequent referrers and user agents.
+// It first reads the owner of the link from the `urls` table: if there is no such code,
+// it returns models.ErrNotFound, if the link belongs to another user, it returns models.ErrForbidden.
+// Then the events of the `clicks` table are aggregated by the database, so only the counts are read.
+func (s *Storage) GetLinkStats(ctx context.Context, code string, userID int, from, to time.Time, top int) (models.LinkStats, error) {
+	var ownerID int
+	row := s.db.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE code = $1", code)
+	if err := row.Scan(&ownerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LinkStats{}, models.ErrNotFound
+		}
+		return models.LinkStats{}, err
+	}
+	if ownerID != userID {
+		return models.LinkStats{}, models.ErrForbidden
+	}
+
+	stats := models.LinkStats{Code: code, From: from, To: to}
+	row = s.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), COUNT(DISTINCT (ip, user_agent)) FROM clicks "+
+			"WHERE code = $1 AND clicked_at >= $2 AND clicked_at < $3",
+		code, from, to,
+	)
+	if err := row.Scan(&stats.Clicks, &stats.Visitors); err != nil {
+		return models.LinkStats{}, err
+	}
+
+	var err error
+	if stats.Daily, err = s.clickCounts(ctx, "day", code, from, to); err != nil {
+		return models.LinkStats{}, err
+	}
+	if stats.Hourly, err = s.clickCounts(ctx, "hour", code, from, to); err != nil {
+		return models.LinkStats{}, err
+	}
+	// прямые переходы и клиенты без заголовка в топ не попадают
+	if stats.TopReferrers, err = s.topValues(ctx, "referrer", code, from, to, top); err != nil {
+		return models.LinkStats{}, err
+	}
+	if stats.TopUserAgents, err = s.topValues(ctx, "user_agent", code, from, to, top); err != nil {
+		return models.LinkStats{}, err
+	}
+
+	return stats, nil
+}
+
+// clickCounts returns the numbers of clicks of the link in the time range [from, to) per period
+// ("day" or "hour" in UTC), sorted by time.
+func (s *Storage) clickCounts(ctx context.Context, period string, code string, from, to time.Time) ([]models.ClickCount, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT date_trunc($1, clicked_at AT TIME ZONE 'UTC') AS period, COUNT(*) FROM clicks "+
+			"WHERE code = $2 AND clicked_at >= $3 AND clicked_at < $4 GROUP BY period ORDER BY period",
+		period, code, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]models.ClickCount, 0)
+	for rows.Next() {
+		var count models.ClickCount
+		if err = rows.Scan(&count.Time, &count.Clicks); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// topValues returns up to top most frequent non-empty values of the column ("referrer" or "user_agent")
+// of the clicks of the link in the time range [from, to). The values with equal counts are sorted by value
+// byte by byte, as the other storages do.
+func (s *Storage) topValues(ctx context.Context, column string, code string, from, to time.Time, top int) ([]models.TopValue, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT "+column+", COUNT(*) AS clicks FROM clicks "+
+			"WHERE code = $1 AND clicked_at >= $2 AND clicked_at < $3 AND "+column+" <> '' "+
+			"GROUP BY "+column+" ORDER BY clicks DESC, "+column+` COLLATE "C" LIMIT $4`,
+		code, from, to, top,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]models.TopValue, 0, top)
+	for rows.Next() {
+		var value models.TopValue
+		if err = rows.Scan(&value.Value, &value.Clicks); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// CreateUser registers the user with the given login and password hash and returns the ID of the user
+// given by the identity column of the `users` table, which starts from models.FirstUserID (see the migration 0009).
+// If the login is already registered, it returns models.ErrLoginTaken.
+func (s *Storage) CreateUser(ctx context.Context, login string, passwordHash string) (int, error) {
+	var userID int
+	row := s.db.QueryRowContext(
+		ctx,
+		"INSERT INTO users (login, password_hash) VALUES($1,$2) RETURNING id",
+		login, passwordHash,
+	)
+	if err := row.Scan(&userID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return 0, models.ErrLoginTaken
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// GetUser returns the registered user with the given login.
+// If the login is not registered, it returns models.ErrNotFound.
+func (s *Storage) GetUser(ctx context.Context, login string) (models.User, error) {
+	user := models.User{Login: login}
+	row := s.db.QueryRowContext(ctx, "SELECT id, password_hash, created_at FROM users WHERE login = $1", login)
+	if err := row.Scan(&user.ID, &user.PasswordHash, &user.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, models.ErrNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// CreateAnonymousUser stores the anonymous user with the given UUID, which is valid until expiresAt,
+// and returns the ID of the user. An anonymous user is a row of the `users` table without a login.
+func (s *Storage) CreateAnonymousUser(ctx context.Context, uuid string, expiresAt time.Time) (int, error) {
+	var userID int
+	row := s.db.QueryRowContext(
+		ctx,
+		"INSERT INTO users (uuid, password_hash, expires_at) VALUES($1,'',$2) RETURNING id",
+		uuid, expiresAt,
+	)
+	if err := row.Scan(&userID); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// ClaimLinks gives all the URLs of the anonymous user to the user and removes the anonymous user
+// in a single transaction. It returns the number of the given URLs.
+// If there is no such anonymous user or it is expired, it returns models.ErrNotFound.
+func (s *Storage) ClaimLinks(ctx context.Context, anonymousID int, userID int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		"DELETE FROM users WHERE id = $1 AND login IS NULL AND expires_at > now()",
+		anonymousID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, errRows := result.RowsAffected(); errRows != nil || n == 0 {
+		return 0, models.ErrNotFound
+	}
+
+	result, err = tx.ExecContext(ctx, "UPDATE urls SET user_id = $1 WHERE user_id = $2", userID, anonymousID)
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(claimed), tx.Commit()
+}
+
+// PurgeAnonymousUsers removes the anonymous users which are expired at the moment now
+// and returns the number of the removed users. The URLs of the removed users are kept.
+func (s *Storage) PurgeAnonymousUsers(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE login IS NULL AND expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), nil
+}
+
+// CreateAPIKey stores the API key in the `api_keys` table.
+// If the ID or the hash of the key is already taken, it returns models.ErrConflict.
+func (s *Storage) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"INSERT INTO api_keys (id, user_id, name, scopes, key_hash, created_at) VALUES($1,$2,$3,$4,$5,$6)",
+		key.ID, key.UserID, key.Name, tagsArray(key.Scopes), key.Hash, key.CreatedAt,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return models.ErrConflict
+	}
+	return err
+}
+
+// GetAPIKeys returns the API keys of the user, the oldest first.
+func (s *Storage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT id, user_id, name, to_json(scopes), key_hash, created_at FROM api_keys "+
+			"WHERE user_id = $1 ORDER BY created_at, id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByHash returns the API key with the given hash.
+// If there is no such key, it returns models.ErrNotFound.
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, name, to_json(scopes), key_hash, created_at FROM api_keys WHERE key_hash = $1",
+		hash,
+	)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, models.ErrNotFound
+	}
+	return key, err
+}
+
+// scanAPIKey scans a row of the `api_keys` table into models.APIKey.
+// The scopes are selected as a JSON array like the tags of the URLs (see scanUserLink).
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes []byte
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &scopes, &key.Hash, &key.CreatedAt); err != nil {
+		return models.APIKey{}, err
+	}
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+// DeleteAPIKey removes the API key of the user from the `api_keys` table.
+// If nothing is deleted, it looks for the key to tell an unknown key from a key of another user:
+// it returns models.ErrNotFound or models.ErrForbidden respectively.
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string, userID int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var owner int
+	row := s.db.QueryRowContext(ctx, "SELECT user_id FROM api_keys WHERE id = $1", id)
+	if err = row.Scan(&owner); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNotFound
+		}
+		return err
+	}
+	return models.ErrForbidden
+}
+
 // HealthCheck performs a health check by pinging the underlying database.
 // It takes a context as a parameter.
 // It returns an error.
@@ -190,39 +955,73 @@ func (s *Storage) HealthCheck(ctx context.Context) error {
 }
 
 // GetStats retrieves the current statistics of the storage.
-func (s *Storage) GetStats(ctx context.Context) (int, int, error) {
-	row := s.db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT user_id) FROM urls")
-	var users int
-	if err := row.Scan(&users); err != nil {
-		return 0, 0, err
+// The counters, the links created per day (in UTC) and the top users are aggregated by the database.
+// The details contain the statistics of the connection pool.
+func (s *Storage) GetStats(ctx context.Context, since time.Time, top int) (models.StorageStats, error) {
+	var stats models.StorageStats
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), COUNT(*) FILTER (WHERE is_deleted), COUNT(DISTINCT user_id) FROM urls",
+	)
+	if err := row.Scan(&stats.Urls, &stats.Deleted, &stats.Users); err != nil {
+		return models.StorageStats{}, err
 	}
+	stats.Live = stats.Urls - stats.Deleted
 
-	row = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM urls")
-	var urls int
-	if err := row.Scan(&urls); err != nil {
-		return 0, 0, err
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, COUNT(*) FROM urls "+
+			"WHERE created_at >= $1 GROUP BY day ORDER BY day",
+		since,
+	)
+	if err != nil {
+		return models.StorageStats{}, err
+	}
+	defer rows.Close()
+	stats.CreatedPerDay = make([]models.DayCount, 0)
+	for rows.Next() {
+		var count models.DayCount
+		if err = rows.Scan(&count.Day, &count.Links); err != nil {
+			return models.StorageStats{}, err
+		}
+		stats.CreatedPerDay = append(stats.CreatedPerDay, count)
+	}
+	if err = rows.Err(); err != nil {
+		return models.StorageStats{}, err
 	}
 
-	return urls, users, nil
-}
-
-// generateKey generates a random key with the specified length.
-// It uses the characters in the charset string and a random number generator
-// to create the key.
-//
-// The function returns the generated key as a string.
-// Example:
-//
-//	key := generateKey()
-func generateKey() string {
-	var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	var length = 8
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rows, err = s.db.QueryContext(
+		ctx,
+		"SELECT user_id, COUNT(*) AS links FROM urls GROUP BY user_id ORDER BY links DESC, user_id LIMIT $1",
+		top,
+	)
+	if err != nil {
+		return models.StorageStats{}, err
+	}
+	defer rows.Close()
+	stats.TopUsers = make([]models.UserCount, 0, top)
+	for rows.Next() {
+		var count models.UserCount
+		if err = rows.Scan(&count.UserID, &count.Links); err != nil {
+			return models.StorageStats{}, err
+		}
+		stats.TopUsers = append(stats.TopUsers, count)
+	}
+	if err = rows.Err(); err != nil {
+		return models.StorageStats{}, err
+	}
 
-	key := make([]byte, length)
-	for i := range key {
-		key[i] = charset[seededRand.Intn(len(charset))]
+	pool := s.db.Stats()
+	stats.Backend = "postgres"
+	stats.Details = map[string]string{
+		"open_connections": strconv.Itoa(pool.OpenConnections),
+		"in_use":           strconv.Itoa(pool.InUse),
+		"idle":             strconv.Itoa(pool.Idle),
 	}
+	return stats, nil
+}
 
-	return string(key)
+// nullTime converts the zero time to SQL NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
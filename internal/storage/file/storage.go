@@ -1,93 +1,186 @@
 package file
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
-	"math/rand"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
 )
 
-// Storage represents a map[string]string type, which is used for key-value storage.
-// Get retrieves the value associated with the given code and userID from the storage.
-// It returns the value and an error if the code is not found in the storage.
+// compactMinEvents is the minimal number of journal events which triggers a compaction.
+const compactMinEvents = 1024
+
+// Storage represents a file-backed storage.
+// All links are kept in memory (see memory.Storage), while every change is appended
+// to a journal file as a JSON line. The journal is replayed on start and compacted
+// in the background, so that the file does not grow without bound.
 // Example usage:
 //
 //	value, err := storage.Get(ctx, code, userID)
-type Storage map[string]string
+type Storage struct {
+	*memory.Storage
 
-var filename string
+	path    string
+	mu      sync.Mutex
+	journal *os.File
+	events  int
+	compact chan struct{}
 
-// Get retrieves the value associated with the given code from the Storage instance.
-// It takes a context as an argument, which represents the execution context.
-// The method expects the code of the value to be retrieved and the userID of the user associated with the value.
-// It checks if the code exists in the Storage instance using the code as the key.
-// If the code does not exist, it returns an error with the message "not found".
-// Otherwise, it returns the value and nil.
-//
-// Example usage:
-// value, err := storage.Get(ctx, code, userID)
-//
-//	if err != nil {
-//	    // handle error
-//	}
-//
-// // use value
-func (s *Storage) Get(ctx context.Context, code string, userID int) (string, error) {
-	v, ok := (*s)[code]
-	if !ok {
-		return "", errors.New("not found")
-	}
-	return v, nil
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-// Set adds a new key-value pair to the Storage instance.
+// event represents a single line of the journal.
+// The "create" event stores a link (including its deleted state and its revisions after a compaction),
+// the "delete" event marks the link as deleted (at the time from the deleted_at field, if it is set),
+// the "restore" event clears the deleted flag of the link, the "click" event stores a redirect by the link,
+// the "tags" event replaces the tags of the link, the "update" event sets the new destination
+// of the link at the time from the created_at field (and replaces its tags, if the with_tags flag is set), the "user" event stores the user with the ID
+// from the user_id field (an anonymous user has no login), the "claim" event gives the links
+// of the anonymous user to the user from the claimed_by field, the "purge" event removes the anonymous users
+// expired at the time from the created_at field, the "sequence" event sets the ID of the next user
+// from the user_id field (so the IDs of the removed users are not given again), the "key" event stores
+// the API key and the "revoke" event removes it.
+type event struct {
+	Op        string            `json:"op"`
+	Code      string            `json:"code"`
+	URI       string            `json:"uri,omitempty"`
+	UserID    int               `json:"user_id"`
+	IsDeleted bool              `json:"is_deleted,omitempty"`
+	DeletedAt *time.Time        `json:"deleted_at,omitempty"`
+	CreatedAt *time.Time        `json:"created_at,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	ClickedAt *time.Time        `json:"clicked_at,omitempty"`
+	Referrer  string            `json:"referrer,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	WithTags  bool              `json:"with_tags,omitempty"`
+	Revisions []models.Revision `json:"revisions,omitempty"`
+	Login     string            `json:"login,omitempty"`
+	Password  string            `json:"password_hash,omitempty"`
+	UUID      string            `json:"uuid,omitempty"`
+	ClaimedBy int               `json:"claimed_by,omitempty"`
+	KeyID     string            `json:"key_id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Scopes    []string          `json:"scopes,omitempty"`
+	KeyHash   string            `json:"key_hash,omitempty"`
+}
+
+const (
+	opCreate   = "create"
+	opDelete   = "delete"
+	opRestore  = "restore"
+	opClick    = "click"
+	opTags     = "tags"
+	opUpdate   = "update"
+	opUser     = "user"
+	opClaim    = "claim"
+	opPurge    = "purge"
+	opSequence = "sequence"
+	opKey      = "key"
+	opRevoke   = "revoke"
+)
+
+// Set adds a new link to the Storage instance.
 // It takes a context as an argument, which represents the execution context.
-// The method expects the value to be stored and the userID of the user associated with the value.
-// It generates a unique key using the generateKey function and assigns the value to the Storage instance with the generated key.
-// After setting the value, it calls the saveToFile function to save the updated Storage instance to a file.
-// If an error occurs during the file saving process, it returns an empty string and the error.
-// Otherwise, it returns the generated key and nil.
+// The method expects the link with the code, the value to be stored, the userID of the user associated with the value
+// and the expiration time. It appends the "create" event to the journal.
+// Only after the event is written, the link becomes visible in the storage.
+// If the code is already taken, it returns models.ErrCodeTaken.
+// If an error occurs during the writing, it returns an empty string and the error.
+// Otherwise, it returns the code and nil.
 // Example usage:
 //
-//	key, err := storage.Set(ctx, value, userID)
+//	code, err := storage.Set(ctx, link)
 //	if err != nil {
 //	    // handle error
 //	}
-//	// use key
-func (s *Storage) Set(ctx context.Context, value string, userID int) (string, error) {
-	key := generateKey()
-	(*s)[key] = value
-	if err := saveToFile(s); err != nil {
+//	// use code
+func (s *Storage) Set(ctx context.Context, link models.Link) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Lookup(link.Code); ok {
+		return "", models.ErrCodeTaken
+	}
+
+	r := memory.Record{
+		Code:      link.Code,
+		URI:       link.URI,
+		UserID:    link.UserID,
+		CreatedAt: time.Now(),
+		ExpiresAt: link.ExpiresAt,
+		Tags:      link.Tags,
+	}
+	if err := s.append(createEvent(r)); err != nil {
 		return "", err
 	}
-	return key, nil
+	s.Put(r)
+
+	return r.Code, nil
 }
 
-// GetByUser retrieves the data associated with a specific user from the Storage instance.
-// It takes a context as an argument, which represents the execution context.
-// The method expects the userID of the user whose data needs to be fetched.
-// It returns a slice of GetByUserResponse objects, which contain the short URL and original URL.
-// If the user has no associated data or if an error occurs, it returns an empty slice and an error respectively.
-// Example usage:
-//
-//	data, err := storage.GetByUser(ctx, userID)
-//	if err != nil {
-//	    // handle error
-//	}
-//	// process data
-func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUserResponse, error) {
-	// предстоит переделать хранилище на map[userID][code]value
-	return nil, nil
+// SetBatch adds all the given links to the Storage instance or none of them.
+// The "create" events of the whole batch are appended to the journal with a single write.
+// If a code is already taken (or repeated in the batch), it returns *models.BatchError
+// with the index of the link and models.ErrCodeTaken.
+// Otherwise, it returns the codes in the order of the links.
+func (s *Storage) SetBatch(ctx context.Context, links []models.Link) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(links))
+	for i, link := range links {
+		_, taken := s.Lookup(link.Code)
+		if _, repeated := seen[link.Code]; taken || repeated {
+			return nil, &models.BatchError{Index: i, Err: models.ErrCodeTaken}
+		}
+		seen[link.Code] = struct{}{}
+	}
+
+	now := time.Now()
+	records := make([]memory.Record, 0, len(links))
+	events := make([]event, 0, len(links))
+	for _, link := range links {
+		r := memory.Record{
+			Code:      link.Code,
+			URI:       link.URI,
+			UserID:    link.UserID,
+			CreatedAt: now,
+			ExpiresAt: link.ExpiresAt,
+			Tags:      link.Tags,
+		}
+		records = append(records, r)
+		events = append(events, createEvent(r))
+	}
+	if err := s.append(events...); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, len(records))
+	for _, r := range records {
+		s.Put(r)
+		codes = append(codes, r.Code)
+	}
+
+	return codes, nil
 }
 
 // SoftDelete performs a soft delete operation on the Storage instance.
 // It takes a context as an argument, which represents the execution context.
 // The method expects a slice of messages of type models.RmvUrlsMsg, which contains UserID and Code.
-// This method will be modified to change the underlying storage structure to a map[userID][code]value.
+// Only the links owned by the user from the message are deleted, each deletion is written to the journal.
 // It returns nil if the soft delete operation succeeds, otherwise it returns an error.
 // Example usage:
 //
@@ -97,13 +190,252 @@ func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUser
 //	}
 //	// soft delete operation succeeded
 func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error {
-	// предстоит переделать хранилище на map[userID][code]value
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var deleted []models.RmvUrlsMsg
+	for _, msg := range messages {
+		r, ok := s.Lookup(msg.Code)
+		if !ok || r.UserID != msg.UserID || r.IsDeleted {
+			continue
+		}
+		err := s.append(event{Op: opDelete, Code: msg.Code, UserID: msg.UserID, DeletedAt: &now})
+		if err != nil {
+			s.SoftDeleteAt(deleted, now)
+			return err
+		}
+		deleted = append(deleted, msg)
+	}
+
+	s.SoftDeleteAt(deleted, now)
+	return nil
+}
+
+// Restore clears the deleted flag of the links from the given messages, which belong to the user
+// from the message and were deleted at or after deletedSince (see memory.Storage.Restore).
+// The "restore" events are appended to the journal with a single write.
+// It returns the codes of the restored links.
+func (s *Storage) Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var restored []models.RmvUrlsMsg
+	var events []event
+	for _, msg := range messages {
+		r, ok := s.Lookup(msg.Code)
+		if !ok || r.UserID != msg.UserID || !r.IsDeleted || r.DeletedAt.Before(deletedSince) {
+			continue
+		}
+		restored = append(restored, msg)
+		events = append(events, event{Op: opRestore, Code: msg.Code, UserID: msg.UserID})
+	}
+	if err := s.append(events...); err != nil {
+		return nil, err
+	}
+
+	return s.Storage.Restore(ctx, restored, deletedSince)
+}
+
+// SetTags replaces the tags of the link with the given code and writes the "tags" event to the journal.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) SetTags(ctx context.Context, code string, userID int, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Lookup(code)
+	if !ok {
+		return models.ErrNotFound
+	}
+	if r.UserID != userID {
+		return models.ErrForbidden
+	}
+	if err := s.append(event{Op: opTags, Code: code, UserID: userID, Tags: tags}); err != nil {
+		return err
+	}
+
+	return s.Storage.SetTags(ctx, code, userID, tags)
+}
+
+// Update sets the new destination of the link with the given code and writes the "update" event to the journal.
+// If the tags are not nil, they replace the tags of the link by the same event.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+// If the link was soft deleted, it returns models.ErrDeleted.
+func (s *Storage) Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Lookup(code)
+	if !ok {
+		return models.Revision{}, models.ErrNotFound
+	}
+	if r.UserID != userID {
+		return models.Revision{}, models.ErrForbidden
+	}
+	if r.IsDeleted {
+		return models.Revision{}, models.ErrDeleted
+	}
+	now := time.Now()
+	e := event{Op: opUpdate, Code: code, UserID: userID, URI: uri, CreatedAt: &now}
+	if tags != nil {
+		e.Tags, e.WithTags = *tags, true
+	}
+	if err := s.append(e); err != nil {
+		return models.Revision{}, err
+	}
+
+	return s.Revise(code, userID, uri, tags, now)
+}
+
+// CreateUser registers the user with the given login and password hash and writes the "user" event to the journal.
+// Only after the event is written, the user can log in.
+// If the login is already registered, it returns models.ErrLoginTaken.
+func (s *Storage) CreateUser(ctx context.Context, login string, passwordHash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.GetUser(ctx, login); err == nil {
+		return 0, models.ErrLoginTaken
+	}
+	user := models.User{ID: s.NextUserID(), Login: login, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	if err := s.append(userEvent(user)); err != nil {
+		return 0, err
+	}
+	s.PutUser(user)
+
+	return user.ID, nil
+}
+
+// CreateAnonymousUser stores the anonymous user with the given UUID, which is valid until expiresAt,
+// and writes the "user" event to the journal.
+func (s *Storage) CreateAnonymousUser(ctx context.Context, uuid string, expiresAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := models.User{ID: s.NextUserID(), UUID: uuid, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	if err := s.append(userEvent(user)); err != nil {
+		return 0, err
+	}
+	s.PutUser(user)
+
+	return user.ID, nil
+}
+
+// ClaimLinks gives all the links of the anonymous user to the user and writes the "claim" event to the journal,
+// so the links and the removal of the anonymous user are saved at once.
+// If there is no such anonymous user or it is expired, it returns models.ErrNotFound.
+func (s *Storage) ClaimLinks(ctx context.Context, anonymousID int, userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.AnonymousUser(anonymousID); !ok {
+		return 0, models.ErrNotFound
+	}
+	if err := s.append(event{Op: opClaim, UserID: anonymousID, ClaimedBy: userID}); err != nil {
+		return 0, err
+	}
+
+	return s.Claim(anonymousID, userID), nil
+}
+
+// PurgeAnonymousUsers removes the anonymous users which are expired at the moment now
+// and writes the "purge" event to the journal. It returns the number of the removed users.
+// If no user is expired, the journal is not touched.
+func (s *Storage) PurgeAnonymousUsers(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.HasExpiredAnonymous(now) {
+		return 0, nil
+	}
+	if err := s.append(event{Op: opPurge, CreatedAt: &now}); err != nil {
+		return 0, err
+	}
+
+	return s.Storage.PurgeAnonymousUsers(ctx, now)
+}
+
+// CreateAPIKey stores the API key and writes the "key" event to the journal.
+// If the ID or the hash of the key is already taken, it returns models.ErrConflict.
+func (s *Storage) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.GetAPIKeyByHash(ctx, key.Hash)
+	if _, ok := s.LookupAPIKey(key.ID); ok || err == nil {
+		return models.ErrConflict
+	}
+	if err := s.append(keyEvent(key)); err != nil {
+		return err
+	}
+	s.PutAPIKey(key)
+
 	return nil
 }
 
+// DeleteAPIKey removes the API key and writes the "revoke" event to the journal.
+// If the key is not found, it returns models.ErrNotFound.
+// If the key belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.LookupAPIKey(id)
+	if !ok {
+		return models.ErrNotFound
+	}
+	if key.UserID != userID {
+		return models.ErrForbidden
+	}
+	if err := s.append(event{Op: opRevoke, KeyID: id, UserID: userID}); err != nil {
+		return err
+	}
+
+	return s.Storage.DeleteAPIKey(ctx, id, userID)
+}
+
+// SaveClicks stores the click events of the links.
+// The "click" events are appended to the journal with a single write.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]event, 0, len(clicks))
+	for _, click := range clicks {
+		events = append(events, clickEvent(click))
+	}
+	if err := s.append(events...); err != nil {
+		return err
+	}
+
+	return s.Storage.SaveClicks(ctx, clicks)
+}
+
+// GetStats returns the statistics of the links kept in memory (see memory.Storage.GetStats).
+// The details contain the path of the journal and the number of its events.
+func (s *Storage) GetStats(ctx context.Context, since time.Time, top int) (models.StorageStats, error) {
+	stats, err := s.Storage.GetStats(ctx, since, top)
+	if err != nil {
+		return stats, err
+	}
+
+	s.mu.Lock()
+	events := s.events
+	s.mu.Unlock()
+
+	stats.Backend = "file"
+	stats.Details = map[string]string{
+		"path":           s.path,
+		"journal_events": strconv.Itoa(events),
+	}
+	return stats, nil
+}
+
 // HealthCheck performs a health check on the Storage instance.
 // It takes a context as an argument, which represents the execution context.
-// This method can be extended to include file presence check and other health checks.
+// It checks that the journal file still exists, if the storage is backed by a file.
 // It returns nil if the health check passes, otherwise it returns an error.
 // Example usage:
 //
@@ -113,17 +445,35 @@ func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg)
 //	}
 //	// health check passed
 func (s *Storage) HealthCheck(ctx context.Context) error {
-	// тут можно добавить проверку наличия файла
-	return nil
+	if s.path == "" {
+		return nil
+	}
+	_, err := os.Stat(s.path)
+	return err
 }
 
-// GetStats retrieves the current statistics of the storage.
-func (s *Storage) GetStats(context.Context) (int, int, error) {
-	return len(*s), 0, nil
+// Close stops the background compaction and closes the journal file.
+// It is safe to call Close several times.
+func (s *Storage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Close()
+	s.journal = nil
+
+	return err
 }
 
 // NewStorage creates a new instance of Storage initialized with data from a file.
-// It takes a filename as an argument, which represents the file from which the data will be loaded.
+// It takes a filename as an argument, which represents the journal the data will be loaded from.
+// If the file contains data in the legacy format (a single JSON object of code/URI pairs),
+// it is converted to the journal format.
 // If an error occurs during the loading process, NewStorage returns the error.
 // Otherwise, it returns a pointer to the Storage instance and a nil error.
 // Example usage:
@@ -138,53 +488,421 @@ func (s *Storage) GetStats(context.Context) (int, int, error) {
 //
 //	func NewStorage(fName string) (*Storage, error)
 func NewStorage(fName string) (*Storage, error) {
-	s := make(Storage)
-	filename = fName
-	if err := loadFromFile(&s); err != nil {
-		return &s, err
+	s := &Storage{
+		Storage: memory.NewStorage(),
+		path:    fName,
+		compact: make(chan struct{}, 1),
+		done:    make(chan struct{}),
 	}
-	return &s, nil
+	if fName == "" {
+		return s, nil
+	}
+
+	legacy, err := s.load()
+	if err != nil {
+		return s, err
+	}
+
+	s.journal, err = os.OpenFile(fName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return s, err
+	}
+	if legacy {
+		if err = s.rewrite(); err != nil {
+			return s, err
+		}
+	}
+
+	s.wg.Add(1)
+	go s.compactor()
+
+	return s, nil
 }
 
-func generateKey() string {
-	var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	var length = 8
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+func createEvent(r memory.Record) event {
+	e := event{
+		Op:        opCreate,
+		Code:      r.Code,
+		URI:       r.URI,
+		UserID:    r.UserID,
+		IsDeleted: r.IsDeleted,
+		CreatedAt: &r.CreatedAt,
+		Tags:      r.Tags,
+		Revisions: r.Revisions,
+	}
+	if !r.ExpiresAt.IsZero() {
+		e.ExpiresAt = &r.ExpiresAt
+	}
+	if r.IsDeleted && !r.DeletedAt.IsZero() {
+		e.DeletedAt = &r.DeletedAt
+	}
+	return e
+}
 
-	key := make([]byte, length)
-	for i := range key {
-		key[i] = charset[seededRand.Intn(len(charset))]
+func clickEvent(click models.Click) event {
+	return event{
+		Op:        opClick,
+		Code:      click.Code,
+		ClickedAt: &click.Time,
+		Referrer:  click.Referrer,
+		UserAgent: click.UserAgent,
+		IP:        click.IP,
 	}
+}
 
-	return string(key)
+func userEvent(user models.User) event {
+	e := event{
+		Op:        opUser,
+		UserID:    user.ID,
+		Login:     user.Login,
+		Password:  user.PasswordHash,
+		UUID:      user.UUID,
+		CreatedAt: &user.CreatedAt,
+	}
+	if !user.ExpiresAt.IsZero() {
+		e.ExpiresAt = &user.ExpiresAt
+	}
+	return e
 }
 
-func loadFromFile(s *Storage) error {
-	if filename == "" {
-		return nil
+func keyEvent(key models.APIKey) event {
+	return event{
+		Op:        opKey,
+		KeyID:     key.ID,
+		UserID:    key.UserID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		KeyHash:   key.Hash,
+		CreatedAt: &key.CreatedAt,
 	}
+}
 
-	data, err := os.ReadFile(filename)
+// load replays the journal into the memory storage.
+// It reports whether the file was written in the legacy format.
+// A partially written last line (after a crash) is cut off.
+func (s *Storage) load() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
 	if err != nil {
+		return false, err
+	}
+
+	// старый формат: весь файл - это один объект {"code": "uri"}
+	var legacy map[string]string
+	if err = json.Unmarshal(data, &legacy); err == nil {
+		for code, uri := range legacy {
+			s.Put(memory.Record{Code: code, URI: uri, CreatedAt: time.Now()})
+		}
+		return true, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var e event
+		err = decoder.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, os.Truncate(s.path, decoder.InputOffset())
+		}
+		if err != nil {
+			return false, err
+		}
+		s.apply(e)
+	}
+
+	return false, nil
+}
+
+func (s *Storage) apply(e event) {
+	s.events++
+	switch e.Op {
+	case opCreate:
+		r := memory.Record{
+			Code:      e.Code,
+			URI:       e.URI,
+			UserID:    e.UserID,
+			IsDeleted: e.IsDeleted,
+			Tags:      e.Tags,
+			Revisions: e.Revisions,
+		}
+		if e.CreatedAt != nil {
+			r.CreatedAt = *e.CreatedAt
+		}
+		if e.ExpiresAt != nil {
+			r.ExpiresAt = *e.ExpiresAt
+		}
+		if e.DeletedAt != nil {
+			r.DeletedAt = *e.DeletedAt
+		}
+		s.Put(r)
+	case opDelete:
+		// время удаления старых событий неизвестно, такие ссылки восстановить нельзя
+		var deletedAt time.Time
+		if e.DeletedAt != nil {
+			deletedAt = *e.DeletedAt
+		}
+		s.SoftDeleteAt([]models.RmvUrlsMsg{{UserID: e.UserID, Code: e.Code}}, deletedAt)
+	case opRestore:
+		_, _ = s.Storage.Restore(context.Background(), []models.RmvUrlsMsg{{UserID: e.UserID, Code: e.Code}}, time.Time{})
+	case opClick:
+		click := models.Click{Code: e.Code, Referrer: e.Referrer, UserAgent: e.UserAgent, IP: e.IP}
+		if e.ClickedAt != nil {
+			click.Time = *e.ClickedAt
+		}
+		_ = s.Storage.SaveClicks(context.Background(), []models.Click{click})
+	case opTags:
+		_ = s.Storage.SetTags(context.Background(), e.Code, e.UserID, e.Tags)
+	case opUpdate:
+		if e.CreatedAt != nil {
+			var tags *[]string
+			if e.WithTags {
+				tags = &e.Tags
+			}
+			_, _ = s.Revise(e.Code, e.UserID, e.URI, tags, *e.CreatedAt)
+		}
+	case opUser:
+		user := models.User{ID: e.UserID, Login: e.Login, PasswordHash: e.Password, UUID: e.UUID}
+		if e.CreatedAt != nil {
+			user.CreatedAt = *e.CreatedAt
+		}
+		if e.ExpiresAt != nil {
+			user.ExpiresAt = *e.ExpiresAt
+		}
+		s.PutUser(user)
+	case opClaim:
+		s.Claim(e.UserID, e.ClaimedBy)
+	case opPurge:
+		if e.CreatedAt != nil {
+			_, _ = s.Storage.PurgeAnonymousUsers(context.Background(), *e.CreatedAt)
+		}
+	case opSequence:
+		s.SetNextUserID(e.UserID)
+	case opKey:
+		key := models.APIKey{ID: e.KeyID, UserID: e.UserID, Name: e.Name, Scopes: e.Scopes, Hash: e.KeyHash}
+		if e.CreatedAt != nil {
+			key.CreatedAt = *e.CreatedAt
+		}
+		s.PutAPIKey(key)
+	case opRevoke:
+		_ = s.Storage.DeleteAPIKey(context.Background(), e.KeyID, e.UserID)
+	}
+}
+
+// append writes the events to the journal with a single write. The caller must hold s.mu.
+func (s *Storage) append(events ...event) error {
+	if s.path == "" {
 		return nil
 	}
+	if s.journal == nil {
+		return os.ErrClosed
+	}
+
+	var data []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := s.journal.Write(data); err != nil {
+		return err
+	}
+
+	s.events += len(events)
+	if s.events >= compactMinEvents && s.events >= 2*(s.Len()+s.ClicksLen()+s.UsersLen()) {
+		select {
+		case s.compact <- struct{}{}:
+		default:
+		}
+	}
 
-	if b := len(data); b > 0 {
-		err = json.Unmarshal(data, &s)
+	return nil
+}
+
+func (s *Storage) compactor() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.compact:
+			if err := s.compactJournal(); err != nil {
+				// журнал остается прежним, попробуем в следующий раз
+				continue
+			}
+		}
 	}
+}
+
+// snapshot is the state of the storage written to the journal by a compaction.
+type snapshot struct {
+	nextUserID int
+	users      []models.User
+	keys       []models.APIKey
+	records    []memory.Record
+	clicks     []models.Click
+}
 
+// snapshot returns the current state of the storage. The caller must hold s.mu.
+func (s *Storage) snapshot() snapshot {
+	return snapshot{
+		nextUserID: s.NextUserID(),
+		users:      s.Users(),
+		keys:       s.APIKeys(),
+		records:    s.Records(),
+		clicks:     s.Clicks(),
+	}
+}
+
+// compactJournal replaces the journal with a snapshot of the current state like rewrite,
+// but holds s.mu only to take the snapshot and to replace the journal: the snapshot is written
+// and synced without the lock, so the writers are not blocked by the compaction.
+// The events appended to the journal in the meantime are copied after the snapshot.
+func (s *Storage) compactJournal() error {
+	s.mu.Lock()
+	if s.journal == nil {
+		s.mu.Unlock()
+		return os.ErrClosed
+	}
+	info, err := s.journal.Stat()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	snap := s.snapshot()
+	events := s.events
+	s.mu.Unlock()
+
+	tmp, written, err := s.writeSnapshot(snap)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// снимок сбрасываем на диск без блокировки, под ней остается только хвост журнала
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		tmp.Close()
+		return os.ErrClosed
+	}
+	// события, записанные во время сжатия, переносим в новый журнал
+	if err = copyTail(tmp, s.path, info.Size()); err != nil {
+		tmp.Close()
+		return err
+	}
+	return s.replaceJournal(tmp, written+s.events-events)
+}
+
+// rewrite writes a snapshot of the current state to a temporary file and atomically
+// replaces the journal with it. The caller must hold s.mu (or own s exclusively).
+func (s *Storage) rewrite() error {
+	tmp, written, err := s.writeSnapshot(s.snapshot())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	return s.replaceJournal(tmp, written)
+}
+
+// writeSnapshot writes the snapshot to a new temporary file next to the journal.
+// It returns the open file and the number of the written events. On error the file is removed.
+func (s *Storage) writeSnapshot(snap snapshot) (*os.File, int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	fail := func(err error) (*os.File, int, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+
+	if err = tmp.Chmod(0644); err != nil {
+		return fail(err)
+	}
+
+	events := make([]event, 0, 1+len(snap.users)+len(snap.keys)+len(snap.records)+len(snap.clicks))
+	// удаленные анонимные пользователи не попадают в снимок, поэтому сохраняем следующий ID,
+	// если его нельзя вычислить по оставшимся пользователям
+	next := models.FirstUserID
+	for _, user := range snap.users {
+		next = max(next, user.ID+1)
+	}
+	if snap.nextUserID > next {
+		events = append(events, event{Op: opSequence, UserID: snap.nextUserID})
+	}
+	for _, user := range snap.users {
+		events = append(events, userEvent(user))
+	}
+	for _, key := range snap.keys {
+		events = append(events, keyEvent(key))
+	}
+	for _, r := range snap.records {
+		events = append(events, createEvent(r))
+	}
+	for _, click := range snap.clicks {
+		events = append(events, clickEvent(click))
+	}
+
+	encoder := json.NewEncoder(tmp)
+	for _, e := range events {
+		if err = encoder.Encode(e); err != nil {
+			return fail(err)
+		}
+	}
+
+	return tmp, len(events), nil
+}
+
+// copyTail appends the part of the journal at path starting from offset to the file.
+func copyTail(dst *os.File, path string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err = src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
 	return err
 }
 
-func saveToFile(s *Storage) error {
-	if filename == "" {
-		return nil
+// replaceJournal syncs and closes the temporary file, atomically renames it to the journal
+// and reopens the journal. The caller must hold s.mu (or own s exclusively).
+func (s *Storage) replaceJournal(tmp *os.File, events int) error {
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(s)
+	journal, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
+	_ = s.journal.Close()
+	s.journal = journal
+	s.events = events
 
-	return os.WriteFile(filename, data, 0666)
+	return nil
 }
@@ -1,11 +1,17 @@
 package file
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
 )
 
 func TestStorage(t *testing.T) {
@@ -15,7 +21,7 @@ func TestStorage(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	key, err := storage.Set(ctx, "https://site.com", 0)
+	key, err := storage.Set(ctx, models.Link{Code: "key", URI: "https://site.com"})
 	assert.NotEmpty(t, key)
 	assert.NoError(t, err)
 
@@ -27,12 +33,534 @@ func TestStorage(t *testing.T) {
 	assert.Empty(t, uri)
 	assert.Error(t, err)
 
-	data, err := storage.GetByUser(ctx, 0)
-	assert.Nil(t, data)
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	assert.Empty(t, data)
 	assert.NoError(t, err)
 
-	urls, users, err := storage.GetStats(ctx)
-	assert.Equal(t, urls, 1)
-	assert.Equal(t, users, 0)
+	stats, err := storage.GetStats(ctx, time.Time{}, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Urls)
+	assert.Equal(t, 1, stats.Live)
+	assert.Equal(t, 1, stats.Users)
+	assert.Equal(t, "file", stats.Backend)
+}
+
+func TestStorageReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	code1, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	code2, err := storage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	code4, err := storage.Set(ctx, models.Link{Code: "code4", URI: "https://site.com/expired", UserID: 1, ExpiresAt: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: code1}})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	// имитируем падение процесса посреди записи
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"create","code":"broken","ur`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	_, err = storage.Get(ctx, code1, 1)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+
+	uri, err := storage.Get(ctx, code2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", uri)
+
+	_, err = storage.Get(ctx, code4, 1)
+	assert.ErrorIs(t, err, models.ErrExpired)
+
+	_, err = storage.Get(ctx, "broken", 1)
+	assert.Error(t, err)
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+	assert.Equal(t, code1, data[0].Code)
+	assert.Equal(t, "https://google.com", data[0].URI)
+	assert.Equal(t, code2, data[1].Code)
+	assert.Equal(t, "https://ya.ru", data[1].URI)
+	assert.Equal(t, code4, data[2].Code)
+	assert.Equal(t, "https://site.com/expired", data[2].URI)
+
+	// после обрезки журнал снова пригоден для записи
+	code3, err := storage.Set(ctx, models.Link{Code: "code3", URI: "https://site.com", UserID: 2})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	uri, err = storage.Get(ctx, code3, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://site.com", uri)
+}
+
+func TestStorageLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	err := os.WriteFile(path, []byte(`{"abc":"https://google.com"}`), 0666)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+	code, err := storage.Set(ctx, models.Link{Code: "code", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	uri, err := storage.Get(ctx, "abc", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://google.com", uri)
+
+	uri, err = storage.Get(ctx, code, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", uri)
+}
+
+func TestStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	code, err := storage.Set(ctx, models.Link{Code: "code", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: code}})
+	require.NoError(t, err)
+
+	// повторные события удаления только раздувают журнал
+	for i := 0; i < compactMinEvents; i++ {
+		storage.mu.Lock()
+		require.NoError(t, storage.append(event{Op: opDelete, Code: code, UserID: 1}))
+		storage.mu.Unlock()
+	}
+
+	require.Eventually(t, func() bool {
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		return storage.events == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, storage.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")))
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	_, err = storage.Get(ctx, code, 1)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+}
+
+func TestStorageCompactionConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	// ссылки, записанные во время сжатия, попадают в новый журнал
+	const links = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < links; i++ {
+			code := "code" + strconv.Itoa(i)
+			if _, errSet := storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: 1}); errSet != nil {
+				done <- errSet
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, storage.compactJournal())
+	}
+	require.NoError(t, <-done)
+	require.NoError(t, storage.compactJournal())
+	assert.Equal(t, links, storage.events)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	assert.Equal(t, links, storage.Len())
+	assert.Equal(t, links, storage.events)
+}
+
+func TestStorageSetBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	codes, err := storage.SetBatch(ctx, []models.Link{
+		{Code: "code1", URI: "https://google.com", UserID: 1},
+		{Code: "code2", URI: "https://ya.ru", UserID: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1", "code2"}, codes)
+
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code3", URI: "https://site.com", UserID: 1},
+		{Code: "code1", URI: "https://go.dev", UserID: 1},
+	})
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+	require.NoError(t, storage.Close())
+
+	// в журнал попадает только сохраненный пакет
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	assert.Equal(t, 2, storage.Len())
+	_, ok := storage.Lookup("code3")
+	assert.False(t, ok)
+}
+
+func TestStorageClicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	clicks := []models.Click{
+		{Code: "code1", Time: now, Referrer: "https://ya.ru", UserAgent: "curl/8.0", IP: "10.0.0.1"},
+		{Code: "code1", Time: now.Add(time.Second)},
+	}
+	require.NoError(t, storage.SaveClicks(ctx, clicks))
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, clicks, storage.Clicks())
+
+	// после сжатия журнала события сохраняются
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+	assert.ElementsMatch(t, clicks, storage.Clicks())
+}
+
+func TestStorageTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/shoes", UserID: 1, Tags: []string{"shoes"}})
+	require.NoError(t, err)
+	_, err = storage.SetBatch(ctx, []models.Link{{Code: "code2", URI: "https://site.com/hats", UserID: 1, Tags: []string{"hats"}}})
+	require.NoError(t, err)
+	require.NoError(t, storage.SetTags(ctx, "code2", 1, []string{"hats", "spring"}))
+	assert.ErrorIs(t, storage.SetTags(ctx, "code2", 2, nil), models.ErrForbidden)
+	require.NoError(t, storage.Close())
+
+	// теги восстанавливаются из журнала
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+
+	links, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.Equal(t, []string{"shoes"}, links[0].Tags)
+	assert.Equal(t, []string{"hats", "spring"}, links[1].Tags)
+
+	// и из снимка после сжатия журнала
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	counts, err := storage.GetTagCounts(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "hats", Links: 1}, {Tag: "shoes", Links: 1}, {Tag: "spring", Links: 1}}, counts)
+}
+
+func TestStorageUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/v1", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Update(ctx, "code1", 1, "https://site.com/v2", &[]string{"sale"})
+	require.NoError(t, err)
+	_, err = storage.Update(ctx, "code1", 2, "https://site.com/v3", nil)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	require.NoError(t, storage.Close())
+
+	// изменения восстанавливаются из журнала
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+
+	uri, err := storage.Get(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/v2", uri)
+	revisions, err := storage.GetRevisions(ctx, "code1", 1)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	r, _ := storage.Lookup("code1")
+	assert.Equal(t, []string{"sale"}, r.Tags)
+
+	// и из снимка после сжатия журнала
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	restored, err := storage.GetRevisions(ctx, "code1", 1)
+	require.NoError(t, err)
+	require.Len(t, restored, 2)
+	assert.Equal(t, "https://site.com/v1", restored[0].URI)
+	assert.Equal(t, "https://site.com/v2", restored[1].URI)
+	assert.True(t, revisions[1].CreatedAt.Equal(restored[1].CreatedAt))
+}
+
+func TestStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	for _, code := range []string{"code1", "code2"} {
+		_, err = storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: 1})
+		require.NoError(t, err)
+	}
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}, {UserID: 1, Code: "code2"}})
+	require.NoError(t, err)
+	restored, err := storage.Restore(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1"}, restored)
+	require.NoError(t, storage.Close())
+
+	// восстановление и время удаления сохраняются в журнале
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	_, err = storage.Get(ctx, "code1", 0)
+	assert.NoError(t, err)
+	_, err = storage.Get(ctx, "code2", 0)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+
+	restored, err = storage.Restore(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code2"}}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code2"}, restored)
+}
+
+func TestStorageUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	aliceID, err := storage.CreateUser(ctx, "alice", "hash1")
+	require.NoError(t, err)
+	_, err = storage.CreateUser(ctx, "alice", "hash2")
+	assert.ErrorIs(t, err, models.ErrLoginTaken)
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+
+	// пользователи сохраняются в журнале и переживают сжатие
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	user, err := storage.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, aliceID, user.ID)
+	assert.Equal(t, "hash1", user.PasswordHash)
+
+	bobID, err := storage.CreateUser(ctx, "bob", "hash3")
+	require.NoError(t, err)
+	assert.Equal(t, aliceID+1, bobID)
+}
+
+func TestStorageClaimLinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: anonymousID})
+	require.NoError(t, err)
+	claimed, err := storage.ClaimLinks(ctx, anonymousID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+	require.NoError(t, storage.Close())
+
+	// передача ссылок сохраняется в журнале
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	links, err := storage.GetByUser(ctx, userID, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+	_, err = storage.ClaimLinks(ctx, anonymousID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// новый пользователь не получает идентификатор анонимного
+	nextID, err := storage.CreateAnonymousUser(ctx, "uuid2", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, userID+1, nextID)
+}
+
+func TestStoragePurgeAnonymousUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	expiredID, err := storage.CreateAnonymousUser(ctx, "uuid", time.Now().Add(time.Second))
+	require.NoError(t, err)
+	purged, err := storage.PurgeAnonymousUsers(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	// без истекших пользователей журнал не меняется
+	events := storage.events
+	purged, err = storage.PurgeAnonymousUsers(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	assert.Equal(t, events, storage.events)
+	require.NoError(t, storage.Close())
+
+	// удаление сохраняется в журнале
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, storage.UsersLen())
+
+	// после сжатия журнала идентификатор удаленного пользователя не выдается снова
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	assert.Equal(t, 1, storage.UsersLen())
+	nextID, err := storage.CreateAnonymousUser(ctx, "uuid2", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []int{userID + 1, expiredID + 1}, []int{expiredID, nextID})
+}
+
+func TestStorageAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	storage, err := NewStorage(path)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key1 := models.APIKey{ID: "k1", UserID: 1, Name: "ci", Scopes: []string{models.ScopeLinksRead}, Hash: "hash1", CreatedAt: now}
+	key2 := models.APIKey{ID: "k2", UserID: 1, Scopes: []string{models.ScopeLinksWrite}, Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, storage.CreateAPIKey(ctx, key1))
+	require.NoError(t, storage.CreateAPIKey(ctx, key2))
+	assert.ErrorIs(t, storage.CreateAPIKey(ctx, key1), models.ErrConflict)
+	require.NoError(t, storage.DeleteAPIKey(ctx, "k2", 1))
+	require.NoError(t, storage.Close())
+
+	// ключи и их отзыв сохраняются в журнале
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+
+	keys, err := storage.GetAPIKeys(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.APIKey{key1}, keys)
+	_, err = storage.GetAPIKeyByHash(ctx, "hash2")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.ErrorIs(t, storage.DeleteAPIKey(ctx, "k1", 2), models.ErrForbidden)
+
+	// и переживают сжатие
+	storage.mu.Lock()
+	require.NoError(t, storage.rewrite())
+	storage.mu.Unlock()
+	require.NoError(t, storage.Close())
+	storage, err = NewStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	key, err := storage.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, key1, key)
 }
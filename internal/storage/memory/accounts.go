@@ -0,0 +1,241 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// accounts is the set of the users guarded by its own lock: the registered users by their logins
+// and the anonymous users by their IDs. The IDs are given in order starting from models.FirstUserID.
+type accounts struct {
+	mu        sync.RWMutex
+	logins    map[string]models.User
+	anonymous map[int]models.User
+	nextID    int
+}
+
+// CreateUser registers the user with the given login and password hash and returns the ID of the user.
+// If the login is already registered, it returns models.ErrLoginTaken.
+// Example usage:
+//
+//	userID, err := storage.CreateUser(ctx, "alice", hash)
+//	if errors.Is(err, models.ErrLoginTaken) {
+//	    // ask for another login
+//	}
+func (s *Storage) CreateUser(ctx context.Context, login string, passwordHash string) (int, error) {
+	s.accounts.mu.Lock()
+	defer s.accounts.mu.Unlock()
+
+	if _, ok := s.accounts.logins[login]; ok {
+		return 0, models.ErrLoginTaken
+	}
+	user := models.User{ID: s.accounts.next(), Login: login, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	s.accounts.put(user)
+
+	return user.ID, nil
+}
+
+// GetUser returns the registered user with the given login.
+// If the login is not registered, it returns models.ErrNotFound.
+func (s *Storage) GetUser(ctx context.Context, login string) (models.User, error) {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	user, ok := s.accounts.logins[login]
+	if !ok {
+		return models.User{}, models.ErrNotFound
+	}
+	return user, nil
+}
+
+// CreateAnonymousUser stores the anonymous user with the given UUID, which is valid until expiresAt,
+// and returns the ID of the user.
+func (s *Storage) CreateAnonymousUser(ctx context.Context, uuid string, expiresAt time.Time) (int, error) {
+	s.accounts.mu.Lock()
+	defer s.accounts.mu.Unlock()
+
+	user := models.User{ID: s.accounts.next(), UUID: uuid, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	s.accounts.put(user)
+
+	return user.ID, nil
+}
+
+// AnonymousUser returns the anonymous user with the given ID, if it is not expired yet.
+// The second value reports whether there is such a user.
+func (s *Storage) AnonymousUser(userID int) (models.User, bool) {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	user, ok := s.accounts.anonymous[userID]
+	if !ok || models.Expired(user.ExpiresAt, time.Now()) {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ClaimLinks gives all the links of the anonymous user to the user with the given ID and removes the anonymous user.
+// It returns the number of the given links.
+// If there is no such anonymous user or it is expired, it returns models.ErrNotFound.
+// Example usage:
+//
+//	claimed, err := storage.ClaimLinks(ctx, anonymousID, userID)
+func (s *Storage) ClaimLinks(ctx context.Context, anonymousID int, userID int) (int, error) {
+	// проверка и удаление анонимного пользователя атомарны, поэтому ссылки передаются только один раз
+	s.accounts.mu.Lock()
+	user, ok := s.accounts.anonymous[anonymousID]
+	ok = ok && !models.Expired(user.ExpiresAt, time.Now())
+	if ok {
+		delete(s.accounts.anonymous, anonymousID)
+	}
+	s.accounts.mu.Unlock()
+
+	if !ok {
+		return 0, models.ErrNotFound
+	}
+	return s.moveLinks(anonymousID, userID), nil
+}
+
+// Claim gives the links of the anonymous user to the user like ClaimLinks, but without checking the anonymous user.
+// It is used to restore previously saved claims.
+func (s *Storage) Claim(anonymousID int, userID int) int {
+	s.accounts.mu.Lock()
+	delete(s.accounts.anonymous, anonymousID)
+	s.accounts.mu.Unlock()
+
+	return s.moveLinks(anonymousID, userID)
+}
+
+// moveLinks gives the links of the anonymous user to the user and returns the number of the given links.
+func (s *Storage) moveLinks(anonymousID int, userID int) int {
+	claimed := 0
+	for _, code := range s.users[userShardIndex(anonymousID)].codes(anonymousID) {
+		cs := &s.codes[codeShardIndex(code)]
+		cs.mu.Lock()
+		r, ok := cs.records[code]
+		moved := ok && r.UserID == anonymousID
+		if moved {
+			r.UserID = userID
+		}
+		cs.mu.Unlock()
+
+		if moved {
+			s.users[userShardIndex(anonymousID)].remove(anonymousID, code)
+			s.users[userShardIndex(userID)].add(userID, code)
+			claimed++
+		}
+	}
+	return claimed
+}
+
+// PurgeAnonymousUsers removes the anonymous users which are expired at the moment now
+// and returns the number of the removed users. The links of the removed users are kept,
+// their IDs are never given again.
+func (s *Storage) PurgeAnonymousUsers(ctx context.Context, now time.Time) (int, error) {
+	s.accounts.mu.Lock()
+	defer s.accounts.mu.Unlock()
+
+	purged := 0
+	for id, user := range s.accounts.anonymous {
+		if models.Expired(user.ExpiresAt, now) {
+			delete(s.accounts.anonymous, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// HasExpiredAnonymous reports whether there are anonymous users which are expired at the moment now,
+// so PurgeAnonymousUsers would remove at least one user.
+func (s *Storage) HasExpiredAnonymous(now time.Time) bool {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	for _, user := range s.accounts.anonymous {
+		if models.Expired(user.ExpiresAt, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// PutUser stores the given user as is, replacing the user with the same login (or the same ID
+// for an anonymous user) if there is one.
+// Unlike CreateUser it does not give an ID, so it is used to restore previously saved users.
+func (s *Storage) PutUser(user models.User) {
+	s.accounts.mu.Lock()
+	defer s.accounts.mu.Unlock()
+
+	s.accounts.put(user)
+}
+
+// NextUserID returns the ID which will be given to the next registered user.
+func (s *Storage) NextUserID() int {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	return s.accounts.next()
+}
+
+// SetNextUserID moves the ID which will be given to the next user to at least next.
+// It is used to restore the IDs of the removed users, so they are not given again.
+func (s *Storage) SetNextUserID(next int) {
+	s.accounts.mu.Lock()
+	defer s.accounts.mu.Unlock()
+
+	s.accounts.nextID = max(s.accounts.next(), next)
+}
+
+// SeedUserIDs moves the ID which will be given to the next user to the number of microseconds since the Unix epoch
+// at the moment now. The storage keeps the users only until a restart, while their tokens stay valid
+// (if the signing keys are configured), so the IDs must not start from models.FirstUserID again:
+// otherwise an old token would authenticate as the user who got the same ID after the restart.
+// Seeded with the start time, the IDs of a run never reach the IDs of the next run, unless the storage
+// gives more than one ID per microsecond. The IDs stay below 2^53, so they are exact in JSON numbers.
+// Example usage:
+//
+//	storage := memory.NewStorage()
+//	storage.SeedUserIDs(time.Now())
+func (s *Storage) SeedUserIDs(now time.Time) {
+	s.SetNextUserID(int(now.UnixMicro()))
+}
+
+// Users returns all the users, registered and anonymous ones, in no particular order.
+func (s *Storage) Users() []models.User {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	users := make([]models.User, 0, len(s.accounts.logins)+len(s.accounts.anonymous))
+	for _, user := range s.accounts.logins {
+		users = append(users, user)
+	}
+	for _, user := range s.accounts.anonymous {
+		users = append(users, user)
+	}
+	return users
+}
+
+// UsersLen returns the number of the users, registered and anonymous ones.
+func (s *Storage) UsersLen() int {
+	s.accounts.mu.RLock()
+	defer s.accounts.mu.RUnlock()
+
+	return len(s.accounts.logins) + len(s.accounts.anonymous)
+}
+
+// next returns the ID for the next user. The caller must hold a.mu.
+func (a *accounts) next() int {
+	return max(a.nextID, models.FirstUserID)
+}
+
+// put stores the user and moves the next ID past it. The caller must hold a.mu for writing.
+func (a *accounts) put(user models.User) {
+	if user.IsAnonymous() {
+		a.anonymous[user.ID] = user
+	} else {
+		a.logins[user.Login] = user
+	}
+	a.nextID = max(a.next(), user.ID+1)
+}
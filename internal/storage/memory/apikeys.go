@@ -0,0 +1,127 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// apiKeys is the set of the API keys guarded by its own lock: the keys by their IDs
+// and the reverse index from the hashes of the keys to the IDs.
+type apiKeys struct {
+	mu     sync.RWMutex
+	byID   map[string]models.APIKey
+	byHash map[string]string
+}
+
+// CreateAPIKey stores the API key.
+// If the ID or the hash of the key is already taken, it returns models.ErrConflict.
+func (s *Storage) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	s.apiKeys.mu.Lock()
+	defer s.apiKeys.mu.Unlock()
+
+	_, idTaken := s.apiKeys.byID[key.ID]
+	_, hashTaken := s.apiKeys.byHash[key.Hash]
+	if idTaken || hashTaken {
+		return models.ErrConflict
+	}
+	s.apiKeys.put(key)
+	return nil
+}
+
+// GetAPIKeys returns the API keys of the user, the oldest first.
+func (s *Storage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	s.apiKeys.mu.RLock()
+	defer s.apiKeys.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0)
+	for _, key := range s.apiKeys.byID {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the API key with the given hash.
+// If there is no such key, it returns models.ErrNotFound.
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	s.apiKeys.mu.RLock()
+	defer s.apiKeys.mu.RUnlock()
+
+	id, ok := s.apiKeys.byHash[hash]
+	if !ok {
+		return models.APIKey{}, models.ErrNotFound
+	}
+	return s.apiKeys.byID[id], nil
+}
+
+// DeleteAPIKey removes the API key with the given ID, so it can no longer be used.
+// If the key is not found, it returns models.ErrNotFound.
+// If the key belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string, userID int) error {
+	s.apiKeys.mu.Lock()
+	defer s.apiKeys.mu.Unlock()
+
+	key, ok := s.apiKeys.byID[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+	if key.UserID != userID {
+		return models.ErrForbidden
+	}
+	delete(s.apiKeys.byID, id)
+	delete(s.apiKeys.byHash, key.Hash)
+	return nil
+}
+
+// PutAPIKey stores the given API key as is, replacing the key with the same ID if there is one.
+// It is used to restore previously saved keys.
+func (s *Storage) PutAPIKey(key models.APIKey) {
+	s.apiKeys.mu.Lock()
+	defer s.apiKeys.mu.Unlock()
+
+	if old, ok := s.apiKeys.byID[key.ID]; ok {
+		delete(s.apiKeys.byHash, old.Hash)
+	}
+	s.apiKeys.put(key)
+}
+
+// LookupAPIKey returns the API key with the given ID.
+// The second value reports whether the key exists.
+func (s *Storage) LookupAPIKey(id string) (models.APIKey, bool) {
+	s.apiKeys.mu.RLock()
+	defer s.apiKeys.mu.RUnlock()
+
+	key, ok := s.apiKeys.byID[id]
+	return key, ok
+}
+
+// APIKeys returns all the API keys in no particular order.
+func (s *Storage) APIKeys() []models.APIKey {
+	s.apiKeys.mu.RLock()
+	defer s.apiKeys.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0, len(s.apiKeys.byID))
+	for _, key := range s.apiKeys.byID {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// put stores the key. The caller must hold k.mu for writing.
+// The scopes are copied, so the stored key is not changed by the caller.
+func (k *apiKeys) put(key models.APIKey) {
+	key.Scopes = slices.Clone(key.Scopes)
+	k.byID[key.ID] = key
+	k.byHash[key.Hash] = key.ID
+}
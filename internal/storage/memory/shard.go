@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// shardCount is the number of shards the storage is split into. It must be a power of two.
+const shardCount = 64
+
+// codeShard is a part of the codes map guarded by its own lock.
+// It also keeps the click events of its codes.
+type codeShard struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+	clicks  map[string][]models.Click
+}
+
+// userShard is a part of the per-user index guarded by its own lock.
+type userShard struct {
+	mu    sync.RWMutex
+	users map[int]map[string]struct{}
+}
+
+// add puts the code to the index of the user.
+func (us *userShard) add(userID int, code string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	codes, ok := us.users[userID]
+	if !ok {
+		codes = make(map[string]struct{})
+		us.users[userID] = codes
+	}
+	codes[code] = struct{}{}
+}
+
+// remove deletes the code from the index of the user.
+func (us *userShard) remove(userID int, code string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	delete(us.users[userID], code)
+	if len(us.users[userID]) == 0 {
+		delete(us.users, userID)
+	}
+}
+
+// codes returns the codes of the user.
+func (us *userShard) codes(userID int) []string {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	codes := make([]string, 0, len(us.users[userID]))
+	for code := range us.users[userID] {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// codeShardIndex returns the index of the shard for the code (FNV-1a hash).
+func codeShardIndex(code string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(code); i++ {
+		hash ^= uint32(code[i])
+		hash *= 16777619
+	}
+	return hash & (shardCount - 1)
+}
+
+// userShardIndex returns the index of the shard for the user.
+func userShardIndex(userID int) uint32 {
+	return uint32(userID) & (shardCount - 1)
+}
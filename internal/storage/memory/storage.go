@@ -2,18 +2,69 @@ package memory
 
 import (
 	"context"
-	"errors"
-	"math/rand"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/search"
 )
 
-// Storage represents a map-based storage that stores key-value pairs. The key is a string, and the value is also a string.
-type Storage map[string]string
+// Record represents a single short link kept in the storage.
+// It contains the short code, the original URI, the owner, the soft delete flag and the time of the deletion
+// (zero if it is unknown), the creation time, the expiration time (zero if the link never expires), the tags
+// and the revisions of the destination (empty if the URI has never been changed).
+// The tags and the revisions of a stored record are never changed in place, they are replaced as a whole.
+type Record struct {
+	Code      string
+	URI       string
+	UserID    int
+	IsDeleted bool
+	DeletedAt time.Time
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Tags      []string
+	Revisions []models.Revision
+}
+
+// searchTexts returns the texts of the record which are searched (see search.LinkTexts).
+func (r *Record) searchTexts() []string {
+	return search.LinkTexts(r.Code, r.URI, r.Tags)
+}
+
+// userLink returns the record as a link in the history of the user with the given number of clicks.
+func (r *Record) userLink(clicks int) models.UserLink {
+	return models.UserLink{
+		Code:      r.Code,
+		URI:       r.URI,
+		IsDeleted: r.IsDeleted,
+		CreatedAt: r.CreatedAt,
+		Clicks:    clicks,
+		Tags:      r.Tags,
+	}
+}
+
+// Storage represents a map-based storage that stores short links by their codes.
+// In addition to the codes map it keeps a per-user index, so that the links of
+// a user can be listed and deleted without scanning the whole storage.
+// Both maps are split into shards with their own locks, so the storage is safe
+// for concurrent use and the handlers do not contend for a single lock.
+// The codes and the URIs are also kept in a trigram index (see search.Index) for Search.
+// The users and their API keys are kept apart from the links (see CreateUser and CreateAPIKey).
+type Storage struct {
+	codes    [shardCount]codeShard
+	users    [shardCount]userShard
+	index    *search.Index
+	count    atomic.Int64
+	clicks   atomic.Int64
+	accounts accounts
+	apiKeys  apiKeys
+}
 
 // Get retrieves the value associated with the given code from the storage.
-// If the code is not found in the storage, it returns an empty string and an error message "not found".
+// If the code is not found in the storage, it returns an empty string and models.ErrNotFound.
+// If the link was soft deleted, it returns models.ErrDeleted.
+// If the link is expired, it returns models.ErrExpired.
 // Example usage:
 //
 //	storage := NewStorage()
@@ -24,61 +75,401 @@ type Storage map[string]string
 //	}
 //	// use value
 func (s *Storage) Get(ctx context.Context, code string, userID int) (string, error) {
-	v, ok := (*s)[code]
+	link, err := s.GetLink(ctx, code)
+	return link.URI, err
+}
+
+// GetLink returns the link with the given code with the same errors as Get.
+func (s *Storage) GetLink(ctx context.Context, code string) (models.Link, error) {
+	r, ok := s.Lookup(code)
 	if !ok {
-		return "", errors.New("not found")
+		return models.Link{}, models.ErrNotFound
 	}
-	return v, nil
+	if r.IsDeleted {
+		return models.Link{}, models.ErrDeleted
+	}
+	if models.Expired(r.ExpiresAt, time.Now()) {
+		return models.Link{}, models.ErrExpired
+	}
+	return models.Link{Code: r.Code, URI: r.URI, UserID: r.UserID, ExpiresAt: r.ExpiresAt, Tags: r.Tags}, nil
 }
 
-// Set adds a new key-value pair to the storage.
-// The key is generated using the generateKey function.
-// The value is stored in the storage using the generated key.
-// The method returns the generated key and nil error.
+// Set adds a new link to the storage.
+// The link is stored under its code and the code is added to the user index.
+// The options (for example, the expiration time) are stored along with the value.
+// If the code is already taken, models.ErrCodeTaken is returned.
+// The method returns the code and nil error.
 // Example usage:
 //
 //	storage := NewStorage()
 //	ctx := context.Background()
-//	key, err := storage.Set(ctx, "https://site.com", userID)
+//	code, err := storage.Set(ctx, models.Link{Code: "abc", URI: "https://site.com", UserID: userID})
 //	if err != nil {
 //	    // handle error
 //	}
-//	// use key
-func (s *Storage) Set(ctx context.Context, value string, userID int) (string, error) {
-	key := generateKey()
-	(*s)[key] = value
-	return key, nil
+//	// use code
+func (s *Storage) Set(ctx context.Context, link models.Link) (string, error) {
+	r := Record{
+		Code:      link.Code,
+		URI:       link.URI,
+		UserID:    link.UserID,
+		CreatedAt: time.Now(),
+		ExpiresAt: link.ExpiresAt,
+		Tags:      link.Tags,
+	}
+	if !s.insert(r) {
+		return "", models.ErrCodeTaken
+	}
+	return r.Code, nil
+}
+
+// SetBatch adds all the given links to the storage or none of them.
+// The shards of all the codes are locked at once, so the batch is stored atomically.
+// If a code is already taken (or repeated in the batch), it returns *models.BatchError
+// with the index of the link and models.ErrCodeTaken.
+// Otherwise, it returns the codes in the order of the links.
+func (s *Storage) SetBatch(ctx context.Context, links []models.Link) ([]string, error) {
+	// блокируем шарды по возрастанию индекса, чтобы не было взаимоблокировок
+	var locked [shardCount]bool
+	for _, link := range links {
+		locked[codeShardIndex(link.Code)] = true
+	}
+	for i := range locked {
+		if locked[i] {
+			s.codes[i].mu.Lock()
+		}
+	}
+	unlock := func() {
+		for i := range locked {
+			if locked[i] {
+				s.codes[i].mu.Unlock()
+			}
+		}
+	}
+
+	seen := make(map[string]struct{}, len(links))
+	for i, link := range links {
+		_, taken := s.codes[codeShardIndex(link.Code)].records[link.Code]
+		if _, repeated := seen[link.Code]; taken || repeated {
+			unlock()
+			return nil, &models.BatchError{Index: i, Err: models.ErrCodeTaken}
+		}
+		seen[link.Code] = struct{}{}
+	}
+
+	now := time.Now()
+	codes := make([]string, 0, len(links))
+	for _, link := range links {
+		s.codes[codeShardIndex(link.Code)].records[link.Code] = &Record{
+			Code:      link.Code,
+			URI:       link.URI,
+			UserID:    link.UserID,
+			CreatedAt: now,
+			ExpiresAt: link.ExpiresAt,
+			Tags:      link.Tags,
+		}
+		codes = append(codes, link.Code)
+	}
+	unlock()
+
+	s.count.Add(int64(len(links)))
+	for _, link := range links {
+		s.users[userShardIndex(link.UserID)].add(link.UserID, link.Code)
+		s.index.Add(link.Code, search.LinkTexts(link.Code, link.URI, link.Tags)...)
+	}
+
+	return codes, nil
 }
 
-// GetByUser retrieves the data for a specific user from the storage.
-// Currently, the storage is implemented as a map[string]string, but it will be refactored to a map[userID][code]value structure in the future.
-// The method returns an array of models.GetByUserResponse and an error.
+// Put stores the given record as is, replacing the record with the same code if there is one.
+// Unlike Set it does not generate a code, so it is used to restore previously saved links.
+func (s *Storage) Put(r Record) {
+	cs := &s.codes[codeShardIndex(r.Code)]
+	cs.mu.Lock()
+	old, replaced := cs.records[r.Code]
+	cs.records[r.Code] = &r
+	cs.mu.Unlock()
+
+	if replaced {
+		s.users[userShardIndex(old.UserID)].remove(old.UserID, r.Code)
+		s.index.Remove(r.Code, old.searchTexts()...)
+	} else {
+		s.count.Add(1)
+	}
+	s.users[userShardIndex(r.UserID)].add(r.UserID, r.Code)
+	s.index.Add(r.Code, r.searchTexts()...)
+}
+
+// Lookup returns a copy of the record stored under the given code.
+// The second value reports whether the code exists.
+func (s *Storage) Lookup(code string) (Record, bool) {
+	cs := &s.codes[codeShardIndex(code)]
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	r, ok := cs.records[code]
+	if !ok {
+		return Record{}, false
+	}
+	return *r, true
+}
+
+// Len returns the number of records in the storage.
+func (s *Storage) Len() int {
+	return int(s.count.Load())
+}
+
+// Records returns copies of all records in the storage in no particular order.
+func (s *Storage) Records() []Record {
+	records := make([]Record, 0, s.Len())
+	for i := range s.codes {
+		cs := &s.codes[i]
+		cs.mu.RLock()
+		for _, r := range cs.records {
+			records = append(records, *r)
+		}
+		cs.mu.RUnlock()
+	}
+	return records
+}
+
+// GetByUser retrieves the links created by a specific user, including the soft deleted ones,
+// filtered, sorted and limited by the query (see models.HistoryQuery).
+// Each link carries the number of its clicks.
+// The method returns an array of models.UserLink and an error.
 // Example usage:
 //
 //	storage := NewStorage()
 //	ctx := context.Background()
-//	data, err := storage.GetByUser(ctx, userID)
+//	data, err := storage.GetByUser(ctx, userID, models.HistoryQuery{Limit: 100})
 //	if err != nil {
 //		// handle error
 //	}
 //	// use data
-//
-//	type models.GetByUserResponse struct {
-//		ShortURL    string `json:"short_url"`
-//		OriginalURL string `json:"original_url"`
-//	}
-func (s *Storage) GetByUser(ctx context.Context, userID int) ([]models.GetByUserResponse, error) {
-	// предстоит переделать хранилище на map[userID][code]value
-	return nil, nil
+func (s *Storage) GetByUser(ctx context.Context, userID int, query models.HistoryQuery) ([]models.UserLink, error) {
+	codes := s.users[userShardIndex(userID)].codes(userID)
+	links := make([]models.UserLink, 0, len(codes))
+	for _, code := range codes {
+		cs := &s.codes[codeShardIndex(code)]
+		cs.mu.RLock()
+		if r, ok := cs.records[code]; ok && r.UserID == userID {
+			links = append(links, r.userLink(len(cs.clicks[code])))
+		}
+		cs.mu.RUnlock()
+	}
+
+	return query.Apply(links), nil
+}
+
+// Search returns up to limit links of the user (the newest first), whose code, URI or tag contains the query
+// (case-insensitive). The candidates are taken from the trigram index, the queries shorter than
+// three letters are checked against all the links of the user.
+func (s *Storage) Search(ctx context.Context, userID int, query string, limit int) ([]models.UserLink, error) {
+	codes, ok := s.index.Candidates(query)
+	if !ok {
+		codes = s.users[userShardIndex(userID)].codes(userID)
+	}
+
+	var links []models.UserLink
+	for _, code := range codes {
+		cs := &s.codes[codeShardIndex(code)]
+		cs.mu.RLock()
+		if r, ok := cs.records[code]; ok && r.UserID == userID && search.Match(query, r.searchTexts()...) {
+			links = append(links, r.userLink(len(cs.clicks[code])))
+		}
+		cs.mu.RUnlock()
+	}
+
+	return models.HistoryQuery{Desc: true, Limit: limit}.Apply(links), nil
+}
+
+// SetTags replaces the tags of the link with the given code.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) SetTags(ctx context.Context, code string, userID int, tags []string) error {
+	cs := &s.codes[codeShardIndex(code)]
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	r, ok := cs.records[code]
+	if !ok {
+		return models.ErrNotFound
+	}
+	if r.UserID != userID {
+		return models.ErrForbidden
+	}
+	// индекс меняем под блокировкой ссылки, чтобы параллельные изменения не удалили ее новые триграммы
+	s.index.Remove(code, r.searchTexts()...)
+	r.Tags = tags
+	s.index.Add(code, r.searchTexts()...)
+	return nil
+}
+
+// GetTagCounts returns the number of links of the user per tag, the most frequent tags first.
+func (s *Storage) GetTagCounts(ctx context.Context, userID int) ([]models.TagCount, error) {
+	codes := s.users[userShardIndex(userID)].codes(userID)
+	tagLists := make([][]string, 0, len(codes))
+	for _, code := range codes {
+		if r, ok := s.Lookup(code); ok && r.UserID == userID {
+			tagLists = append(tagLists, r.Tags)
+		}
+	}
+	return models.CountTags(tagLists...), nil
+}
+
+// Update sets the new destination of the link with the given code and returns the new revision.
+// If the tags are not nil, they replace the tags of the link in the same change.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+// If the link was soft deleted, it returns models.ErrDeleted.
+func (s *Storage) Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error) {
+	return s.Revise(code, userID, uri, tags, time.Now())
+}
+
+// Revise sets the new destination of the link like Update, the new revision is made at the given time.
+// It is used to restore previously saved changes.
+func (s *Storage) Revise(code string, userID int, uri string, tags *[]string, at time.Time) (models.Revision, error) {
+	cs := &s.codes[codeShardIndex(code)]
+	cs.mu.Lock()
+	r, ok := cs.records[code]
+	if !ok {
+		cs.mu.Unlock()
+		return models.Revision{}, models.ErrNotFound
+	}
+	if r.UserID != userID {
+		cs.mu.Unlock()
+		return models.Revision{}, models.ErrForbidden
+	}
+	if r.IsDeleted {
+		cs.mu.Unlock()
+		return models.Revision{}, models.ErrDeleted
+	}
+	// индекс меняем под блокировкой ссылки, чтобы параллельные изменения не удалили ее новые триграммы
+	s.index.Remove(code, r.searchTexts()...)
+	r.Revisions = models.Revise(r.Revisions, r.URI, r.CreatedAt, uri, at)
+	r.URI = uri
+	if tags != nil {
+		r.Tags = *tags
+	}
+	s.index.Add(code, r.searchTexts()...)
+	revision := r.Revisions[len(r.Revisions)-1]
+	cs.mu.Unlock()
+
+	return revision, nil
 }
 
-// SoftDelete deletes the specified messages from the storage.
-// Currently, the storage is implemented as a map[string]string, but it will be refactored to a map[userID][code]value structure in the future.
+// GetRevisions returns the revisions of the link with the given code, the oldest first.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) GetRevisions(ctx context.Context, code string, userID int) ([]models.Revision, error) {
+	r, ok := s.Lookup(code)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	if r.UserID != userID {
+		return nil, models.ErrForbidden
+	}
+	return models.Revisions(r.Revisions, r.URI, r.CreatedAt), nil
+}
+
+// SoftDelete marks the links from the given messages as deleted.
+// A link is marked only if it belongs to the user from the message, other codes are ignored.
 func (s *Storage) SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error {
-	// предстоит переделать хранилище на map[userID][code]value
+	s.SoftDeleteAt(messages, time.Now())
+	return nil
+}
+
+// SoftDeleteAt marks the links from the given messages as deleted like SoftDelete at the given time.
+// The links which are already deleted keep their time of the deletion.
+// It is used to restore previously saved deletions.
+func (s *Storage) SoftDeleteAt(messages []models.RmvUrlsMsg, at time.Time) {
+	for _, msg := range messages {
+		cs := &s.codes[codeShardIndex(msg.Code)]
+		cs.mu.Lock()
+		if r, ok := cs.records[msg.Code]; ok && r.UserID == msg.UserID && !r.IsDeleted {
+			r.IsDeleted = true
+			r.DeletedAt = at
+		}
+		cs.mu.Unlock()
+	}
+}
+
+// Restore clears the deleted flag of the links from the given messages, which belong to the user
+// from the message and were deleted at or after deletedSince. Other codes are ignored.
+// It returns the codes of the restored links.
+func (s *Storage) Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error) {
+	restored := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		cs := &s.codes[codeShardIndex(msg.Code)]
+		cs.mu.Lock()
+		r, ok := cs.records[msg.Code]
+		if ok && r.UserID == msg.UserID && r.IsDeleted && !r.DeletedAt.Before(deletedSince) {
+			r.IsDeleted = false
+			r.DeletedAt = time.Time{}
+			restored = append(restored, msg.Code)
+		}
+		cs.mu.Unlock()
+	}
+	return restored, nil
+}
+
+// SaveClicks stores the click events of the links.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []models.Click) error {
+	for _, click := range clicks {
+		cs := &s.codes[codeShardIndex(click.Code)]
+		cs.mu.Lock()
+		cs.clicks[click.Code] = append(cs.clicks[click.Code], click)
+		cs.mu.Unlock()
+	}
+	s.clicks.Add(int64(len(clicks)))
 	return nil
 }
 
+// Clicks returns copies of all click events in the storage in no particular order.
+func (s *Storage) Clicks() []models.Click {
+	clicks := make([]models.Click, 0, s.ClicksLen())
+	for i := range s.codes {
+		cs := &s.codes[i]
+		cs.mu.RLock()
+		for _, codeClicks := range cs.clicks {
+			clicks = append(clicks, codeClicks...)
+		}
+		cs.mu.RUnlock()
+	}
+	return clicks
+}
+
+// GetLinkStats returns the statistics of the clicks of the link with the given code in the time range [from, to)
+// with up to top most frequent referrers and user agents.
+// If the code is not found, it returns models.ErrNotFound.
+// If the link belongs to another user, it returns models.ErrForbidden.
+func (s *Storage) GetLinkStats(ctx context.Context, code string, userID int, from, to time.Time, top int) (models.LinkStats, error) {
+	cs := &s.codes[codeShardIndex(code)]
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	r, ok := cs.records[code]
+	if !ok {
+		return models.LinkStats{}, models.ErrNotFound
+	}
+	if r.UserID != userID {
+		return models.LinkStats{}, models.ErrForbidden
+	}
+
+	builder := models.NewClickStatsBuilder(code, from, to)
+	for _, click := range cs.clicks[code] {
+		if !click.Time.Before(from) && click.Time.Before(to) {
+			builder.Add(click)
+		}
+	}
+	return builder.Stats(top), nil
+}
+
+// ClicksLen returns the number of click events in the storage.
+func (s *Storage) ClicksLen() int {
+	return int(s.clicks.Load())
+}
+
 // HealthCheck checks the health of the storage.
 // It can include memory utilization checks.
 func (s *Storage) HealthCheck(ctx context.Context) error {
@@ -88,23 +479,54 @@ func (s *Storage) HealthCheck(ctx context.Context) error {
 
 // NewStorage creates a new instance of the Storage struct.
 func NewStorage() *Storage {
-	return &Storage{}
+	s := &Storage{index: search.NewIndex()}
+	s.accounts.logins = make(map[string]models.User)
+	s.accounts.anonymous = make(map[int]models.User)
+	s.apiKeys.byID = make(map[string]models.APIKey)
+	s.apiKeys.byHash = make(map[string]string)
+	for i := range s.codes {
+		s.codes[i].records = make(map[string]*Record)
+		s.codes[i].clicks = make(map[string][]models.Click)
+		s.users[i].users = make(map[int]map[string]struct{})
+	}
+	return s
 }
 
 // GetStats retrieves the current statistics of the storage.
-func (s *Storage) GetStats(context.Context) (int, int, error) {
-	return len(*s), 0, nil
-}
+// It scans all the records shard by shard, so the numbers may be slightly inconsistent
+// if the links are changed at the same time.
+func (s *Storage) GetStats(ctx context.Context, since time.Time, top int) (models.StorageStats, error) {
+	builder := models.NewStatsBuilder(since)
+	for i := range s.codes {
+		cs := &s.codes[i]
+		cs.mu.RLock()
+		for _, r := range cs.records {
+			builder.Add(r.UserID, r.IsDeleted, r.CreatedAt)
+		}
+		cs.mu.RUnlock()
+	}
 
-func generateKey() string {
-	var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	var length = 8
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	stats := builder.Stats(top)
+	stats.Backend = "memory"
+	stats.Details = map[string]string{"shards": strconv.Itoa(shardCount)}
+	return stats, nil
+}
 
-	key := make([]byte, length)
-	for i := range key {
-		key[i] = charset[seededRand.Intn(len(charset))]
+// insert stores the record only if its code is not taken yet.
+// It reports whether the record was stored.
+func (s *Storage) insert(r Record) bool {
+	cs := &s.codes[codeShardIndex(r.Code)]
+	cs.mu.Lock()
+	if _, ok := cs.records[r.Code]; ok {
+		cs.mu.Unlock()
+		return false
 	}
+	cs.records[r.Code] = &r
+	cs.mu.Unlock()
+
+	s.count.Add(1)
+	s.users[userShardIndex(r.UserID)].add(r.UserID, r.Code)
+	s.index.Add(r.Code, r.searchTexts()...)
 
-	return string(key)
+	return true
 }
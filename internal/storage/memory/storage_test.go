@@ -2,10 +2,15 @@ package memory
 
 import (
 	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
 )
 
 func TestStorage(t *testing.T) {
@@ -14,7 +19,7 @@ func TestStorage(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	key, err := storage.Set(ctx, "https://site.com", 0)
+	key, err := storage.Set(ctx, models.Link{Code: "key", URI: "https://site.com"})
 	assert.NotEmpty(t, key)
 	assert.NoError(t, err)
 
@@ -26,12 +31,553 @@ func TestStorage(t *testing.T) {
 	assert.Empty(t, uri)
 	assert.Error(t, err)
 
-	data, err := storage.GetByUser(ctx, 0)
-	assert.Nil(t, data)
+	_, err = storage.Set(ctx, models.Link{Code: "key", URI: "https://ya.ru"})
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	assert.Empty(t, data)
+	assert.NoError(t, err)
+
+	stats, err := storage.GetStats(ctx, time.Time{}, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Urls)
+	assert.Equal(t, 1, stats.Live)
+	assert.Equal(t, 1, stats.Users)
+	assert.Equal(t, "memory", stats.Backend)
+}
+
+func TestStorageUserLinks(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code1, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+	code2, err := storage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1})
+	require.NoError(t, err)
+	code3, err := storage.Set(ctx, models.Link{Code: "code3", URI: "https://site.com", UserID: 2})
+	require.NoError(t, err)
 
-	urls, users, err := storage.GetStats(ctx)
-	assert.Equal(t, urls, 1)
-	assert.Equal(t, users, 0)
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, data, 2)
+	assert.Equal(t, code1, data[0].Code)
+	assert.Equal(t, "https://google.com", data[0].URI)
+	assert.Equal(t, code2, data[1].Code)
+	assert.Equal(t, "https://ya.ru", data[1].URI)
+
+	// чужие ссылки не удаляются
+	err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{
+		{UserID: 1, Code: code1},
+		{UserID: 1, Code: code3},
+	})
+	require.NoError(t, err)
+
+	uri, err := storage.Get(ctx, code1, 1)
+	assert.Empty(t, uri)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+
+	uri, err = storage.Get(ctx, code3, 2)
+	assert.Equal(t, "https://site.com", uri)
 	assert.NoError(t, err)
+
+	// удаленные ссылки остаются в истории пользователя
+	data, err = storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, data, 2)
+
+	// удаленные ссылки не считаются живыми
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := storage.GetStats(ctx, today, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Urls)
+	assert.Equal(t, 2, stats.Live)
+	assert.Equal(t, 1, stats.Deleted)
+	assert.Equal(t, 2, stats.Users)
+	assert.Equal(t, []models.DayCount{{Day: today, Links: 3}}, stats.CreatedPerDay)
+	assert.Equal(t, []models.UserCount{{UserID: 1, Links: 2}}, stats.TopUsers)
+}
+
+func TestStorageExpiration(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code1, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	code2, err := storage.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru", UserID: 1, ExpiresAt: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	uri, err := storage.Get(ctx, code1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://google.com", uri)
+
+	uri, err = storage.Get(ctx, code2, 1)
+	assert.ErrorIs(t, err, models.ErrExpired)
+	assert.Empty(t, uri)
+}
+
+func TestStorageConcurrent(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	const workers = 16
+	const links = 100
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(userID int) {
+			defer wg.Done()
+			for i := 0; i < links; i++ {
+				code, err := storage.Set(ctx, models.Link{
+					Code:   strconv.Itoa(userID) + "-" + strconv.Itoa(i),
+					URI:    "https://site.com/" + strconv.Itoa(i),
+					UserID: userID,
+				})
+				assert.NoError(t, err)
+
+				uri, err := storage.Get(ctx, code, userID)
+				assert.NoError(t, err)
+				assert.Equal(t, "https://site.com/"+strconv.Itoa(i), uri)
+
+				if i%2 == 0 {
+					err = storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: userID, Code: code}})
+					assert.NoError(t, err)
+				}
+				_, err = storage.GetByUser(ctx, userID, models.HistoryQuery{})
+				assert.NoError(t, err)
+			}
+		}(w + 1)
+	}
+	wg.Wait()
+
+	assert.Equal(t, workers*links, storage.Len())
+	for w := 1; w <= workers; w++ {
+		data, err := storage.GetByUser(ctx, w, models.HistoryQuery{})
+		require.NoError(t, err)
+		assert.Len(t, data, links)
+	}
+}
+
+func TestStorageSetBatch(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "taken", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	codes, err := storage.SetBatch(ctx, []models.Link{
+		{Code: "code1", URI: "https://ya.ru", UserID: 1},
+		{Code: "code2", URI: "https://site.com", UserID: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1", "code2"}, codes)
+	assert.Equal(t, 3, storage.Len())
+
+	// пакет с занятым или повторяющимся кодом не сохраняется
+	for _, links := range [][]models.Link{
+		{{Code: "code3", URI: "https://go.dev"}, {Code: "taken", URI: "https://go.dev/doc"}},
+		{{Code: "code3", URI: "https://go.dev"}, {Code: "code3", URI: "https://go.dev/doc"}},
+	} {
+		_, err = storage.SetBatch(ctx, links)
+		var batchErr *models.BatchError
+		require.ErrorAs(t, err, &batchErr)
+		assert.Equal(t, 1, batchErr.Index)
+		assert.ErrorIs(t, err, models.ErrCodeTaken)
+		_, ok := storage.Lookup("code3")
+		assert.False(t, ok)
+	}
+
+	data, err := storage.GetByUser(ctx, 1, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, data, 3)
+}
+
+func TestStorageSaveClicks(t *testing.T) {
+	storage := NewStorage()
+	ctx := context.Background()
+
+	now := time.Now()
+	clicks := []models.Click{
+		{Code: "code1", Time: now, Referrer: "https://ya.ru", IP: "10.0.0.1"},
+		{Code: "code2", Time: now},
+		{Code: "code1", Time: now.Add(time.Second)},
+	}
+	require.NoError(t, storage.SaveClicks(ctx, clicks))
+
+	assert.Equal(t, 3, storage.ClicksLen())
+	assert.ElementsMatch(t, clicks, storage.Clicks())
+}
+
+func TestStorageSearch(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/pricing", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code2", URI: "https://site.com/Pricing/enterprise", UserID: 1},
+		{Code: "code3", URI: "https://site.com/pricing", UserID: 2},
+	})
+	require.NoError(t, err)
+	storage.Put(Record{Code: "sale", URI: "https://ya.ru", UserID: 1, CreatedAt: time.Now()})
+
+	// новые ссылки идут первыми, чужие не находятся
+	links, err := storage.Search(ctx, 1, "PRICING", 10)
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.Equal(t, "code2", links[0].Code)
+	assert.Equal(t, "code1", links[1].Code)
+
+	links, err = storage.Search(ctx, 1, "pricing", 1)
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+
+	// короткий запрос проверяется по всем ссылкам пользователя
+	links, err = storage.Search(ctx, 1, "sa", 10)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "sale", links[0].Code)
+
+	links, err = storage.Search(ctx, 1, "blog", 10)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	// ссылка находится по тегу, в том числе после его замены
+	require.NoError(t, storage.SetTags(ctx, "code1", 1, []string{"blog-post"}))
+	links, err = storage.Search(ctx, 1, "blog", 10)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "code1", links[0].Code)
+	_, err = storage.Update(ctx, "code1", 1, "https://site.com/news", &[]string{"news"})
+	require.NoError(t, err)
+	links, err = storage.Search(ctx, 1, "blog", 10)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+	links, err = storage.Search(ctx, 1, "news", 10)
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+}
+
+func TestStorageTags(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/shoes", UserID: 1, Tags: []string{"shoes", "spring"}})
+	require.NoError(t, err)
+	_, err = storage.SetBatch(ctx, []models.Link{
+		{Code: "code2", URI: "https://site.com/hats", UserID: 1, Tags: []string{"spring"}},
+		{Code: "code3", URI: "https://site.com/bags", UserID: 2, Tags: []string{"spring"}},
+	})
+	require.NoError(t, err)
+
+	links, err := storage.GetByUser(ctx, 1, models.HistoryQuery{Tag: "spring"})
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+
+	// теги заменяются целиком, чужие ссылки не меняются
+	require.NoError(t, storage.SetTags(ctx, "code2", 1, []string{"hats"}))
+	assert.ErrorIs(t, storage.SetTags(ctx, "code3", 1, nil), models.ErrForbidden)
+	assert.ErrorIs(t, storage.SetTags(ctx, "unknown", 1, nil), models.ErrNotFound)
+
+	links, err = storage.GetByUser(ctx, 1, models.HistoryQuery{Tag: "hats"})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "code2", links[0].Code)
+	assert.Equal(t, []string{"hats"}, links[0].Tags)
+
+	counts, err := storage.GetTagCounts(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "hats", Links: 1}, {Tag: "shoes", Links: 1}, {Tag: "spring", Links: 1}}, counts)
+
+	counts, err = storage.GetTagCounts(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{{Tag: "spring", Links: 1}}, counts)
+}
+
+func TestStorageUpdate(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := storage.Set(ctx, models.Link{Code: "code1", URI: "https://site.com/old", UserID: 1})
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code2", URI: "https://site.com/deleted", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code2"}}))
+
+	// без изменений у ссылки одна ревизия
+	revisions, err := storage.GetRevisions(ctx, "code1", 1)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "https://site.com/old", revisions[0].URI)
+
+	copied, _ := storage.Lookup("code1")
+	revision, err := storage.Update(ctx, "code1", 1, "https://site.com/new", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, revision.Number)
+	assert.Empty(t, copied.Revisions)
+
+	uri, err := storage.Get(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/new", uri)
+
+	revisions, err = storage.GetRevisions(ctx, "code1", 1)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "https://site.com/old", revisions[0].URI)
+	assert.Equal(t, "https://site.com/new", revisions[1].URI)
+
+	// поиск находит ссылку по новому адресу
+	links, err := storage.Search(ctx, 1, "new", 10)
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+	links, err = storage.Search(ctx, 1, "old", 10)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	// теги меняются вместе с адресом, при ошибке не меняется ничего
+	_, err = storage.Update(ctx, "code1", 1, "https://site.com/tagged", &[]string{"sale"})
+	require.NoError(t, err)
+	r, _ := storage.Lookup("code1")
+	assert.Equal(t, []string{"sale"}, r.Tags)
+	_, err = storage.Update(ctx, "code2", 1, "https://site.com/other", &[]string{"sale"})
+	assert.ErrorIs(t, err, models.ErrDeleted)
+	r, _ = storage.Lookup("code2")
+	assert.Empty(t, r.Tags)
+
+	_, err = storage.Update(ctx, "code1", 2, "https://site.com/other", nil)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	_, err = storage.Update(ctx, "code2", 1, "https://site.com/other", nil)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+	_, err = storage.Update(ctx, "unknown", 1, "https://site.com/other", nil)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = storage.GetRevisions(ctx, "code1", 2)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+}
+
+func TestStorageRestore(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, code := range []string{"code1", "code2", "code3"} {
+		_, err := storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: 1})
+		require.NoError(t, err)
+	}
+	storage.SoftDeleteAt([]models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}, time.Now().Add(-time.Hour))
+	require.NoError(t, storage.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code2"}}))
+
+	// удаленные раньше периода, чужие и не удаленные ссылки пропускаются
+	restored, err := storage.Restore(ctx, []models.RmvUrlsMsg{
+		{UserID: 1, Code: "code1"},
+		{UserID: 2, Code: "code2"},
+		{UserID: 1, Code: "code3"},
+		{UserID: 1, Code: "unknown"},
+	}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+
+	restored, err = storage.Restore(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}, {UserID: 1, Code: "code2"}},
+		time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code2"}, restored)
+
+	uri, err := storage.Get(ctx, "code2", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/code2", uri)
+	_, err = storage.Get(ctx, "code1", 0)
+	assert.ErrorIs(t, err, models.ErrDeleted)
+}
+
+func TestStorageUsers(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// идентификаторы выдаются по порядку начиная с models.FirstUserID
+	aliceID, err := storage.CreateUser(ctx, "alice", "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, models.FirstUserID, aliceID)
+	bobID, err := storage.CreateUser(ctx, "bob", "hash2")
+	require.NoError(t, err)
+	assert.Equal(t, models.FirstUserID+1, bobID)
+
+	_, err = storage.CreateUser(ctx, "alice", "hash3")
+	assert.ErrorIs(t, err, models.ErrLoginTaken)
+
+	user, err := storage.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, aliceID, user.ID)
+	assert.Equal(t, "hash1", user.PasswordHash)
+	_, err = storage.GetUser(ctx, "carol")
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	// восстановленный пользователь сдвигает следующий идентификатор
+	storage.PutUser(models.User{ID: models.FirstUserID + 10, Login: "dave"})
+	assert.Equal(t, models.FirstUserID+11, storage.NextUserID())
+	assert.Len(t, storage.Users(), 3)
+}
+
+func TestStorageClaimLinks(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid1", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	expiredID, err := storage.CreateAnonymousUser(ctx, "uuid2", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	assert.Equal(t, []int{models.FirstUserID, models.FirstUserID + 1, models.FirstUserID + 2}, []int{anonymousID, expiredID, userID})
+
+	for i, owner := range []int{anonymousID, anonymousID, expiredID} {
+		code := "code" + strconv.Itoa(i)
+		_, err = storage.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: owner})
+		require.NoError(t, err)
+	}
+
+	claimed, err := storage.ClaimLinks(ctx, anonymousID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, claimed)
+	links, err := storage.GetByUser(ctx, userID, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+	links, err = storage.GetByUser(ctx, anonymousID, models.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	// анонимный пользователь удален, истекший передать ссылки не может
+	_, err = storage.ClaimLinks(ctx, anonymousID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = storage.ClaimLinks(ctx, expiredID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestStorageClaimLinksConcurrent(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code", URI: "https://google.com", UserID: anonymousID})
+	require.NoError(t, err)
+
+	// ссылки получает только один из пользователей, забирающих их одновременно
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			if _, errClaim := storage.ClaimLinks(ctx, anonymousID, userID); errClaim == nil {
+				succeeded.Add(1)
+			}
+		}(anonymousID + i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded.Load())
+}
+
+func TestStoragePurgeAnonymousUsers(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymousID, err := storage.CreateAnonymousUser(ctx, "uuid1", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	expiredID, err := storage.CreateAnonymousUser(ctx, "uuid2", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	_, err = storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	_, err = storage.Set(ctx, models.Link{Code: "code", URI: "https://google.com", UserID: expiredID})
+	require.NoError(t, err)
+
+	purged, err := storage.PurgeAnonymousUsers(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, 2, storage.UsersLen())
+	_, ok := storage.AnonymousUser(anonymousID)
+	assert.True(t, ok)
+
+	// ссылки удаленного пользователя остаются
+	uri, err := storage.Get(ctx, "code", expiredID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", uri)
+
+	storage.SetNextUserID(models.FirstUserID)
+	assert.Equal(t, expiredID+2, storage.NextUserID())
+	storage.SetNextUserID(expiredID + 10)
+	assert.Equal(t, expiredID+10, storage.NextUserID())
+}
+
+func TestStorageSeedUserIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	storage := NewStorage()
+	storage.SeedUserIDs(start)
+	userID, err := storage.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+
+	// после перезапуска новый пользователь не получает идентификатор прежнего
+	restarted := NewStorage()
+	restarted.SeedUserIDs(start.Add(time.Millisecond))
+	nextID, err := restarted.CreateUser(ctx, "bob", "hash")
+	require.NoError(t, err)
+	assert.Greater(t, nextID, userID)
+	assert.Less(t, nextID, 1<<53)
+}
+
+func TestStorageAPIKeys(t *testing.T) {
+	storage := NewStorage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	key1 := models.APIKey{ID: "k1", UserID: 1, Scopes: []string{models.ScopeLinksRead}, Hash: "hash1", CreatedAt: now}
+	key2 := models.APIKey{ID: "k2", UserID: 1, Scopes: []string{models.ScopeLinksWrite}, Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, storage.CreateAPIKey(ctx, key2))
+	require.NoError(t, storage.CreateAPIKey(ctx, key1))
+	assert.ErrorIs(t, storage.CreateAPIKey(ctx, key1), models.ErrConflict)
+
+	// ключи пользователя возвращаются от старых к новым
+	keys, err := storage.GetAPIKeys(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.APIKey{key1, key2}, keys)
+	keys, err = storage.GetAPIKeys(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	key, err := storage.GetAPIKeyByHash(ctx, "hash2")
+	require.NoError(t, err)
+	assert.Equal(t, key2, key)
+
+	// отозвать ключ может только его владелец
+	assert.ErrorIs(t, storage.DeleteAPIKey(ctx, "k2", 2), models.ErrForbidden)
+	require.NoError(t, storage.DeleteAPIKey(ctx, "k2", 1))
+	assert.ErrorIs(t, storage.DeleteAPIKey(ctx, "k2", 1), models.ErrNotFound)
+	_, err = storage.GetAPIKeyByHash(ctx, "hash2")
+	assert.ErrorIs(t, err, models.ErrNotFound)
 }
@@ -0,0 +1,127 @@
+// Package search provides a simple in-process trigram index of short links
+// for the storages which cannot search the links by themselves.
+package search
+
+import (
+	"strings"
+	"sync"
+)
+
+// Index is a trigram index of short links: it maps every three-letter substring
+// of the lower-cased code, URI and tags of a link (see LinkTexts) to the codes of the links containing it.
+// The index only narrows down the candidates: they must be checked with Match,
+// since the trigrams of a query may be found in different places of the text.
+// The index is safe for concurrent use.
+// Example usage:
+//
+//	index := search.NewIndex()
+//	index.Add(code, search.LinkTexts(code, uri, tags)...)
+//	codes, ok := index.Candidates("pricing")
+type Index struct {
+	mu    sync.RWMutex
+	grams map[string]map[string]struct{}
+}
+
+// NewIndex creates an empty Index.
+func NewIndex() *Index {
+	return &Index{grams: make(map[string]map[string]struct{})}
+}
+
+// Add indexes the texts (for example, the code and the URI) of the link with the given code.
+func (i *Index) Add(code string, texts ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, gram := range trigrams(texts...) {
+		codes, ok := i.grams[gram]
+		if !ok {
+			codes = make(map[string]struct{})
+			i.grams[gram] = codes
+		}
+		codes[code] = struct{}{}
+	}
+}
+
+// Remove removes the texts of the link with the given code from the index.
+// The texts must be the same as the ones passed to Add.
+func (i *Index) Remove(code string, texts ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, gram := range trigrams(texts...) {
+		delete(i.grams[gram], code)
+		if len(i.grams[gram]) == 0 {
+			delete(i.grams, gram)
+		}
+	}
+}
+
+// Candidates returns the codes of the links whose texts contain all the trigrams of the query.
+// The second value is false if the query is shorter than three letters and cannot be looked up,
+// in this case the caller has to check all the links with Match.
+func (i *Index) Candidates(query string) ([]string, bool) {
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	// начинаем с самой редкой триграммы, чтобы пересечение было минимальным
+	smallest := i.grams[grams[0]]
+	for _, gram := range grams[1:] {
+		if len(i.grams[gram]) < len(smallest) {
+			smallest = i.grams[gram]
+		}
+	}
+
+	codes := make([]string, 0, len(smallest))
+	for code := range smallest {
+		found := true
+		for _, gram := range grams {
+			if _, ok := i.grams[gram][code]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			codes = append(codes, code)
+		}
+	}
+	return codes, true
+}
+
+// LinkTexts returns the texts of a link which are searched: the code, the URI and the tags.
+// The links have no titles or notes, so the tags are the only user-given texts to search by.
+func LinkTexts(code, uri string, tags []string) []string {
+	return append([]string{code, uri}, tags...)
+}
+
+// Match reports whether one of the texts contains the query (case-insensitive).
+func Match(query string, texts ...string) bool {
+	query = strings.ToLower(query)
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// trigrams returns the distinct trigrams of the lower-cased texts.
+func trigrams(texts ...string) []string {
+	seen := make(map[string]struct{})
+	var grams []string
+	for _, text := range texts {
+		runes := []rune(strings.ToLower(text))
+		for j := 0; j+3 <= len(runes); j++ {
+			gram := string(runes[j : j+3])
+			if _, ok := seen[gram]; !ok {
+				seen[gram] = struct{}{}
+				grams = append(grams, gram)
+			}
+		}
+	}
+	return grams
+}
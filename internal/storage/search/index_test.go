@@ -0,0 +1,44 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	index := NewIndex()
+	index.Add("code1", "code1", "https://site.com/Pricing")
+	index.Add("code2", "code2", "https://site.com/about")
+	index.Add("price", "price", "https://ya.ru")
+
+	codes, ok := index.Candidates("pricing")
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"code1"}, codes)
+
+	codes, ok = index.Candidates("PRIC")
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"code1", "price"}, codes)
+
+	codes, ok = index.Candidates("site.com/")
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"code1", "code2"}, codes)
+
+	codes, ok = index.Candidates("blog")
+	assert.True(t, ok)
+	assert.Empty(t, codes)
+
+	// короткий запрос по индексу не ищется
+	_, ok = index.Candidates("ya")
+	assert.False(t, ok)
+
+	index.Remove("code1", "code1", "https://site.com/Pricing")
+	codes, _ = index.Candidates("pricing")
+	assert.Empty(t, codes)
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("pricing", "code1", "https://site.com/Pricing"))
+	assert.True(t, Match("CODE", "code1", "https://site.com"))
+	assert.False(t, Match("blog", "code1", "https://site.com"))
+}
@@ -1,10 +1,14 @@
 package subnet
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 // Handle is a function that takes a http.HandlerFunc as an argument and returns a modified http.HandlerFunc.
@@ -24,8 +28,57 @@ func Handle(handler http.HandlerFunc) http.HandlerFunc {
 	return handlerFunc
 }
 
+// TrustedContext reports whether the gRPC request comes from the trusted subnet.
+// Like Handle, it checks the address of the client (see ContextIP).
+func TrustedContext(ctx context.Context) bool {
+	return trusted(ContextIP(ctx))
+}
+
+// RequestIP returns the IP address of the client of the HTTP request: the address of the remote side
+// of the connection, or the X-Real-IP header if the connection comes from a trusted proxy
+// (see config.Options.TrustedProxies). The header of any other client is ignored, so it cannot be forged.
+func RequestIP(req *http.Request) net.IP {
+	return clientIP(req.RemoteAddr, req.Header.Get("X-Real-IP"))
+}
+
+// ContextIP returns the IP address of the client of the gRPC request like RequestIP:
+// the address of the peer, or the "x-real-ip" metadata if the peer is a trusted proxy.
+// It returns nil if the peer is unknown.
+func ContextIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	var forwarded string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-real-ip"); len(values) > 0 {
+			forwarded = values[0]
+		}
+	}
+	return clientIP(p.Addr.String(), forwarded)
+}
+
+// clientIP returns the IP address of the remote side ("host:port" or a bare host),
+// or the forwarded address if the remote side is a trusted proxy.
+func clientIP(remoteAddr string, forwarded string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if forwarded != "" && fromProxy(ip) {
+		if forwardedIP := net.ParseIP(strings.TrimSpace(forwarded)); forwardedIP != nil {
+			return forwardedIP
+		}
+	}
+	return ip
+}
+
 func checkTrust(req *http.Request) bool {
-	ip := net.ParseIP(req.Header.Get("X-Real-IP"))
+	return trusted(RequestIP(req))
+}
+
+func trusted(ip net.IP) bool {
 	if ip == nil {
 		return false
 	}
@@ -34,7 +87,24 @@ func checkTrust(req *http.Request) bool {
 		return false
 	}
 
-	_, trustNet, _ := net.ParseCIDR(config.Options.TrustedNet)
+	_, trustNet, err := net.ParseCIDR(config.Options.TrustedNet)
+	if err != nil {
+		return false
+	}
 
 	return trustNet.Contains(ip)
 }
+
+// fromProxy reports whether the address belongs to one of the trusted proxies (comma-separated subnets).
+func fromProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range strings.Split(config.Options.TrustedProxies, ",") {
+		_, proxyNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err == nil && proxyNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package subnet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yury-kuznetsov/shortener/cmd/config"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestHandle(t *testing.T) {
+	config.Options.TrustedNet = "192.168.1.0/24"
+	config.Options.TrustedProxies = "10.0.0.1/32"
+	defer func() {
+		config.Options.TrustedNet = ""
+		config.Options.TrustedProxies = ""
+	}()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		status     int
+	}{
+		{name: "trusted client", remoteAddr: "192.168.1.10:5000", status: http.StatusOK},
+		{name: "untrusted client", remoteAddr: "8.8.8.8:5000", status: http.StatusForbidden},
+		{name: "forged header", remoteAddr: "8.8.8.8:5000", realIP: "192.168.1.10", status: http.StatusForbidden},
+		{name: "header from proxy", remoteAddr: "10.0.0.1:5000", realIP: "192.168.1.10", status: http.StatusOK},
+		{name: "untrusted client behind proxy", remoteAddr: "10.0.0.1:5000", realIP: "8.8.8.8", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			Handle(func(res http.ResponseWriter, req *http.Request) {})(rec, req)
+			res := rec.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
+
+func TestTrustedContext(t *testing.T) {
+	config.Options.TrustedNet = "192.168.1.0/24"
+	config.Options.TrustedProxies = "10.0.0.1/32"
+	defer func() {
+		config.Options.TrustedNet = ""
+		config.Options.TrustedProxies = ""
+	}()
+
+	// метаданные клиента не заменяют адрес соединения
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-real-ip", "192.168.1.10"))
+	assert.False(t, TrustedContext(ctx))
+	client := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 5000}})
+	assert.False(t, TrustedContext(client))
+
+	// но принимаются от доверенного прокси
+	proxy := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	assert.True(t, TrustedContext(proxy))
+	direct := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000}})
+	assert.True(t, TrustedContext(direct))
+}
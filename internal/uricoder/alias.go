@@ -0,0 +1,43 @@
+package uricoder
+
+import (
+	"strings"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+)
+
+// reservedAliases contains the first path segments used by the routes of the service.
+// They cannot be used as aliases, otherwise the short URL would be shadowed by the route.
+var reservedAliases = map[string]struct{}{
+	"api":         {},
+	"debug":       {},
+	"favicon.ico": {},
+	"ping":        {},
+}
+
+// ErrIncorrectAlias is returned when the alias has a wrong length, contains
+// characters other than latin letters, digits, "-" and "_", or is reserved.
+var ErrIncorrectAlias = models.NewError("incorrect alias", models.ErrInvalidArgument)
+
+// ValidateAlias checks that the alias can be used as a short code.
+func ValidateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return ErrIncorrectAlias
+	}
+	for _, c := range alias {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return ErrIncorrectAlias
+		}
+	}
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return ErrIncorrectAlias
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package uricoder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// APIKeyPrefix is the prefix of the API keys, which makes them easy to recognize (for example, by secret scanners).
+const APIKeyPrefix = "shk_"
+
+// MaxAPIKeyNameLength is the maximal length of the name of an API key in characters.
+const MaxAPIKeyNameLength = 64
+
+var (
+	// ErrIncorrectScopes is returned when the scopes of a new API key are empty or unknown.
+	ErrIncorrectScopes = models.NewError("incorrect scopes", models.ErrInvalidArgument)
+
+	// ErrIncorrectKeyName is returned when the name of a new API key is too long.
+	ErrIncorrectKeyName = models.NewError("incorrect key name", models.ErrInvalidArgument)
+
+	// ErrInvalidAPIKey is returned when the API key is unknown or revoked.
+	ErrInvalidAPIKey = models.NewError("invalid API key", models.ErrUnauthorized)
+)
+
+// CreateAPIKey creates a new API key of the user with the name and the scopes from the request.
+// The scopes are deduplicated and sorted in the order of models.Scopes.
+// Only the hash of the key is stored, so the response is the only place where the key itself is returned.
+// It returns ErrIncorrectScopes or ErrIncorrectKeyName if the request is incorrect.
+// Example usage:
+//
+//	response, err := coder.CreateAPIKey(ctx, userID, models.APIKeyRequest{Scopes: []string{models.ScopeLinksWrite}})
+//	if err != nil {
+//	    // handle error
+//	}
+//	fmt.Println("Key:", response.Key)
+func (coder *Coder) CreateAPIKey(ctx context.Context, userID int, request models.APIKeyRequest) (models.NewAPIKeyResponse, error) {
+	name := strings.TrimSpace(request.Name)
+	if utf8.RuneCountInString(name) > MaxAPIKeyNameLength {
+		return models.NewAPIKeyResponse{}, ErrIncorrectKeyName
+	}
+	scopes, err := normalizeScopes(request.Scopes)
+	if err != nil {
+		return models.NewAPIKeyResponse{}, err
+	}
+
+	for attempt := 0; attempt < coder.maxAttempts; attempt++ {
+		id, errRand := randomString(8, hex.EncodeToString)
+		if errRand != nil {
+			return models.NewAPIKeyResponse{}, errRand
+		}
+		secret, errRand := randomString(32, base64.RawURLEncoding.EncodeToString)
+		if errRand != nil {
+			return models.NewAPIKeyResponse{}, errRand
+		}
+		key := APIKeyPrefix + secret
+
+		apiKey := models.APIKey{
+			ID:        id,
+			UserID:    userID,
+			Name:      name,
+			Scopes:    scopes,
+			Hash:      hashAPIKey(key),
+			CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		}
+		err = coder.storage.CreateAPIKey(ctx, apiKey)
+		if err == nil {
+			return models.NewAPIKeyResponse{APIKey: apiKey, Key: key}, nil
+		}
+		// при совпадении идентификатора пробуем еще раз
+		if !errors.Is(err, models.ErrConflict) {
+			return models.NewAPIKeyResponse{}, err
+		}
+	}
+	return models.NewAPIKeyResponse{}, err
+}
+
+// GetAPIKeys returns the API keys of the user (without the keys themselves), the oldest first.
+func (coder *Coder) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return coder.storage.GetAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey removes the API key of the user with the given ID, so it can no longer be used.
+// The storage returns models.ErrNotFound for an unknown key and models.ErrForbidden for a key of another user.
+func (coder *Coder) RevokeAPIKey(ctx context.Context, id string, userID int) error {
+	return coder.storage.DeleteAPIKey(ctx, id, userID)
+}
+
+// AuthenticateAPIKey returns the stored API key for the key sent by a client.
+// It returns ErrInvalidAPIKey if the key is unknown or revoked.
+// Example usage:
+//
+//	apiKey, err := coder.AuthenticateAPIKey(ctx, strings.TrimPrefix(header, "Bearer "))
+//	if errors.Is(err, models.ErrUnauthorized) {
+//	    // answer 401 Unauthorized
+//	}
+func (coder *Coder) AuthenticateAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	apiKey, err := coder.storage.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if errors.Is(err, models.ErrNotFound) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	return apiKey, err
+}
+
+// normalizeScopes returns the known scopes in the order of models.Scopes without duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrIncorrectScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return nil, ErrIncorrectScopes
+		}
+	}
+	result := make([]string, 0, len(scopes))
+	for _, scope := range models.Scopes {
+		if slices.Contains(scopes, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// hashAPIKey returns the SHA-256 hash of the key in hex. The keys are long random strings,
+// so a fast hash is enough and the key can be found by its hash.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes in the given encoding.
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,89 @@
+package uricoder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		request models.APIKeyRequest
+		scopes  []string
+		err     error
+	}{
+		{
+			name:    "created",
+			request: models.APIKeyRequest{Name: " ci ", Scopes: []string{models.ScopeStatsRead, models.ScopeLinksRead, models.ScopeLinksRead}},
+			scopes:  []string{models.ScopeLinksRead, models.ScopeStatsRead},
+		},
+		{name: "no scopes", request: models.APIKeyRequest{Name: "ci"}, err: ErrIncorrectScopes},
+		{name: "unknown scope", request: models.APIKeyRequest{Scopes: []string{"admin"}}, err: ErrIncorrectScopes},
+		{
+			name:    "long name",
+			request: models.APIKeyRequest{Name: strings.Repeat("n", MaxAPIKeyNameLength+1), Scopes: []string{models.ScopeLinksRead}},
+			err:     ErrIncorrectKeyName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := coder.CreateAPIKey(ctx, 1, tt.request)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.ErrorIs(t, err, models.ErrInvalidArgument)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "ci", response.Name)
+			assert.Equal(t, tt.scopes, response.Scopes)
+			assert.True(t, strings.HasPrefix(response.Key, APIKeyPrefix))
+
+			// ключ хранится только в виде хеша
+			key, err := s.GetAPIKeyByHash(ctx, response.Hash)
+			require.NoError(t, err)
+			assert.NotContains(t, key.Hash, strings.TrimPrefix(response.Key, APIKeyPrefix))
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	response, err := coder.CreateAPIKey(ctx, 1, models.APIKeyRequest{Scopes: []string{models.ScopeLinksWrite}})
+	require.NoError(t, err)
+
+	key, err := coder.AuthenticateAPIKey(ctx, response.Key)
+	require.NoError(t, err)
+	assert.Equal(t, response.APIKey, key)
+
+	_, err = coder.AuthenticateAPIKey(ctx, response.Key+"x")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+	_, err = coder.AuthenticateAPIKey(ctx, "SECRET_KEY")
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+
+	// отозванный ключ больше не принимается
+	assert.ErrorIs(t, coder.RevokeAPIKey(ctx, response.ID, 2), models.ErrForbidden)
+	require.NoError(t, coder.RevokeAPIKey(ctx, response.ID, 1))
+	_, err = coder.AuthenticateAPIKey(ctx, response.Key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	keys, err := coder.GetAPIKeys(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
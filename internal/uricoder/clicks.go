@@ -0,0 +1,63 @@
+package uricoder
+
+import (
+	"context"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+const (
+	// clicksBufferSize is the capacity of the channel of click events.
+	clicksBufferSize = 4096
+	// clicksBatchSize is the number of click events which are saved without waiting for the ticker.
+	clicksBatchSize = 1000
+	// clicksFlushInterval is the interval of saving the collected click events.
+	clicksFlushInterval = time.Second
+)
+
+// RecordClick records a redirect by a short link.
+// The event is passed to the background goroutine, which saves the events in batches
+// with Storage.SaveClicks, so the redirect never waits for the storage.
+// If the channel is full, the event is dropped.
+// Example usage:
+//
+//	coder.RecordClick(models.Click{Code: code, Time: time.Now(), Referrer: req.Referer()})
+func (coder *Coder) RecordClick(click models.Click) {
+	select {
+	case coder.clicksChan <- click:
+	default:
+		// не блокируем редирект, если хранилище не успевает
+	}
+}
+
+func (coder *Coder) saveClicks() {
+	ticker := time.NewTicker(clicksFlushInterval)
+
+	var clicks []models.Click
+	flush := func() {
+		if len(clicks) == 0 {
+			return
+		}
+		if err := coder.storage.SaveClicks(context.TODO(), clicks); err != nil {
+			coder.logger.Error("save clicks", err)
+			// не копим события бесконечно, если хранилище недоступно
+			if len(clicks) < clicksBufferSize {
+				return
+			}
+		}
+		clicks = nil
+	}
+
+	for {
+		select {
+		case click := <-coder.clicksChan:
+			clicks = append(clicks, click)
+			if len(clicks) == clicksBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
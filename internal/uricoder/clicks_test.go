@@ -0,0 +1,26 @@
+package uricoder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestRecordClick(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	now := time.Now()
+	coder.RecordClick(models.Click{Code: "code1", Time: now, Referrer: "https://ya.ru", IP: "10.0.0.1"})
+	coder.RecordClick(models.Click{Code: "code1", Time: now})
+
+	// события сохраняются в фоне
+	require.Eventually(t, func() bool {
+		return s.ClicksLen() == 2
+	}, 3*clicksFlushInterval, 10*time.Millisecond)
+	assert.Contains(t, s.Clicks(), models.Click{Code: "code1", Time: now, Referrer: "https://ya.ru", IP: "10.0.0.1"})
+}
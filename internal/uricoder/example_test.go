@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/yury-kuznetsov/shortener/internal/models"
 	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
 )
 
@@ -11,7 +12,7 @@ func Example() {
 	s := memory.NewStorage()
 	coder := NewCoder(s)
 
-	code, _ := coder.ToCode(context.Background(), "https://ya.ru", 0)
+	code, _ := coder.ToCode(context.Background(), "https://ya.ru", 0, models.LinkOptions{})
 	uri, _ := coder.ToURI(context.Background(), code, 0)
 	fmt.Println(uri)
 
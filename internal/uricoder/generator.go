@@ -0,0 +1,171 @@
+package uricoder
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultAlphabet is the alphabet of the generated codes: latin letters and digits.
+const DefaultAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// DefaultCodeLength is the length of the generated random codes.
+const DefaultCodeLength = 8
+
+// CodeGenerator is an interface that defines a source of short codes for new links.
+// Generated codes are not required to be unique: the Coder retries when a code is already taken.
+type CodeGenerator interface {
+	Generate() (string, error)
+}
+
+// RandomGenerator generates codes of the fixed length from the given alphabet
+// using the cryptographically secure random number generator.
+type RandomGenerator struct {
+	alphabet string
+	length   int
+}
+
+// NewRandomGenerator creates a new instance of RandomGenerator.
+// The alphabet must consist of at least two distinct single-byte characters, the length must be positive.
+func NewRandomGenerator(alphabet string, length int) (*RandomGenerator, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, errors.New("code length must be positive")
+	}
+	return &RandomGenerator{alphabet: alphabet, length: length}, nil
+}
+
+// Generate returns a new random code.
+// It uses the rejection sampling, so every character of the alphabet is equally likely.
+func (g *RandomGenerator) Generate() (string, error) {
+	// отбрасываем байты, которые дали бы смещение распределения
+	limit := 256 - 256%len(g.alphabet)
+	code := make([]byte, 0, g.length)
+	buf := make([]byte, g.length)
+	for len(code) < g.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, g.alphabet[int(b)%len(g.alphabet)])
+			if len(code) == g.length {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// CounterGenerator generates codes by encoding a monotonically increasing counter in base62.
+// The codes are short and never repeat within a process; after a restart the counter
+// should start from a value greater than before (for example, the current time in nanoseconds).
+type CounterGenerator struct {
+	counter atomic.Uint64
+}
+
+// NewCounterGenerator creates a new instance of CounterGenerator starting from the given value.
+func NewCounterGenerator(start uint64) *CounterGenerator {
+	g := &CounterGenerator{}
+	g.counter.Store(start)
+	return g
+}
+
+// Generate returns the next value of the counter encoded in base62.
+func (g *CounterGenerator) Generate() (string, error) {
+	n := g.counter.Add(1)
+
+	var buf [11]byte // 62^11 > 2^64
+	i := len(buf)
+	for {
+		i--
+		buf[i] = DefaultAlphabet[n%62]
+		n /= 62
+		if n == 0 {
+			break
+		}
+	}
+	return string(buf[i:]), nil
+}
+
+// WordGenerator generates human-readable codes like "brave-otter-42".
+type WordGenerator struct{}
+
+// NewWordGenerator creates a new instance of WordGenerator.
+func NewWordGenerator() *WordGenerator {
+	return &WordGenerator{}
+}
+
+// Generate returns a new code made of a random adjective, a random noun and a random number.
+func (g *WordGenerator) Generate() (string, error) {
+	adjective, err := randomIndex(len(adjectives))
+	if err != nil {
+		return "", err
+	}
+	noun, err := randomIndex(len(nouns))
+	if err != nil {
+		return "", err
+	}
+	number, err := randomIndex(100)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-%02d", adjectives[adjective], nouns[noun], number), nil
+}
+
+// randomIndex returns a uniformly distributed random number in [0, n), n must not exceed 256.
+func randomIndex(n int) (int, error) {
+	limit := 256 - 256%n
+	var b [1]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		if int(b[0]) < limit {
+			return int(b[0]) % n, nil
+		}
+	}
+}
+
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("alphabet must contain at least two characters")
+	}
+	if len(alphabet) > 256 {
+		return errors.New("alphabet must not contain more than 256 characters")
+	}
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] >= 0x80 {
+			return errors.New("alphabet must contain only ASCII characters")
+		}
+		if strings.IndexByte(alphabet[i+1:], alphabet[i]) >= 0 {
+			return fmt.Errorf("alphabet contains duplicate character %q", alphabet[i])
+		}
+	}
+	return nil
+}
+
+var adjectives = []string{
+	"able", "bold", "brave", "bright", "calm", "clever", "cool", "cosy",
+	"crisp", "eager", "early", "fair", "fancy", "fast", "fresh", "gentle",
+	"glad", "golden", "grand", "green", "happy", "honest", "jolly", "keen",
+	"kind", "lively", "lucky", "merry", "mighty", "modern", "neat", "noble",
+	"polite", "proud", "quick", "quiet", "rapid", "ready", "rich", "royal",
+	"shiny", "silent", "simple", "smart", "smooth", "solid", "sunny", "super",
+	"swift", "tidy", "tiny", "vivid", "warm", "wise", "witty", "young",
+}
+
+var nouns = []string{
+	"badger", "bear", "beaver", "bee", "bison", "cat", "crane", "deer",
+	"dolphin", "eagle", "falcon", "fox", "frog", "goose", "hare", "hawk",
+	"hedgehog", "heron", "horse", "koala", "lion", "lynx", "moose", "otter",
+	"owl", "panda", "parrot", "pelican", "penguin", "puma", "rabbit", "raven",
+	"robin", "salmon", "seal", "shark", "sparrow", "squirrel", "swan", "tiger",
+	"trout", "turtle", "walrus", "whale", "wolf", "wombat", "yak", "zebra",
+}
@@ -0,0 +1,159 @@
+package uricoder
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+// fixedGenerator returns the codes from the list in turn, repeating the last one.
+type fixedGenerator struct {
+	codes []string
+	calls int
+}
+
+func (g *fixedGenerator) Generate() (string, error) {
+	code := g.codes[min(g.calls, len(g.codes)-1)]
+	g.calls++
+	return code, nil
+}
+
+func TestRandomGenerator(t *testing.T) {
+	g, err := NewRandomGenerator("abc", 12)
+	require.NoError(t, err)
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		code, err := g.Generate()
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^[abc]{12}$`), code)
+		seen[code] = struct{}{}
+	}
+	assert.Greater(t, len(seen), 90)
+
+	_, err = NewRandomGenerator("a", 8)
+	assert.Error(t, err)
+	_, err = NewRandomGenerator("abca", 8)
+	assert.Error(t, err)
+	_, err = NewRandomGenerator(DefaultAlphabet, 0)
+	assert.Error(t, err)
+}
+
+func TestCounterGenerator(t *testing.T) {
+	g := NewCounterGenerator(60)
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		code, err := g.Generate()
+		require.NoError(t, err)
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []string{"9", "ba", "bb"}, codes)
+}
+
+func TestWordGenerator(t *testing.T) {
+	code, err := NewWordGenerator().Generate()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z]+-[a-z]+-\d{2}$`), code)
+	assert.NoError(t, ValidateAlias(code))
+}
+
+func TestToCodeRetry(t *testing.T) {
+	s := memory.NewStorage()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.Set(ctx, models.Link{Code: "taken", URI: "https://google.com"})
+	require.NoError(t, err)
+
+	g := &fixedGenerator{codes: []string{"taken", "taken", "free"}}
+	coder := NewCoder(s, WithGenerator(g))
+	code, err := coder.ToCode(ctx, "https://ya.ru", 0, models.LinkOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "free", code)
+	assert.Equal(t, 3, g.calls)
+
+	g = &fixedGenerator{codes: []string{"taken"}}
+	coder = NewCoder(s, WithGenerator(g), WithMaxAttempts(5))
+	_, err = coder.ToCode(ctx, "https://ya.ru", 0, models.LinkOptions{})
+	var exhausted *CodeExhaustedError
+	require.ErrorAs(t, err, &exhausted)
+	assert.Equal(t, 5, exhausted.Attempts)
+	assert.Equal(t, 5, g.calls)
+}
+
+func TestToCodesRetry(t *testing.T) {
+	s := memory.NewStorage()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.Set(ctx, models.Link{Code: "taken", URI: "https://google.com"})
+	require.NoError(t, err)
+
+	// занятый код заменяется, остальные коды пакета сохраняются
+	g := &fixedGenerator{codes: []string{"code1", "taken", "code2"}}
+	coder := NewCoder(s, WithGenerator(g))
+	codes, err := coder.ToCodes(ctx, []models.LinkRequest{
+		{URI: "https://ya.ru"},
+		{URI: "https://site.com", LinkOptions: models.LinkOptions{Alias: "my-site"}},
+		{URI: "https://go.dev"},
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1", "my-site", "code2"}, codes)
+	assert.Equal(t, 3, g.calls)
+
+	// при ошибке ничего не сохраняется
+	g = &fixedGenerator{codes: []string{"code3"}}
+	coder = NewCoder(s, WithGenerator(g))
+	_, err = coder.ToCodes(ctx, []models.LinkRequest{
+		{URI: "https://ya.ru"},
+		{URI: "https://site.com", LinkOptions: models.LinkOptions{Alias: "my-site"}},
+	}, 1)
+	var batchErr *models.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+	_, err = s.Get(ctx, "code3", 1)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = coder.ToCodes(ctx, []models.LinkRequest{{URI: "https://ya.ru"}, {URI: "ya.ru"}}, 1)
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+}
+
+func TestToCodesPartial(t *testing.T) {
+	s := memory.NewStorage()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.Set(ctx, models.Link{Code: "taken", URI: "https://google.com"})
+	require.NoError(t, err)
+
+	g := &fixedGenerator{codes: []string{"code1", "taken", "code2"}}
+	coder := NewCoder(s, WithGenerator(g))
+	results, err := coder.ToCodesPartial(ctx, []models.LinkRequest{
+		{URI: "https://ya.ru"},
+		{URI: "ya.ru"},
+		{URI: "https://site.com", LinkOptions: models.LinkOptions{Alias: "taken"}},
+		{URI: "https://go.dev"},
+	}, 1)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, models.BatchResult{Code: "code1", Status: models.BatchStatusCreated}, results[0])
+	assert.Equal(t, models.BatchStatusInvalid, results[1].Status)
+	assert.Error(t, results[1].Err)
+	assert.Equal(t, models.BatchStatusConflict, results[2].Status)
+	assert.ErrorIs(t, results[2].Err, models.ErrCodeTaken)
+	assert.Equal(t, models.BatchResult{Code: "code2", Status: models.BatchStatusCreated}, results[3])
+
+	uri, err := s.Get(ctx, "code2", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", uri)
+}
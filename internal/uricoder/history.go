@@ -0,0 +1,128 @@
+package uricoder
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+const (
+	// DefaultHistoryLimit is the number of links on a page of the history, if the limit is not set.
+	DefaultHistoryLimit = 100
+	// MaxHistoryLimit is the maximal number of links on a page of the history.
+	MaxHistoryLimit = 1000
+)
+
+var (
+	// ErrIncorrectCursor is returned when the cursor of the history cannot be decoded.
+	ErrIncorrectCursor = models.NewError("incorrect cursor", models.ErrInvalidArgument)
+	// ErrIncorrectSort is returned when the sort order of the history is unknown.
+	ErrIncorrectSort = models.NewError("incorrect sort order", models.ErrInvalidArgument)
+	// ErrEmptyQuery is returned when the search query is empty.
+	ErrEmptyQuery = models.NewError("empty search query", models.ErrInvalidArgument)
+)
+
+// GetHistory returns a page of the history of URLs for a given user.
+// The query sets the order and the filters of the history (see models.HistoryQuery), its After field is ignored:
+// the position is set by the cursor, which is the NextCursor of the previous page (empty for the first page).
+// The zero limit means DefaultHistoryLimit, the limit greater than MaxHistoryLimit is reduced to it.
+// The tag of the query is normalized like the tags of the links (see NormalizeTags).
+// The returned page contains the links and the cursor of the next page, which is empty on the last page.
+// For an unknown sort order it returns ErrIncorrectSort, for a broken cursor it returns ErrIncorrectCursor.
+// Example usage:
+//
+//	page, err := coder.GetHistory(req.Context(), userID, models.HistoryQuery{Limit: 50}, cursor)
+//	if err != nil {
+//	    // handle error
+//	}
+//	if len(page.Links) == 0 {
+//	    // handle empty history
+//	}
+//	// process page.Links, pass page.NextCursor to get the next page
+func (coder *Coder) GetHistory(
+	ctx context.Context,
+	userID int,
+	query models.HistoryQuery,
+	cursor string,
+) (models.HistoryPage, error) {
+	switch query.SortBy {
+	case "":
+		query.SortBy = models.HistorySortCreated
+	case models.HistorySortCreated, models.HistorySortClicks:
+	default:
+		return models.HistoryPage{}, ErrIncorrectSort
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultHistoryLimit
+	}
+	query.Limit = min(query.Limit, MaxHistoryLimit)
+
+	query.Tag = strings.ToLower(strings.TrimSpace(query.Tag))
+	query.After = nil
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+		query.After = &after
+	}
+
+	// запрашиваем на одну ссылку больше, чтобы узнать, есть ли следующая страница
+	limit := query.Limit
+	query.Limit++
+	links, err := coder.storage.GetByUser(ctx, userID, query)
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+
+	page := models.HistoryPage{Links: links}
+	if len(links) > limit {
+		page.Links = links[:limit]
+		page.NextCursor = encodeCursor(page.Links[limit-1].Cursor())
+	}
+	return page, nil
+}
+
+// Search returns the links of the user whose code, destination URL or one of the tags contains the query
+// (case-insensitive), the newest first. The links have no titles or notes, so the tags are the only
+// user-given texts to search by. The leading and trailing spaces of the query are ignored; for an empty query
+// it returns ErrEmptyQuery. The limit is handled like in GetHistory.
+// Example usage:
+//
+//	links, err := coder.Search(req.Context(), userID, "pricing", 0)
+//	if err != nil {
+//	    // handle error
+//	}
+//	// process links
+func (coder *Coder) Search(ctx context.Context, userID int, query string, limit int) ([]models.UserLink, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	return coder.storage.Search(ctx, userID, query, min(limit, MaxHistoryLimit))
+}
+
+// encodeCursor encodes the position in the history into an opaque string.
+func encodeCursor(cursor models.HistoryCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes the position in the history encoded by encodeCursor.
+func decodeCursor(value string) (models.HistoryCursor, error) {
+	var cursor models.HistoryCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrIncorrectCursor
+	}
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.Code == "" {
+		return cursor, ErrIncorrectCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,86 @@
+package uricoder
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestGetHistory(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		code := "code" + strconv.Itoa(i)
+		_, err := s.Set(ctx, models.Link{Code: code, URI: "https://site" + strconv.Itoa(i) + ".com", UserID: 1})
+		require.NoError(t, err)
+	}
+	_, err := s.Set(ctx, models.Link{Code: "news", URI: "https://news.ya.ru/today", UserID: 1})
+	require.NoError(t, err)
+	_, err = s.Set(ctx, models.Link{Code: "other", URI: "https://ya.ru", UserID: 2})
+	require.NoError(t, err)
+	require.NoError(t, s.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}))
+	require.NoError(t, s.SaveClicks(ctx, []models.Click{
+		{Code: "code3", Time: time.Now()},
+		{Code: "code3", Time: time.Now()},
+		{Code: "news", Time: time.Now()},
+	}))
+
+	// страницы по две ссылки в порядке создания
+	var codes []string
+	cursor := ""
+	for {
+		page, err := coder.GetHistory(ctx, 1, models.HistoryQuery{Limit: 2}, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Links), 2)
+		for _, link := range page.Links {
+			codes = append(codes, link.Code)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"code0", "code1", "code2", "code3", "code4", "news"}, codes)
+
+	// сортировка по кликам
+	page, err := coder.GetHistory(ctx, 1, models.HistoryQuery{Limit: 2, SortBy: models.HistorySortClicks, Desc: true}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Links, 2)
+	assert.Equal(t, "code3", page.Links[0].Code)
+	assert.Equal(t, 2, page.Links[0].Clicks)
+	assert.Equal(t, "news", page.Links[1].Code)
+
+	page, err = coder.GetHistory(ctx, 1, models.HistoryQuery{SortBy: models.HistorySortClicks, Desc: true}, page.NextCursor)
+	require.NoError(t, err)
+	require.Len(t, page.Links, 4)
+	assert.Equal(t, "code4", page.Links[0].Code)
+	assert.Empty(t, page.NextCursor)
+
+	// фильтры по удалению и домену
+	deleted := true
+	page, err = coder.GetHistory(ctx, 1, models.HistoryQuery{Deleted: &deleted}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, "code1", page.Links[0].Code)
+
+	page, err = coder.GetHistory(ctx, 1, models.HistoryQuery{Domain: "YA.ru"}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, "news", page.Links[0].Code)
+
+	_, err = coder.GetHistory(ctx, 1, models.HistoryQuery{SortBy: "name"}, "")
+	assert.ErrorIs(t, err, ErrIncorrectSort)
+
+	_, err = coder.GetHistory(ctx, 1, models.HistoryQuery{}, "broken")
+	assert.ErrorIs(t, err, ErrIncorrectCursor)
+}
@@ -0,0 +1,76 @@
+package uricoder
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+// ErrRevisionNotFound is returned when the link has no revision with the requested number.
+var ErrRevisionNotFound = models.NewError("revision not found", models.ErrNotFound)
+
+// Update sets the new destination of the link with the given code owned by the user
+// and returns the new revision of the link. It returns models.ErrInvalidURL if the URI is incorrect.
+// The storage returns models.ErrNotFound for an unknown code, models.ErrForbidden if the link belongs
+// to another user and models.ErrDeleted if the link was deleted.
+// Example usage:
+//
+//	revision, err := coder.Update(ctx, code, userID, "https://site.com/new")
+//	if err != nil {
+//	    // handle error
+//	}
+//	fmt.Println("Revision:", revision.Number)
+func (coder *Coder) Update(ctx context.Context, code string, userID int, uri string) (models.Revision, error) {
+	if _, err := url.ParseRequestURI(uri); err != nil {
+		return models.Revision{}, models.ErrInvalidURL
+	}
+	return coder.storage.Update(ctx, code, userID, uri, nil)
+}
+
+// UpdateWithTags sets the new destination and replaces the tags of the link like Update and SetTags,
+// but in a single change of the storage: either both are applied or none of them.
+// It returns models.ErrInvalidURL if the URI is incorrect and ErrIncorrectTags if the tags are not valid
+// (see NormalizeTags), the storage errors are the same as for Update.
+// Example usage:
+//
+//	revision, err := coder.UpdateWithTags(ctx, code, userID, "https://site.com/new", []string{"spring-sale"})
+func (coder *Coder) UpdateWithTags(ctx context.Context, code string, userID int, uri string, tags []string) (models.Revision, error) {
+	if _, err := url.ParseRequestURI(uri); err != nil {
+		return models.Revision{}, models.ErrInvalidURL
+	}
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	return coder.storage.Update(ctx, code, userID, uri, &tags)
+}
+
+// GetRevisions returns the revisions of the link with the given code owned by the user, the oldest first.
+// Example usage:
+//
+//	revisions, err := coder.GetRevisions(ctx, code, userID)
+func (coder *Coder) GetRevisions(ctx context.Context, code string, userID int) ([]models.Revision, error) {
+	return coder.storage.GetRevisions(ctx, code, userID)
+}
+
+// Rollback restores the destination of the link from the revision with the given number.
+// The history is never rewritten: the destination is set by a new revision, which is returned.
+// If the revision is the current one, nothing is changed and the current revision is returned.
+// It returns ErrRevisionNotFound if the link has no such revision.
+// Example usage:
+//
+//	revision, err := coder.Rollback(ctx, code, userID, 1)
+func (coder *Coder) Rollback(ctx context.Context, code string, userID int, number int) (models.Revision, error) {
+	revisions, err := coder.storage.GetRevisions(ctx, code, userID)
+	if err != nil {
+		return models.Revision{}, err
+	}
+	if number < 1 || number > len(revisions) {
+		return models.Revision{}, ErrRevisionNotFound
+	}
+	if number == len(revisions) {
+		return revisions[number-1], nil
+	}
+	return coder.storage.Update(ctx, code, userID, revisions[number-1].URI, nil)
+}
@@ -0,0 +1,56 @@
+package uricoder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestRevisions(t *testing.T) {
+	coder := NewCoder(memory.NewStorage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code, err := coder.ToCode(ctx, "https://site.com/v1", 1, models.LinkOptions{})
+	require.NoError(t, err)
+
+	_, err = coder.Update(ctx, code, 1, "not a url")
+	assert.ErrorIs(t, err, models.ErrInvalidURL)
+
+	revision, err := coder.Update(ctx, code, 1, "https://site.com/v2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, revision.Number)
+
+	// откат добавляет новую ревизию, история не переписывается
+	revision, err = coder.Rollback(ctx, code, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, revision.Number)
+	assert.Equal(t, "https://site.com/v1", revision.URI)
+
+	uri, err := coder.ToURI(ctx, code, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/v1", uri)
+
+	// откат к текущей ревизии ничего не меняет
+	revision, err = coder.Rollback(ctx, code, 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, revision.Number)
+
+	revisions, err := coder.GetRevisions(ctx, code, 1)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, []string{"https://site.com/v1", "https://site.com/v2", "https://site.com/v1"},
+		[]string{revisions[0].URI, revisions[1].URI, revisions[2].URI})
+
+	_, err = coder.Rollback(ctx, code, 1, 4)
+	assert.ErrorIs(t, err, ErrRevisionNotFound)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	_, err = coder.Rollback(ctx, code, 2, 1)
+	assert.ErrorIs(t, err, models.ErrForbidden)
+}
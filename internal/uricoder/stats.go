@@ -0,0 +1,47 @@
+package uricoder
+
+import (
+	"context"
+	"time"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+const (
+	// DefaultStatsPeriod is the time range of the link statistics, if the start of the range is not set.
+	DefaultStatsPeriod = 30 * 24 * time.Hour
+	// statsTopSize is the number of the most frequent referrers and user agents in the link statistics
+	// and the number of the top users in the statistics of the storage.
+	statsTopSize = 10
+	// statsDays is the number of the last days (including today) of the links created per day
+	// in the statistics of the storage.
+	statsDays = 30
+)
+
+// ErrIncorrectRange is returned when the start of the time range of the statistics is not before its end.
+var ErrIncorrectRange = models.NewError("incorrect time range", models.ErrInvalidArgument)
+
+// GetLinkStats returns the statistics of the clicks of the link with the given code in the time range [from, to).
+// The zero end of the range means the current time, the zero start means DefaultStatsPeriod before the end.
+// Only the owner of the link can see its statistics: the storage returns models.ErrForbidden for other users
+// and models.ErrNotFound for an unknown code. If the range is empty, ErrIncorrectRange is returned.
+// Example usage:
+//
+//	stats, err := coder.GetLinkStats(ctx, code, userID, time.Time{}, time.Time{})
+//	if err != nil {
+//	    // handle error
+//	}
+//	fmt.Println("Clicks:", stats.Clicks)
+func (coder *Coder) GetLinkStats(ctx context.Context, code string, userID int, from, to time.Time) (models.LinkStats, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-DefaultStatsPeriod)
+	}
+	if !from.Before(to) {
+		return models.LinkStats{}, ErrIncorrectRange
+	}
+
+	return coder.storage.GetLinkStats(ctx, code, userID, from, to, statsTopSize)
+}
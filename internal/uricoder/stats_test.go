@@ -0,0 +1,71 @@
+package uricoder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestGetLinkStats(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := s.Set(ctx, models.Link{Code: "code1", URI: "https://google.com", UserID: 1})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	err = s.SaveClicks(ctx, []models.Click{
+		{Code: "code1", Time: day.Add(10 * time.Minute), Referrer: "https://ya.ru", UserAgent: "curl/8.0", IP: "10.0.0.1"},
+		{Code: "code1", Time: day.Add(20 * time.Minute), Referrer: "https://ya.ru", UserAgent: "curl/8.0", IP: "10.0.0.1"},
+		{Code: "code1", Time: day.Add(90 * time.Minute), Referrer: "https://google.com", UserAgent: "Mozilla/5.0", IP: "10.0.0.2"},
+		{Code: "code1", Time: day.Add(25 * time.Hour), UserAgent: "curl/8.0", IP: "10.0.0.2"},
+		// за пределами диапазона
+		{Code: "code1", Time: day.Add(-time.Minute), Referrer: "https://old.com"},
+	})
+	require.NoError(t, err)
+
+	stats, err := coder.GetLinkStats(ctx, "code1", 1, day, day.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Clicks)
+	assert.Equal(t, 3, stats.Visitors)
+	assert.Equal(t, []models.ClickCount{
+		{Time: day, Clicks: 3},
+		{Time: day.Add(24 * time.Hour), Clicks: 1},
+	}, stats.Daily)
+	assert.Equal(t, []models.ClickCount{
+		{Time: day, Clicks: 2},
+		{Time: day.Add(time.Hour), Clicks: 1},
+		{Time: day.Add(25 * time.Hour), Clicks: 1},
+	}, stats.Hourly)
+	assert.Equal(t, []models.TopValue{
+		{Value: "https://ya.ru", Clicks: 2},
+		{Value: "https://google.com", Clicks: 1},
+	}, stats.TopReferrers)
+	assert.Equal(t, []models.TopValue{
+		{Value: "curl/8.0", Clicks: 3},
+		{Value: "Mozilla/5.0", Clicks: 1},
+	}, stats.TopUserAgents)
+
+	// по умолчанию берутся последние DefaultStatsPeriod
+	stats, err = coder.GetLinkStats(ctx, "code1", 1, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Clicks)
+	assert.Equal(t, DefaultStatsPeriod, stats.To.Sub(stats.From))
+
+	_, err = coder.GetLinkStats(ctx, "code1", 2, time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+
+	_, err = coder.GetLinkStats(ctx, "unknown", 1, time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = coder.GetLinkStats(ctx, "code1", 1, day, day)
+	assert.ErrorIs(t, err, ErrIncorrectRange)
+}
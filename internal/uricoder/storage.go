@@ -2,16 +2,81 @@ package uricoder
 
 import (
 	"context"
+	"time"
 
 	"github.com/yury-kuznetsov/shortener/internal/models"
 )
 
 // Storage is an interface that defines methods for interacting with a storage system.
+// Get returns the URI of the link with the code: it returns models.ErrNotFound for an unknown code,
+// models.ErrDeleted for a soft deleted link and models.ErrExpired for an expired one.
+// GetLink returns the link with the code (with its expiration time) with the same errors as Get.
+// Set stores the link under its code and returns models.ErrCodeTaken if the code is already taken.
+// GetByUser returns the links of the user (including the soft deleted ones) selected by the query.
+// Search returns up to limit links of the user (the newest first) whose code, URI or tag contains the query.
+// SetTags replaces the tags of the link owned by the user: it returns models.ErrNotFound
+// if the code is unknown and models.ErrForbidden if the link belongs to another user.
+// GetTagCounts returns the number of links of the user per tag, the most frequent tags first.
+// Update sets the new destination of the link owned by the user and returns the new revision of the link;
+// if the tags are not nil, they replace the tags of the link in the same change (all or nothing).
+// GetRevisions returns the revisions of the link owned by the user, the oldest first.
+// Both return models.ErrNotFound if the code is unknown and models.ErrForbidden if the link belongs
+// to another user; Update also returns models.ErrDeleted for a soft deleted link.
+// A link whose destination has been changed is no longer found by its URI: Set and SetBatch
+// do not return models.ErrURIExists for it.
+// Restore clears the deleted flag of the links from the messages, which belong to the user from the message
+// and were deleted at or after deletedSince, and returns their codes; other codes are ignored.
+// SaveClicks stores the click events of the links.
+// CreateUser stores a new user and returns its ID, which is never less than models.FirstUserID:
+// it returns models.ErrLoginTaken if the login is already registered.
+// GetUser returns the user with the login or models.ErrNotFound.
+// CreateAnonymousUser stores an anonymous user, which is valid until expiresAt, and returns its ID.
+// ClaimLinks gives all the links of the anonymous user to the user and removes the anonymous user at once,
+// it returns the number of the given links or models.ErrNotFound if the anonymous user is unknown or expired.
+// PurgeAnonymousUsers removes the anonymous users expired at the moment now (but not their links)
+// and returns their number.
+// CreateAPIKey stores an API key, it returns models.ErrConflict if the ID or the hash is taken.
+// GetAPIKeys returns the API keys of the user, the oldest first.
+// GetAPIKeyByHash returns the API key with the hash or models.ErrNotFound.
+// DeleteAPIKey removes the API key of the user: it returns models.ErrNotFound if the key is unknown
+// and models.ErrForbidden if the key belongs to another user.
+// GetLinkStats returns the statistics of the clicks of the link in the time range [from, to) with up to top
+// most frequent referrers and user agents: it returns models.ErrNotFound if the code is unknown
+// and models.ErrForbidden if the link belongs to another user.
+// GetStats returns the statistics of the storage with the links created per day since the given time
+// and up to top users with the most links.
+// SetBatch stores all the links or none of them: if one of the links cannot be stored,
+// it returns *models.BatchError with the index of that link.
 type Storage interface {
 	Get(ctx context.Context, code string, userID int) (string, error)
-	Set(ctx context.Context, uri string, userID int) (string, error)
-	GetByUser(ctx context.Context, userID int) ([]models.GetByUserResponse, error)
+	GetLink(ctx context.Context, code string) (models.Link, error)
+	Set(ctx context.Context, link models.Link) (string, error)
+	SetBatch(ctx context.Context, links []models.Link) ([]string, error)
+	GetByUser(ctx context.Context, userID int, query models.HistoryQuery) ([]models.UserLink, error)
+	Search(ctx context.Context, userID int, query string, limit int) ([]models.UserLink, error)
+	SetTags(ctx context.Context, code string, userID int, tags []string) error
+	GetTagCounts(ctx context.Context, userID int) ([]models.TagCount, error)
+	Update(ctx context.Context, code string, userID int, uri string, tags *[]string) (models.Revision, error)
+	GetRevisions(ctx context.Context, code string, userID int) ([]models.Revision, error)
 	SoftDelete(ctx context.Context, messages []models.RmvUrlsMsg) error
+	Restore(ctx context.Context, messages []models.RmvUrlsMsg, deletedSince time.Time) ([]string, error)
+	SaveClicks(ctx context.Context, clicks []models.Click) error
+	GetLinkStats(ctx context.Context, code string, userID int, from, to time.Time, top int) (models.LinkStats, error)
 	HealthCheck(ctx context.Context) error
-	GetStats(ctx context.Context) (int, int, error)
+	GetStats(ctx context.Context, since time.Time, top int) (models.StorageStats, error)
+	CreateUser(ctx context.Context, login string, passwordHash string) (int, error)
+	GetUser(ctx context.Context, login string) (models.User, error)
+	CreateAnonymousUser(ctx context.Context, uuid string, expiresAt time.Time) (int, error)
+	ClaimLinks(ctx context.Context, anonymousID int, userID int) (int, error)
+	PurgeAnonymousUsers(ctx context.Context, now time.Time) (int, error)
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+	GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string, userID int) error
+}
+
+// CacheStatsProvider is an optional interface of a Storage wrapped with a cache.
+// If the storage implements it, the cache counters are included in the statistics.
+type CacheStatsProvider interface {
+	CacheStats() models.CacheStats
 }
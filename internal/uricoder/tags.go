@@ -0,0 +1,65 @@
+package uricoder
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+)
+
+const (
+	tagsMaxCount = 20
+	tagMaxLength = 64
+)
+
+// ErrIncorrectTags is returned when a link has too many tags, or a tag is too long
+// or contains control characters.
+var ErrIncorrectTags = models.NewError("incorrect tags", models.ErrInvalidArgument)
+
+// NormalizeTags returns the tags trimmed, lower-cased, without empty and repeated ones and sorted,
+// so that "Campaign " and "campaign" are the same tag. It returns ErrIncorrectTags for incorrect tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > tagMaxLength || strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+			return nil, ErrIncorrectTags
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > tagsMaxCount {
+		return nil, ErrIncorrectTags
+	}
+	return normalized, nil
+}
+
+// SetTags replaces the tags of the link with the given code owned by the user.
+// The tags are normalized by NormalizeTags, an empty list removes all the tags.
+// The storage returns models.ErrNotFound for an unknown code and models.ErrForbidden
+// if the link belongs to another user.
+// Example usage:
+//
+//	err := coder.SetTags(ctx, code, userID, []string{"spring-sale", "shoes"})
+func (coder *Coder) SetTags(ctx context.Context, code string, userID int, tags []string) error {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	return coder.storage.SetTags(ctx, code, userID, tags)
+}
+
+// GetTagCounts returns the number of links of the user per tag, the most frequent tags first.
+// Example usage:
+//
+//	counts, err := coder.GetTagCounts(ctx, userID)
+func (coder *Coder) GetTagCounts(ctx context.Context, userID int) ([]models.TagCount, error) {
+	return coder.storage.GetTagCounts(ctx, userID)
+}
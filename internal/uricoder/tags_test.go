@@ -0,0 +1,88 @@
+package uricoder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "nil", tags: nil, want: []string{}},
+		{name: "trim and case", tags: []string{" Spring-Sale ", "shoes", "spring-sale"}, want: []string{"shoes", "spring-sale"}},
+		{name: "empty tags", tags: []string{"", "  "}, want: []string{}},
+		{name: "unicode", tags: []string{"Обувь"}, want: []string{"обувь"}},
+		{name: "too long", tags: []string{strings.Repeat("a", tagMaxLength+1)}, wantErr: true},
+		{name: "control characters", tags: []string{"new\nline"}, wantErr: true},
+		{name: "too many", tags: strings.Fields("a b c d e f g h i j k l m n o p q r s t u"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTags(tt.tags)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrIncorrectTags)
+				assert.ErrorIs(t, err, models.ErrInvalidArgument)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// теги нормализуются при создании ссылки
+	code1, err := coder.ToCode(ctx, "https://site.com/shoes", 1, models.LinkOptions{Tags: []string{"Shoes", "spring"}})
+	require.NoError(t, err)
+	code2, err := coder.ToCode(ctx, "https://site.com/hats", 1, models.LinkOptions{Tags: []string{"spring"}})
+	require.NoError(t, err)
+	_, err = coder.ToCode(ctx, "https://site.com/bad", 1, models.LinkOptions{Tags: []string{"bad\ttag"}})
+	assert.ErrorIs(t, err, ErrIncorrectTags)
+
+	page, err := coder.GetHistory(ctx, 1, models.HistoryQuery{Tag: " SPRING"}, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Links, 2)
+
+	page, err = coder.GetHistory(ctx, 1, models.HistoryQuery{Tag: "shoes"}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, code1, page.Links[0].Code)
+	assert.Equal(t, []string{"shoes", "spring"}, page.Links[0].Tags)
+
+	// замена тегов
+	require.NoError(t, coder.SetTags(ctx, code2, 1, []string{"Hats", "summer"}))
+	err = coder.SetTags(ctx, code2, 2, []string{"hats"})
+	assert.ErrorIs(t, err, models.ErrForbidden)
+	err = coder.SetTags(ctx, "unknown", 1, []string{"hats"})
+	assert.ErrorIs(t, err, models.ErrNotFound)
+
+	counts, err := coder.GetTagCounts(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagCount{
+		{Tag: "hats", Links: 1},
+		{Tag: "shoes", Links: 1},
+		{Tag: "spring", Links: 1},
+		{Tag: "summer", Links: 1},
+	}, counts)
+
+	counts, err = coder.GetTagCounts(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
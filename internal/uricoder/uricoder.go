@@ -6,24 +6,103 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/yury-kuznetsov/shortener/internal/logger"
 	"github.com/yury-kuznetsov/shortener/internal/models"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// DefaultMaxAttempts is the number of codes the Coder tries before it gives up with CodeExhaustedError.
+const DefaultMaxAttempts = 10
+
+// DefaultRestorePeriod is the time after the deletion during which a deleted link can be restored.
+const DefaultRestorePeriod = 7 * 24 * time.Hour
+
+// DefaultAnonymousLifetime is the time during which an anonymous user is valid.
+const DefaultAnonymousLifetime = 30 * 24 * time.Hour
+
+// Option is a function that configures the Coder in NewCoder.
+type Option func(*Coder)
+
+// WithGenerator sets the generator of short codes.
+// By default, the Coder uses RandomGenerator with DefaultAlphabet and DefaultCodeLength.
+func WithGenerator(g CodeGenerator) Option {
+	return func(coder *Coder) {
+		coder.generator = g
+	}
+}
+
+// WithMaxAttempts sets the number of generated codes the Coder tries, if the previous ones are already taken.
+func WithMaxAttempts(n int) Option {
+	return func(coder *Coder) {
+		coder.maxAttempts = n
+	}
+}
+
+// WithRestorePeriod sets the time after the deletion during which a deleted link can be restored
+// (see Coder.Restore). A zero or negative period means the deleted links cannot be restored.
+func WithRestorePeriod(period time.Duration) Option {
+	return func(coder *Coder) {
+		coder.restorePeriod = period
+	}
+}
+
+// WithAnonymousLifetime sets the time during which an anonymous user is valid (see Coder.CreateAnonymous).
+// A zero or negative lifetime means the default one.
+func WithAnonymousLifetime(lifetime time.Duration) Option {
+	return func(coder *Coder) {
+		if lifetime > 0 {
+			coder.anonymousLifetime = lifetime
+		}
+	}
+}
+
+// ErrIncorrectExpiration is returned when the expiration time of a new link is already in the past.
+var ErrIncorrectExpiration = models.NewError("incorrect expiration time", models.ErrInvalidArgument)
+
+// CodeExhaustedError is returned by ToCode when every generated code turned out to be taken.
+// It usually means that the code space of the generator is nearly full and the code length should be increased.
+type CodeExhaustedError struct {
+	Attempts int
+}
+
+// Error returns the text of the error.
+func (e *CodeExhaustedError) Error() string {
+	return fmt.Sprintf("no free short code after %d attempts", e.Attempts)
+}
+
 // NewCoder initializes a new instance of the Coder struct with the provided Storage implementation.
 // It creates a new Coder instance and sets the storage field to the provided Storage implementation.
-// It also creates a new channel rmvUrlsChan with a buffer size of 1024 and assigns it to the rmvUrlsChan field.
-// It then starts a goroutine to handle the rmvUrls channel.
+// It also creates a new channel rmvUrlsChan with a buffer size of 1024 and assigns it to the rmvUrlsChan field,
+// and the channel of click events with a buffer size of clicksBufferSize.
+// The errors of the background tasks are written to the log (see logger.Logger.Error).
+// The options are applied after the defaults are set.
+// It then starts goroutines to handle the rmvUrls channel and the clicks channel
+// and to purge the expired anonymous users.
 // The NewCoder function returns the new instance of the Coder struct.
-func NewCoder(s Storage) *Coder {
+func NewCoder(s Storage, opts ...Option) *Coder {
+	generator, _ := NewRandomGenerator(DefaultAlphabet, DefaultCodeLength)
 	instance := &Coder{
-		storage:     s,
-		rmvUrlsChan: make(chan models.RmvUrlsMsg, 1024),
+		storage:           s,
+		generator:         generator,
+		maxAttempts:       DefaultMaxAttempts,
+		restorePeriod:     DefaultRestorePeriod,
+		anonymousLifetime: DefaultAnonymousLifetime,
+		passwordCost:      bcrypt.DefaultCost,
+		rmvUrlsChan:       make(chan models.RmvUrlsMsg, 1024),
+		clicksChan:        make(chan models.Click, clicksBufferSize),
+		logger:            logger.NewLogger(),
+	}
+	for _, opt := range opts {
+		opt(instance)
 	}
 
 	go instance.rmvUrls()
+	go instance.saveClicks()
+	go instance.purgeAnonymous()
 
 	return instance
 }
@@ -33,8 +112,18 @@ func NewCoder(s Storage) *Coder {
 // Declaration:
 //
 //	type Coder struct {
-//	    storage     Storage
-//	    rmvUrlsChan chan models.RmvUrlsMsg
+//	    storage           Storage
+//	    generator         CodeGenerator
+//	    maxAttempts       int
+//	    restorePeriod     time.Duration
+//	    anonymousLifetime time.Duration
+//	    passwordCost      int
+//	    rmvUrlsChan       chan models.RmvUrlsMsg
+//	    clicksChan        chan models.Click
+//	    pendingDeletions  atomic.Int64
+//	    dummyHash         []byte
+//	    dummyHashOnce     sync.Once
+//	    logger            *logger.Logger
 //	}
 //
 // Usage Example 1:
@@ -47,7 +136,7 @@ func NewCoder(s Storage) *Coder {
 //
 // Usage Example 3:
 //
-//	code, err := c.ToCode(ctx, uri, userID)
+//	code, err := c.ToCode(ctx, uri, userID, opts)
 //
 // Usage Example 4:
 //
@@ -67,6 +156,7 @@ func NewCoder(s Storage) *Coder {
 //
 // Related Declarations:
 // - Storage: an interface for data storage
+// - CodeGenerator: an interface for short code generation
 //
 // Related structs:
 // - models.RmvUrlsMsg: a struct representing a message for removing URLs
@@ -77,55 +167,225 @@ func NewCoder(s Storage) *Coder {
 // - GetHistory: retrieves the history of URLs for the provided user ID
 // - HealthCheck: checks the health of the storage
 // - DeleteUrls: deletes multiple URLs for the provided codes and user ID
+// - Restore: restores the recently deleted URLs for the provided codes and user ID
+// - Register, Login: create and authenticate the registered users
+// - CreateAnonymous, Claim: create the anonymous users and give their links to the registered ones
 // - rmvUrls: removes URLs from the storage based on messages received through the rmvUrlsChan channel
+// - RecordClick: records a redirect by a short link in the background
 type Coder struct {
-	storage     Storage
-	rmvUrlsChan chan models.RmvUrlsMsg
+	storage           Storage
+	generator         CodeGenerator
+	maxAttempts       int
+	restorePeriod     time.Duration
+	anonymousLifetime time.Duration
+	passwordCost      int
+	rmvUrlsChan       chan models.RmvUrlsMsg
+	clicksChan        chan models.Click
+	// число сообщений об удалении, ещё не записанных в хранилище (в канале и в пакете rmvUrls)
+	pendingDeletions atomic.Int64
+	// хеш, с которым Login сравнивает пароль неизвестного логина (см. passwordDummyHash)
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+	// журнал ошибок фоновых задач, которым некому вернуть ошибку
+	logger *logger.Logger
 }
 
 // ToURI returns the URI associated with the given code and user ID.
 // It retrieves the URI from the storage using the provided context,
-// and returns an error if the code is not found or if there is an error
-// retrieving the URI from the storage.
+// and returns models.ErrNotFound if the code is not found or the error
+// retrieving the URI from the storage. For an expired link the storage returns models.ErrExpired.
 func (coder *Coder) ToURI(ctx context.Context, code string, userID int) (string, error) {
 	uri, err := coder.storage.Get(ctx, code, userID)
 	if err != nil {
 		return "", err
 	}
 	if uri == "" {
-		return "", errors.New("URI not found")
+		return "", models.ErrNotFound
 	}
 	return uri, nil
 }
 
 // ToCode returns the code associated with the given URI and user ID.
-// It parses the URI using the url.ParseRequestURI method and returns an error
-// if the URI is incorrect. Otherwise, it sets the URI in the storage using
-// the provided context and user ID.
-func (coder *Coder) ToCode(ctx context.Context, uri string, userID int) (string, error) {
-	_, err := url.ParseRequestURI(uri)
+// It parses the URI using the url.ParseRequestURI method and returns models.ErrInvalidURL
+// if the URI is incorrect. It also returns ErrIncorrectExpiration if the expiration time from
+// the options is already in the past, ErrIncorrectAlias if the alias is not valid (see ValidateAlias)
+// or ErrIncorrectTags if the tags are not valid (see NormalizeTags).
+// Otherwise, it sets the URI in the storage using the provided context, user ID and options.
+// If the alias is already taken, the storage returns models.ErrCodeTaken.
+// Without an alias the code is produced by the generator; taken codes are replaced by new ones
+// up to maxAttempts times, after that CodeExhaustedError is returned.
+func (coder *Coder) ToCode(ctx context.Context, uri string, userID int, opts models.LinkOptions) (string, error) {
+	link, err := coder.newLink(uri, userID, opts)
 	if err != nil {
-		return "", errors.New("incorrect URI")
+		return "", err
 	}
-	return coder.storage.Set(ctx, uri, userID)
+	if link.Code != "" {
+		return coder.storage.Set(ctx, link)
+	}
+
+	for attempt := 0; attempt < coder.maxAttempts; attempt++ {
+		link.Code, err = coder.generator.Generate()
+		if err != nil {
+			return "", err
+		}
+		code, err := coder.storage.Set(ctx, link)
+		if !errors.Is(err, models.ErrCodeTaken) {
+			return code, err
+		}
+	}
+
+	return "", &CodeExhaustedError{Attempts: coder.maxAttempts}
 }
 
-// GetHistory returns the history of URLs for a given user.
-// It retrieves the URLs from the storage using the provided context and user ID.
-// It returns a slice of models.GetByUserResponse, which contains the short URL and original URL.
-// If there is an error retrieving the history or if the history is empty, it returns an error.
+// ToCodes returns the codes of the given links of the user in the order of the links.
+// The links are validated like in ToCode and stored with a single call of Storage.SetBatch,
+// so either all of them are stored or none. If a link is not valid or cannot be stored,
+// *models.BatchError with the index of the link is returned.
+// If some generated codes are already taken, they are replaced by new ones and the batch is retried
+// up to maxAttempts times, after that CodeExhaustedError is returned.
 // Example usage:
 //
-//	data, err := coder.GetHistory(req.Context(), userID)
-//	if err != nil {
-//	    // handle error
-//	}
-//	if len(data) == 0 {
-//	    // handle empty history
-//	}
-//	// process data
-func (coder *Coder) GetHistory(ctx context.Context, userID int) ([]models.GetByUserResponse, error) {
-	return coder.storage.GetByUser(ctx, userID)
+//	codes, err := coder.ToCodes(ctx, []models.LinkRequest{{URI: "https://site.com"}}, userID)
+func (coder *Coder) ToCodes(ctx context.Context, requests []models.LinkRequest, userID int) ([]string, error) {
+	links := make([]models.Link, 0, len(requests))
+	for i, request := range requests {
+		link, err := coder.newLink(request.URI, userID, request.LinkOptions)
+		if err != nil {
+			return nil, &models.BatchError{Index: i, Err: err}
+		}
+		links = append(links, link)
+	}
+	if len(links) == 0 {
+		return []string{}, nil
+	}
+
+	for attempt := 0; attempt < coder.maxAttempts; attempt++ {
+		for i := range links {
+			if links[i].Code != "" {
+				continue
+			}
+			code, err := coder.generator.Generate()
+			if err != nil {
+				return nil, err
+			}
+			links[i].Code = code
+		}
+
+		codes, err := coder.storage.SetBatch(ctx, links)
+		var batchErr *models.BatchError
+		if !errors.As(err, &batchErr) || !errors.Is(err, models.ErrCodeTaken) || requests[batchErr.Index].Alias != "" {
+			return codes, err
+		}
+		// занятый код заменим на новый при следующей попытке
+		links[batchErr.Index].Code = ""
+	}
+
+	return nil, &CodeExhaustedError{Attempts: coder.maxAttempts}
+}
+
+// ToCodesPartial stores the valid links of the given batch and reports the result of every link
+// in the order of the links, instead of failing the whole batch like ToCodes.
+// An invalid link gets models.BatchStatusInvalid, a link whose URI is already shortened
+// or whose alias is already taken gets models.BatchStatusConflict (with the existing code for the URI),
+// other links are stored with a single call of Storage.SetBatch and get models.BatchStatusCreated.
+// If the storage rejects a link, the link is excluded and the rest of the batch is stored again.
+// Taken generated codes are replaced by new ones up to maxAttempts times in total, after that
+// CodeExhaustedError is returned. Other storage errors are returned as is.
+// Example usage:
+//
+//	results, err := coder.ToCodesPartial(ctx, []models.LinkRequest{{URI: "https://site.com"}}, userID)
+func (coder *Coder) ToCodesPartial(ctx context.Context, requests []models.LinkRequest, userID int) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(requests))
+	links := make([]models.Link, 0, len(requests))
+	indexes := make([]int, 0, len(requests))
+	for i, request := range requests {
+		link, err := coder.newLink(request.URI, userID, request.LinkOptions)
+		if err != nil {
+			results[i] = models.BatchResult{Status: models.BatchStatusInvalid, Err: err}
+			continue
+		}
+		links = append(links, link)
+		indexes = append(indexes, i)
+	}
+
+	regenerated := 0
+	for len(links) > 0 {
+		for j := range links {
+			if links[j].Code != "" {
+				continue
+			}
+			code, err := coder.generator.Generate()
+			if err != nil {
+				return nil, err
+			}
+			links[j].Code = code
+		}
+
+		codes, err := coder.storage.SetBatch(ctx, links)
+		if err == nil {
+			for j, code := range codes {
+				results[indexes[j]] = models.BatchResult{Code: code, Status: models.BatchStatusCreated}
+			}
+			break
+		}
+		var batchErr *models.BatchError
+		if !errors.As(err, &batchErr) {
+			return nil, err
+		}
+
+		j, i := batchErr.Index, indexes[batchErr.Index]
+		switch {
+		case errors.Is(err, models.ErrCodeTaken) && requests[i].Alias == "":
+			// занятый код заменим на новый при следующей попытке
+			if regenerated++; regenerated >= coder.maxAttempts {
+				return nil, &CodeExhaustedError{Attempts: regenerated}
+			}
+			links[j].Code = ""
+			continue
+		case errors.Is(err, models.ErrConflict):
+			results[i] = models.BatchResult{Code: batchErr.Code, Status: models.BatchStatusConflict, Err: batchErr.Err}
+		default:
+			results[i] = models.BatchResult{Status: models.BatchStatusInvalid, Err: batchErr.Err}
+		}
+		links = append(links[:j], links[j+1:]...)
+		indexes = append(indexes[:j], indexes[j+1:]...)
+	}
+
+	// URI, повторенный в самом пакете, конфликтует с уже сохраненной ссылкой из этого пакета
+	for i := range results {
+		if results[i].Code != "" || !errors.Is(results[i].Err, models.ErrURIExists) {
+			continue
+		}
+		for k := range results {
+			if results[k].Status == models.BatchStatusCreated && requests[k].URI == requests[i].URI {
+				results[i].Code = results[k].Code
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// newLink validates the URI and the options and returns the link to be stored.
+// The code of the link is the alias, if it is set, or empty.
+func (coder *Coder) newLink(uri string, userID int, opts models.LinkOptions) (models.Link, error) {
+	if _, err := url.ParseRequestURI(uri); err != nil {
+		return models.Link{}, models.ErrInvalidURL
+	}
+	if models.Expired(opts.ExpiresAt, time.Now()) {
+		return models.Link{}, ErrIncorrectExpiration
+	}
+	if opts.Alias != "" {
+		if err := ValidateAlias(opts.Alias); err != nil {
+			return models.Link{}, err
+		}
+	}
+	tags, err := NormalizeTags(opts.Tags)
+	if err != nil {
+		return models.Link{}, err
+	}
+	return models.Link{Code: opts.Alias, URI: uri, UserID: userID, ExpiresAt: opts.ExpiresAt, Tags: tags}, nil
 }
 
 // HealthCheck checks the health of the storage.
@@ -144,30 +404,90 @@ func (coder *Coder) HealthCheck(ctx context.Context) error {
 	return coder.storage.HealthCheck(ctx)
 }
 
-// GetStats returns the total number of URLs and users in the storage.
-// It retrieves the statistics from the storage using the provided context,
-// and returns the number of URLs, number of users, and an error if there is any.
+// GetStats returns the statistics for the operators.
+// It retrieves the statistics of the storage (with the links created per day for the last statsDays days
+// and statsTopSize top users) and adds the depth of the deletion queue and the cache counters, if any.
 // Example usage:
 //
-//	urls, users, err := coder.GetStats(ctx)
-func (coder *Coder) GetStats(ctx context.Context) (int, int, error) {
-	return coder.storage.GetStats(ctx)
+//	stats, err := coder.GetStats(ctx)
+//	if err != nil {
+//	    // handle error
+//	}
+//	fmt.Println("Live URLs:", stats.Live)
+func (coder *Coder) GetStats(ctx context.Context) (models.GetStatsResponse, error) {
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-statsDays)
+	stats, err := coder.storage.GetStats(ctx, since, statsTopSize)
+	if err != nil {
+		return models.GetStatsResponse{}, err
+	}
+
+	response := models.GetStatsResponse{
+		StorageStats:  stats,
+		DeletionQueue: coder.DeletionQueueLen(),
+	}
+	if cache, ok := coder.CacheStats(); ok {
+		response.Cache = &cache
+	}
+	return response, nil
+}
+
+// CacheStats returns the counters of the storage cache.
+// The second value reports whether the storage is wrapped with a cache (see CacheStatsProvider).
+func (coder *Coder) CacheStats() (models.CacheStats, bool) {
+	provider, ok := coder.storage.(CacheStatsProvider)
+	if !ok {
+		return models.CacheStats{}, false
+	}
+	return provider.CacheStats(), true
 }
 
 // DeleteUrls deletes multiple URLs associated with the given codes and user ID.
 // It sends a message to the rmvUrlsChan for each code to be deleted,
 // triggering the actual deletion process in the background.
-// The userID is printed to the console for debugging purposes.
 // This method returns nil as there is no error handling in this implementation.
 func (coder *Coder) DeleteUrls(codes []string, userID int) error {
-	fmt.Println("userID: " + strconv.Itoa(userID))
 	for _, code := range codes {
+		coder.pendingDeletions.Add(1)
 		coder.rmvUrlsChan <- models.RmvUrlsMsg{UserID: userID, Code: code}
 	}
 
 	return nil
 }
 
+// Restore clears the deleted flag of the links with the given codes owned by the user,
+// if they were deleted within the restore period (see WithRestorePeriod).
+// It returns the codes of the restored links; the unknown codes, the links of other users,
+// the links which are not deleted and the links deleted before the period are skipped.
+// The deletion is asynchronous (see DeleteUrls), so a link whose deletion is still queued
+// is not deleted yet and cannot be restored.
+// Example usage:
+//
+//	restored, err := coder.Restore(ctx, []string{"abc123"}, userID)
+func (coder *Coder) Restore(ctx context.Context, codes []string, userID int) ([]string, error) {
+	if len(codes) == 0 || coder.restorePeriod <= 0 {
+		return []string{}, nil
+	}
+
+	messages := make([]models.RmvUrlsMsg, 0, len(codes))
+	for _, code := range codes {
+		messages = append(messages, models.RmvUrlsMsg{UserID: userID, Code: code})
+	}
+	restored, err := coder.storage.Restore(ctx, messages, time.Now().Add(-coder.restorePeriod))
+	if err != nil {
+		return nil, err
+	}
+	if restored == nil {
+		restored = []string{}
+	}
+	return restored, nil
+}
+
+// DeletionQueueLen returns the number of deletion messages which are not applied to the storage yet:
+// the messages waiting in the queue of the Coder and the batch collected by the background deletion.
+func (coder *Coder) DeletionQueueLen() int {
+	return int(coder.pendingDeletions.Load())
+}
+
 func (coder *Coder) rmvUrls() {
 	ticker := time.NewTicker(10 * time.Second)
 
@@ -183,9 +503,10 @@ func (coder *Coder) rmvUrls() {
 			}
 			err := coder.storage.SoftDelete(context.TODO(), messages)
 			if err != nil {
-				fmt.Print(err)
+				coder.logger.Error("soft delete", err)
 				continue
 			}
+			coder.pendingDeletions.Add(-int64(len(messages)))
 			messages = nil
 		}
 	}
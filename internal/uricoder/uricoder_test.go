@@ -2,12 +2,14 @@ package uricoder
 
 import (
 	"context"
-	"errors"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
 	"github.com/yury-kuznetsov/shortener/internal/storage/file"
 	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
 )
@@ -20,9 +22,9 @@ func TestToURI(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	code1, _ := s.Set(ctx, "https://google.com", 0)
-	code2, _ := s.Set(ctx, "https://ya.ru", 0)
-	code3, _ := s.Set(ctx, "", 0)
+	code1, _ := s.Set(ctx, models.Link{Code: "code1", URI: "https://google.com"})
+	code2, _ := s.Set(ctx, models.Link{Code: "code2", URI: "https://ya.ru"})
+	code3, _ := s.Set(ctx, models.Link{Code: "code3", URI: ""})
 
 	tests := []struct {
 		name string
@@ -46,7 +48,7 @@ func TestToURI(t *testing.T) {
 			name: "not found",
 			code: code3,
 			uri:  "",
-			err:  errors.New("URI not found"),
+			err:  models.ErrNotFound,
 		},
 	}
 
@@ -70,6 +72,7 @@ func TestToCode(t *testing.T) {
 	tests := []struct {
 		name string
 		uri  string
+		opts models.LinkOptions
 		code string
 		err  error
 	}{
@@ -86,12 +89,18 @@ func TestToCode(t *testing.T) {
 		{
 			name: "incorrect",
 			uri:  "",
-			err:  errors.New("incorrect URI"),
+			err:  models.ErrInvalidURL,
+		},
+		{
+			name: "expired",
+			uri:  "https://ya.ru",
+			opts: models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)},
+			err:  ErrIncorrectExpiration,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			code, err := coder.ToCode(ctx, test.uri, 0)
+			code, err := coder.ToCode(ctx, test.uri, 0, test.opts)
 			if code != "" {
 				uri, _ := coder.ToURI(ctx, code, 0)
 				assert.Equal(t, uri, test.uri)
@@ -101,9 +110,47 @@ func TestToCode(t *testing.T) {
 	}
 }
 
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		err   error
+	}{
+		{alias: "spring-sale", err: nil},
+		{alias: "Sale_2024", err: nil},
+		{alias: "ab", err: ErrIncorrectAlias},
+		{alias: strings.Repeat("a", aliasMaxLength+1), err: ErrIncorrectAlias},
+		{alias: "sale/2024", err: ErrIncorrectAlias},
+		{alias: "распродажа", err: ErrIncorrectAlias},
+		{alias: "ping", err: ErrIncorrectAlias},
+		{alias: "API", err: ErrIncorrectAlias},
+		{alias: "debug", err: ErrIncorrectAlias},
+	}
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			assert.Equal(t, test.err, ValidateAlias(test.alias))
+		})
+	}
+}
+
+func TestToCodeAlias(t *testing.T) {
+	coder := NewCoder(memory.NewStorage())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code, err := coder.ToCode(ctx, "https://ya.ru", 0, models.LinkOptions{Alias: "spring-sale"})
+	require.NoError(t, err)
+	assert.Equal(t, "spring-sale", code)
+
+	_, err = coder.ToCode(ctx, "https://google.com", 0, models.LinkOptions{Alias: "spring-sale"})
+	assert.ErrorIs(t, err, models.ErrCodeTaken)
+
+	_, err = coder.ToCode(ctx, "https://google.com", 0, models.LinkOptions{Alias: "ping"})
+	assert.ErrorIs(t, err, ErrIncorrectAlias)
+}
+
 func BenchmarkToURI(b *testing.B) {
 	s := memory.NewStorage()
-	code, _ := s.Set(context.Background(), "https://ya.ru", 0)
+	code, _ := s.Set(context.Background(), models.Link{Code: "code", URI: "https://ya.ru"})
 	coder := NewCoder(s)
 	b.ResetTimer()
 
@@ -118,6 +165,80 @@ func BenchmarkToCode(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, _ = coder.ToCode(context.Background(), "https://ya.ru", 0)
+		_, _ = coder.ToCode(context.Background(), "https://ya.ru", 0, models.LinkOptions{})
+	}
+}
+
+// Параллельные версии бенчмарков показывают, как хранилище масштабируется
+// с ростом числа ядер, например: go test -bench Parallel -cpu 1,2,4,8
+func BenchmarkToURIParallel(b *testing.B) {
+	s := memory.NewStorage()
+	codes := make([]string, 1024)
+	for i := range codes {
+		codes[i], _ = s.Set(context.Background(), models.Link{Code: strconv.Itoa(i), URI: "https://ya.ru/" + strconv.Itoa(i)})
+	}
+	coder := NewCoder(s)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = coder.ToURI(context.Background(), codes[i%len(codes)], 0)
+			i++
+		}
+	})
+}
+
+func BenchmarkToCodeParallel(b *testing.B) {
+	s := memory.NewStorage()
+	coder := NewCoder(s)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = coder.ToCode(context.Background(), "https://ya.ru", 0, models.LinkOptions{})
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s, WithRestorePeriod(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, code := range []string{"code1", "code2"} {
+		_, err := s.Set(ctx, models.Link{Code: code, URI: "https://site.com/" + code, UserID: 1})
+		require.NoError(t, err)
 	}
+	require.NoError(t, s.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}))
+	s.SoftDeleteAt([]models.RmvUrlsMsg{{UserID: 1, Code: "code2"}}, time.Now().Add(-2*time.Hour))
+
+	restored, err := coder.Restore(ctx, []string{"code1", "code2"}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code1"}, restored)
+
+	uri, err := coder.ToURI(ctx, "code1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://site.com/code1", uri)
+
+	// без периода восстановления ссылки не восстанавливаются
+	require.NoError(t, s.SoftDelete(ctx, []models.RmvUrlsMsg{{UserID: 1, Code: "code1"}}))
+	restored, err = NewCoder(s, WithRestorePeriod(0)).Restore(ctx, []string{"code1"}, 1)
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+}
+
+func TestDeletionQueueLen(t *testing.T) {
+	coder := NewCoder(memory.NewStorage())
+
+	require.NoError(t, coder.DeleteUrls([]string{"code1", "code2"}, 1))
+
+	// фоновое удаление сразу забирает сообщения из канала в пакет,
+	// но до записи в хранилище они остаются в очереди
+	require.Eventually(t, func() bool {
+		return len(coder.rmvUrlsChan) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, coder.DeletionQueueLen())
 }
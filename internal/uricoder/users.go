@@ -0,0 +1,178 @@
+package uricoder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// anonymousPurgeInterval is the interval of removing the expired anonymous users from the storage.
+const anonymousPurgeInterval = time.Hour
+
+// The limits of the credentials of a user.
+// The password is limited by the bcrypt algorithm, which uses at most 72 bytes of it.
+const (
+	MaxLoginLength    = 64
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+var (
+	// ErrIncorrectLogin is returned when the login is empty, too long or contains spaces.
+	ErrIncorrectLogin = models.NewError("incorrect login", models.ErrInvalidArgument)
+
+	// ErrIncorrectPassword is returned when the password is too short or too long.
+	ErrIncorrectPassword = models.NewError("incorrect password", models.ErrInvalidArgument)
+
+	// ErrWrongCredentials is returned when there is no user with the login and the password.
+	ErrWrongCredentials = models.NewError("wrong login or password", models.ErrUnauthorized)
+)
+
+// WithPasswordCost sets the cost of the bcrypt hashes of the passwords.
+// By default, the Coder uses bcrypt.DefaultCost.
+func WithPasswordCost(cost int) Option {
+	return func(coder *Coder) {
+		coder.passwordCost = cost
+	}
+}
+
+// Register creates the user with the given credentials and returns the ID of the user.
+// The password is stored as a bcrypt hash. The login is case-sensitive.
+// It returns ErrIncorrectLogin or ErrIncorrectPassword if the credentials are incorrect
+// and models.ErrLoginTaken if the login is already registered.
+// Example usage:
+//
+//	userID, err := coder.Register(ctx, models.Credentials{Login: "alice", Password: "secret-pass"})
+//	if errors.Is(err, models.ErrLoginTaken) {
+//	    // ask for another login
+//	}
+func (coder *Coder) Register(ctx context.Context, credentials models.Credentials) (int, error) {
+	if err := validateCredentials(credentials); err != nil {
+		return 0, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), coder.passwordCost)
+	if err != nil {
+		return 0, err
+	}
+	return coder.storage.CreateUser(ctx, credentials.Login, string(hash))
+}
+
+// Login checks the credentials and returns the ID of the user.
+// It returns ErrWrongCredentials both for an unknown login and for a wrong password,
+// so the callers cannot find out which logins are registered. For an unknown login the password
+// is compared with a dummy hash, so the response time does not reveal it either.
+// Example usage:
+//
+//	userID, err := coder.Login(ctx, credentials)
+//	if errors.Is(err, models.ErrUnauthorized) {
+//	    // ask for the credentials again
+//	}
+func (coder *Coder) Login(ctx context.Context, credentials models.Credentials) (int, error) {
+	user, err := coder.storage.GetUser(ctx, credentials.Login)
+	if errors.Is(err, models.ErrNotFound) {
+		// сравнение занимает столько же времени, сколько и для существующего логина
+		_ = bcrypt.CompareHashAndPassword(coder.passwordDummyHash(), []byte(credentials.Password))
+		return 0, ErrWrongCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password))
+	if err != nil {
+		return 0, ErrWrongCredentials
+	}
+	return user.ID, nil
+}
+
+// passwordDummyHash returns the bcrypt hash of a random password with the cost of the Coder.
+// It is generated once, on the first login with an unknown login.
+func (coder *Coder) passwordDummyHash() []byte {
+	coder.dummyHashOnce.Do(func() {
+		password := make([]byte, MinPasswordLength)
+		_, _ = rand.Read(password)
+		coder.dummyHash, _ = bcrypt.GenerateFromPassword(password, coder.passwordCost)
+	})
+	return coder.dummyHash
+}
+
+// CreateAnonymous stores a new anonymous user with a random UUID, which is valid for the anonymous lifetime
+// of the Coder (see WithAnonymousLifetime), and returns it.
+// Example usage:
+//
+//	user, err := coder.CreateAnonymous(ctx)
+//	if err != nil {
+//	    // handle error
+//	}
+//	token, err := auth.NewToken(user.ID)
+func (coder *Coder) CreateAnonymous(ctx context.Context) (models.User, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return models.User{}, err
+	}
+	now := time.Now()
+	user := models.User{UUID: uuid, CreatedAt: now, ExpiresAt: now.Add(coder.anonymousLifetime)}
+	if user.ID, err = coder.storage.CreateAnonymousUser(ctx, user.UUID, user.ExpiresAt); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// Claim gives all the links of the anonymous user to the registered user and removes the anonymous user,
+// so the links created before the registration (or the login) are not lost.
+// It returns the number of the given links and models.ErrNotFound if the anonymous user is unknown,
+// expired or already claimed.
+func (coder *Coder) Claim(ctx context.Context, anonymousID int, userID int) (int, error) {
+	if anonymousID == userID {
+		return 0, models.ErrNotFound
+	}
+	return coder.storage.ClaimLinks(ctx, anonymousID, userID)
+}
+
+// PurgeAnonymous removes the anonymous users whose lifetime is over and returns their number.
+// Their links are kept, but can no longer be claimed. It is called periodically in the background.
+func (coder *Coder) PurgeAnonymous(ctx context.Context) (int, error) {
+	return coder.storage.PurgeAnonymousUsers(ctx, time.Now())
+}
+
+func (coder *Coder) purgeAnonymous() {
+	ticker := time.NewTicker(anonymousPurgeInterval)
+
+	for range ticker.C {
+		// при ошибке попробуем в следующий раз
+		if _, err := coder.PurgeAnonymous(context.TODO()); err != nil {
+			coder.logger.Error("purge anonymous users", err)
+		}
+	}
+}
+
+// newUUID returns a random UUID (version 4) in the canonical text form.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// validateCredentials checks the credentials of a new user.
+func validateCredentials(credentials models.Credentials) error {
+	login := credentials.Login
+	if login == "" || utf8.RuneCountInString(login) > MaxLoginLength || strings.IndexFunc(login, unicode.IsSpace) >= 0 {
+		return ErrIncorrectLogin
+	}
+	if len(credentials.Password) < MinPasswordLength || len(credentials.Password) > MaxPasswordLength {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
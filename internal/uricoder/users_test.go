@@ -0,0 +1,135 @@
+package uricoder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yury-kuznetsov/shortener/internal/models"
+	"github.com/yury-kuznetsov/shortener/internal/storage/memory"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegister(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s, WithPasswordCost(bcrypt.MinCost))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name        string
+		credentials models.Credentials
+		err         error
+	}{
+		{name: "registered", credentials: models.Credentials{Login: "alice", Password: "password1"}},
+		{name: "login taken", credentials: models.Credentials{Login: "alice", Password: "password2"}, err: models.ErrLoginTaken},
+		{name: "empty login", credentials: models.Credentials{Password: "password1"}, err: ErrIncorrectLogin},
+		{name: "login with space", credentials: models.Credentials{Login: "al ice", Password: "password1"}, err: ErrIncorrectLogin},
+		{
+			name:        "long login",
+			credentials: models.Credentials{Login: strings.Repeat("a", MaxLoginLength+1), Password: "password1"},
+			err:         ErrIncorrectLogin,
+		},
+		{name: "short password", credentials: models.Credentials{Login: "bob", Password: "short"}, err: ErrIncorrectPassword},
+		{
+			name:        "long password",
+			credentials: models.Credentials{Login: "bob", Password: strings.Repeat("p", MaxPasswordLength+1)},
+			err:         ErrIncorrectPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := coder.Register(ctx, tt.credentials)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, userID, models.FirstUserID)
+		})
+	}
+
+	// пароль хранится только в виде хеша
+	user, err := s.GetUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.NotContains(t, user.PasswordHash, "password1")
+}
+
+func TestLogin(t *testing.T) {
+	coder := NewCoder(memory.NewStorage(), WithPasswordCost(bcrypt.MinCost))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := coder.Register(ctx, models.Credentials{Login: "alice", Password: "password1"})
+	require.NoError(t, err)
+
+	// с другого устройства пользователь получает тот же идентификатор
+	loggedID, err := coder.Login(ctx, models.Credentials{Login: "alice", Password: "password1"})
+	require.NoError(t, err)
+	assert.Equal(t, userID, loggedID)
+
+	_, err = coder.Login(ctx, models.Credentials{Login: "alice", Password: "password2"})
+	assert.ErrorIs(t, err, ErrWrongCredentials)
+	_, err = coder.Login(ctx, models.Credentials{Login: "bob", Password: "password1"})
+	assert.ErrorIs(t, err, ErrWrongCredentials)
+	assert.ErrorIs(t, err, models.ErrUnauthorized)
+
+	// для неизвестного логина пароль сравнивается с хешем той же стоимости
+	cost, err := bcrypt.Cost(coder.passwordDummyHash())
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+}
+
+func TestPurgeAnonymous(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s, WithPasswordCost(bcrypt.MinCost), WithAnonymousLifetime(time.Nanosecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymous, err := coder.CreateAnonymous(ctx)
+	require.NoError(t, err)
+	_, err = coder.ToCode(ctx, "https://google.com", anonymous.ID, models.LinkOptions{})
+	require.NoError(t, err)
+
+	purged, err := coder.PurgeAnonymous(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Zero(t, s.UsersLen())
+
+	// ссылки удаленного пользователя остаются
+	page, err := coder.GetHistory(ctx, anonymous.ID, models.HistoryQuery{}, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Links, 1)
+}
+
+func TestClaim(t *testing.T) {
+	s := memory.NewStorage()
+	coder := NewCoder(s, WithPasswordCost(bcrypt.MinCost), WithAnonymousLifetime(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	anonymous, err := coder.CreateAnonymous(ctx)
+	require.NoError(t, err)
+	assert.Len(t, anonymous.UUID, 36)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), anonymous.ExpiresAt, time.Minute)
+
+	_, err = coder.ToCode(ctx, "https://google.com", anonymous.ID, models.LinkOptions{})
+	require.NoError(t, err)
+	userID, err := coder.Register(ctx, models.Credentials{Login: "alice", Password: "password1"})
+	require.NoError(t, err)
+
+	claimed, err := coder.Claim(ctx, anonymous.ID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+
+	// пользователь не может передать ссылки сам себе
+	_, err = coder.Claim(ctx, userID, userID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}